@@ -1,13 +1,54 @@
 package chanrpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/log"
 	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// 异步调用超时，超时时回调收到该错误
+var ErrTimeout = errors.New("chanrpc call timeout")
+
+// rpc服务器已关闭
+var ErrClosed = errors.New("chanrpc server closed")
+
+// 函数或回调执行时的panic，返回给调用方并记录日志
+type PanicError struct {
+	ID    string      // 函数id
+	Value interface{} // panic的值
+	Stack []byte      // panic时的调用栈，conf.LenStackBuf为0时为空
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("function id %v: panic: %v", e.ID, e.Value)
+}
+
+// 在recover之后调用，r为recover的返回值
+func newPanicError(id interface{}, r interface{}) *PanicError {
+	e := &PanicError{ID: idName(id), Value: r}
+	if conf.LenStackBuf > 0 {
+		buf := make([]byte, conf.LenStackBuf)
+		l := runtime.Stack(buf, false)
+		e.Stack = buf[:l]
+	}
+	return e
+}
+
+// 记录panic日志，包括调用栈
+func logPanic(e *PanicError) {
+	if len(e.Stack) > 0 {
+		log.Error("%v: %s", e, e.Stack)
+	} else {
+		log.Error("%v", e)
+	}
+}
+
 // one server per goroutine (goroutine not safe)
 // one client per goroutine (goroutine not safe)
 //rpc服务器定义
@@ -20,14 +61,46 @@ type Server struct {
 	// func(args []interface{}) []interface{}
 	functions map[interface{}]interface{} // id->func映射
 	ChanCall  chan *CallInfo              // 调用管道（用于传递调用信息）
+	Name      string                      // 服务名，用于统计队列长度和执行耗时，为空时不输出到metrics
+
+	statsMutex sync.Mutex
+	stats      map[interface{}]*FuncStats // id->调用统计
+	highWater  int32                      // ChanCall出现过的最大长度，原子读写
 }
 
 //调用信息
 type CallInfo struct {
+	id      interface{}   // 函数id
 	f       interface{}   // 函数
 	args    []interface{} // 参数
 	chanRet chan *RetInfo // 返回值管道，用于传输返回值，可能是同步返回值管道也可能是异步返回值管道
 	cb      interface{}   // 回调
+
+	ctx      context.Context // 同步调用的上下文，取消或超时后不再执行
+	deadline time.Time       // 异步调用的截止时间，超过后不再执行
+	timeout  *asynTimeout    // 异步调用的超时状态
+}
+
+// 带截止时间的异步调用，超时和返回结果只有先到的生效
+type asynTimeout struct {
+	done  int32
+	timer *time.Timer
+}
+
+// 第一次调用时返回true
+func (t *asynTimeout) finish() bool {
+	return atomic.CompareAndSwapInt32(&t.done, 0, 1)
+}
+
+// 调用在执行前已取消或超时
+func (ci *CallInfo) expired() error {
+	if ci.ctx != nil {
+		return ci.ctx.Err()
+	}
+	if !ci.deadline.IsZero() && !time.Now().Before(ci.deadline) {
+		return ErrTimeout
+	}
+	return nil
 }
 
 //返回信息
@@ -35,6 +108,7 @@ type RetInfo struct {
 	// nil
 	// interface{}
 	// []interface{}
+	id  interface{} // 函数id
 	ret interface{} // 返回值
 	err error       // 错误
 	// callback:
@@ -49,7 +123,7 @@ type Client struct {
 	s               *Server       // rpc服务器引用
 	chanSyncRet     chan *RetInfo // 同步函数结果返回管道，大小为1
 	ChanAsynRet     chan *RetInfo // 异步函数结果返回管道，大小为n
-	pendingAsynCall int           // 待处理的异步调用
+	pendingAsynCall int32         // 待处理的异步调用，原子读写，Pending可以在其他goroutine中调用
 }
 
 // 初始化rpc服务器
@@ -57,6 +131,10 @@ func NewServer(l int) *Server {
 	s := new(Server)                                // 初始化Server结构体
 	s.functions = make(map[interface{}]interface{}) // 初始化functions属性
 	s.ChanCall = make(chan *CallInfo, l)            // 初始化ChanCall
+	s.stats = make(map[interface{}]*FuncStats)
+	serversMutex.Lock()
+	servers = append(servers, s)
+	serversMutex.Unlock()
 	return s
 }
 
@@ -72,13 +150,18 @@ func assert(i interface{}) []interface{} {
 // you must call the function before calling Open and Go
 // 注册f(函数)
 // 注册函数到Server实例的fuctions属性中
+// f也可以是强类型函数，例如func(msg *msg.Login, a gate.Agent)，注册时检查签名，调用时按参数类型转换
 func (s *Server) Register(id interface{}, f interface{}) {
 	switch f.(type) { //判断f的类型
 	case func([]interface{}): //参数是切片，值任意。无返回值
 	case func([]interface{}) interface{}: //参数是切片，值任意。返回值为一个任意值
 	case func([]interface{}) []interface{}: //参数是切片，返回值也是切片，值均为任意
 	default:
-		panic(fmt.Sprintf("function id %v: definition of function is invalid", id)) //id对应的函数定义非法
+		var err error
+		f, err = adapt(id, f) //强类型函数
+		if err != nil {
+			panic(err.Error()) //id对应的函数定义非法
+		}
 	}
 
 	if _, ok := s.functions[id]; ok { //判断映射是否存在
@@ -94,14 +177,21 @@ func (s *Server) ret(ci *CallInfo, ri *RetInfo) (err error) {
 	if ci.chanRet == nil { //返回管道不能为空
 		return
 	}
+	if ci.timeout != nil {
+		if !ci.timeout.finish() { // 已超时，回调已收到超时错误
+			return
+		}
+		ci.timeout.timer.Stop()
+	}
 
 	//延迟捕获异常
 	defer func() {
 		if r := recover(); r != nil {
-			err = r.(error)
+			err = newPanicError(ci.id, r)
 		}
 	}()
 
+	ri.id = ci.id
 	ri.cb = ci.cb    //将回调函数保存到返回信息中
 	ci.chanRet <- ri //将返回信息发送到返回值管道中
 	return
@@ -113,15 +203,10 @@ func (s *Server) exec(ci *CallInfo) (err error) {
 	//延迟处理异常
 	defer func() {
 		if r := recover(); r != nil {
-			if conf.LenStackBuf > 0 {
-				buf := make([]byte, conf.LenStackBuf)
-				l := runtime.Stack(buf, false)
-				err = fmt.Errorf("%v: %s", r, buf[:l])
-			} else {
-				err = fmt.Errorf("%v", r)
-			}
-
-			s.ret(ci, &RetInfo{err: fmt.Errorf("%v", r)})
+			e := newPanicError(ci.id, r)
+			s.recordPanic(ci)
+			s.ret(ci, &RetInfo{err: e}) // 调用方收到PanicError
+			err = e
 		}
 	}()
 
@@ -143,8 +228,26 @@ func (s *Server) exec(ci *CallInfo) (err error) {
 
 // rpc服务器实例根据调用信息CallInfo调用相应方法
 func (s *Server) Exec(ci *CallInfo) {
+	// 兜底，保证调用Exec的模块goroutine不会退出
+	defer func() {
+		if r := recover(); r != nil {
+			logPanic(newPanicError(ci.id, r))
+		}
+	}()
+
+	s.observeQueue()
+	// 排队期间已取消或超时的调用直接丢弃
+	if err := ci.expired(); err != nil {
+		s.recordExpired(ci)
+		s.ret(ci, &RetInfo{err: err})
+		return
+	}
+	start := time.Now()
 	err := s.exec(ci)
-	if err != nil {
+	s.record(ci, time.Since(start), err)
+	if e, ok := err.(*PanicError); ok {
+		logPanic(e)
+	} else if err != nil {
 		log.Error("%v", err)
 	}
 }
@@ -162,7 +265,9 @@ func (s *Server) Go(id interface{}, args ...interface{}) {
 		recover()
 	}()
 
+	// 服务器已关闭时丢弃
 	s.ChanCall <- &CallInfo{ //将调用消息传给rpc服务器的调用管道ChanCall
+		id: id,
 		f: f,
 		args: args,
 	}
@@ -183,6 +288,26 @@ func (s *Server) CallN(id interface{}, args ...interface{}) ([]interface{}, erro
 	return s.Open(0).CallN(id, args...)
 }
 
+// goroutine safe
+func (s *Server) Call0Ctx(ctx context.Context, id interface{}, args ...interface{}) error {
+	return s.Open(0).Call0Ctx(ctx, id, args...)
+}
+
+// goroutine safe
+func (s *Server) Call0Wait(ctx context.Context, id interface{}, args ...interface{}) error {
+	return s.Open(0).Call0Wait(ctx, id, args...)
+}
+
+// goroutine safe
+func (s *Server) Call1Ctx(ctx context.Context, id interface{}, args ...interface{}) (interface{}, error) {
+	return s.Open(0).Call1Ctx(ctx, id, args...)
+}
+
+// goroutine safe
+func (s *Server) CallNCtx(ctx context.Context, id interface{}, args ...interface{}) ([]interface{}, error) {
+	return s.Open(0).CallNCtx(ctx, id, args...)
+}
+
 //关闭RPC服务器
 func (s *Server) Close() {
 	close(s.ChanCall) //关闭管道调用
@@ -190,7 +315,7 @@ func (s *Server) Close() {
 	//遍历所有未处理完的消息，返回错误消息(rpc server已关闭)
 	for ci := range s.ChanCall {
 		s.ret(ci, &RetInfo{
-			err: errors.New("chanrpc server closed"),
+			err: ErrClosed,
 		})
 	}
 }
@@ -219,14 +344,20 @@ func (c *Client) Attach(s *Server) {
 
 // 发起调用
 func (c *Client) call(ci *CallInfo, block bool) (err error) {
-	//捕获异常
+	//只有向已关闭的ChanCall发送时会panic
 	defer func() {
 		if r := recover(); r != nil {
-			err = r.(error)
+			err = ErrClosed
 		}
 	}()
 
-	if block { // 阻塞的。当管道满时，阻塞
+	if block && ci.ctx != nil { // 阻塞到管道有空位或ctx取消
+		select {
+		case c.s.ChanCall <- ci:
+		case <-ci.ctx.Done():
+			err = ci.ctx.Err()
+		}
+	} else if block { // 阻塞的。当管道满时，阻塞
 		c.s.ChanCall <- ci // 将调用消息通过管道传输到rpc服务器
 	} else { // 非阻塞的。当管道满时，返回管道已满错误，利用default特性检测chan是否已满
 		select {
@@ -280,6 +411,7 @@ func (c *Client) Call0(id interface{}, args ...interface{}) error {
 	}
 
 	err = c.call(&CallInfo{ // 发起调用
+		id: id,
 		f: f,
 		args: args,
 		chanRet: c.chanSyncRet, // 同步函数结果返回管道
@@ -301,6 +433,7 @@ func (c *Client) Call1(id interface{}, args ...interface{}) (interface{}, error)
 	}
 
 	err = c.call(&CallInfo{ //发起调用
+		id: id,
 		f: f,
 		args: args,
 		chanRet: c.chanSyncRet,
@@ -322,6 +455,7 @@ func (c *Client) CallN(id interface{}, args ...interface{}) ([]interface{}, erro
 	}
 
 	err = c.call(&CallInfo{ //发起调用
+		id: id,
 		f: f,
 		args: args,
 		chanRet: c.chanSyncRet,
@@ -334,22 +468,131 @@ func (c *Client) CallN(id interface{}, args ...interface{}) ([]interface{}, erro
 	return assert(ri.ret), ri.err //返回返回值字段（先转化类型）和错误字段
 }
 
+// 带上下文的同步调用
+// 每次调用使用新的返回管道，超时后迟到的结果写入后丢弃，不影响之后的调用
+// drop为false时ctx只限制等待结果的时间，调用一定会执行
+func (c *Client) callCtx(ctx context.Context, id interface{}, n int, args []interface{}, drop bool) (*RetInfo, error) {
+	f, err := c.f(id, n)
+	if err != nil {
+		return nil, err
+	}
+
+	chanRet := make(chan *RetInfo, 1)
+	ci := &CallInfo{
+		id:      id,
+		f:       f,
+		args:    args,
+		chanRet: chanRet,
+	}
+	if drop {
+		ci.ctx = ctx
+		err = c.call(ci, true)
+	} else {
+		err = c.callWait(ctx, ci)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case ri := <-chanRet:
+		return ri, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// 阻塞到管道有空位或ctx取消
+// 取消时在新的goroutine中继续发送，调用仍会执行，不阻塞调用方
+func (c *Client) callWait(ctx context.Context, ci *CallInfo) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = ErrClosed
+		}
+	}()
+
+	select {
+	case c.s.ChanCall <- ci:
+		return nil
+	case <-ctx.Done():
+	}
+	log.Error("function id %v: chanrpc channel full, call will be sent later", ci.id)
+	go c.call(ci, true)
+	return ctx.Err()
+}
+
+// 同步调用0，ctx取消或超时时返回ctx.Err()
+func (c *Client) Call0Ctx(ctx context.Context, id interface{}, args ...interface{}) error {
+	ri, err := c.callCtx(ctx, id, 0, args, true)
+	if err != nil {
+		return err
+	}
+	return ri.err
+}
+
+// 同步调用0，ctx限制等待入队和结果的时间，超时后调用仍会执行，用于不能丢弃的调用
+func (c *Client) Call0Wait(ctx context.Context, id interface{}, args ...interface{}) error {
+	ri, err := c.callCtx(ctx, id, 0, args, false)
+	if err != nil {
+		return err
+	}
+	return ri.err
+}
+
+// 同步调用1，ctx取消或超时时返回ctx.Err()
+func (c *Client) Call1Ctx(ctx context.Context, id interface{}, args ...interface{}) (interface{}, error) {
+	ri, err := c.callCtx(ctx, id, 1, args, true)
+	if err != nil {
+		return nil, err
+	}
+	return ri.ret, ri.err
+}
+
+// 同步调用N，ctx取消或超时时返回ctx.Err()
+func (c *Client) CallNCtx(ctx context.Context, id interface{}, args ...interface{}) ([]interface{}, error) {
+	ri, err := c.callCtx(ctx, id, 2, args, true)
+	if err != nil {
+		return nil, err
+	}
+	return assert(ri.ret), ri.err
+}
+
 //发起异步调用(内部的)
-func (c *Client) asynCall(id interface{}, args []interface{}, cb interface{}, n int) {
+func (c *Client) asynCall(id interface{}, args []interface{}, cb interface{}, n int, timeout time.Duration) {
 	f, err := c.f(id, n) // 获得函数
 	if err != nil {
-		c.ChanAsynRet <- &RetInfo{err: err, cb: cb}
+		c.ChanAsynRet <- &RetInfo{id: id, err: err, cb: cb}
 		return
 	}
 
-	err = c.call(&CallInfo{ // 写入rpc服务的调用管道
+	ci := &CallInfo{ // 写入rpc服务的调用管道
+		id: id,
 		f: f,
 		args: args,
 		chanRet: c.ChanAsynRet, //异步返回管道
 		cb: cb,
-	}, false)
+	}
+	if timeout > 0 {
+		// 超时时由定时器返回超时错误，之后服务端的结果被丢弃
+		t := new(asynTimeout)
+		t.timer = time.AfterFunc(timeout, func() {
+			if t.finish() {
+				c.ChanAsynRet <- &RetInfo{id: id, err: ErrTimeout, cb: cb}
+			}
+		})
+		ci.deadline = time.Now().Add(timeout)
+		ci.timeout = t
+	}
+
+	err = c.call(ci, false)
 	if err != nil {
-		c.ChanAsynRet <- &RetInfo{err: err, cb: cb} // 如果异常返回错误和回调函数
+		if ci.timeout != nil {
+			if !ci.timeout.finish() {
+				return
+			}
+			ci.timeout.timer.Stop()
+		}
+		c.ChanAsynRet <- &RetInfo{id: id, err: err, cb: cb} // 如果异常返回错误和回调函数
 		return
 	}
 }
@@ -357,6 +600,12 @@ func (c *Client) asynCall(id interface{}, args []interface{}, cb interface{}, n
 // 发起异步调用(导出的)
 // 异步调用，需要自己写c.Cb(<-c.ChanAsynRet)执行回调
 func (c *Client) AsynCall(id interface{}, _args ...interface{}) {
+	c.AsynCallTimeout(id, 0, _args...)
+}
+
+// 带超时的异步调用，timeout内没有返回时回调收到ErrTimeout，timeout为0时不超时
+// 超时前仍在排队的调用不会再执行
+func (c *Client) AsynCallTimeout(id interface{}, timeout time.Duration, _args ...interface{}) {
 	if len(_args) < 1 { // 检查是否提供了回调函数参数，参数个数必定大于等于1
 		panic("callback function not found")
 	}
@@ -377,26 +626,22 @@ func (c *Client) AsynCall(id interface{}, _args ...interface{}) {
 	}
 
 	// too many calls
-	if c.pendingAsynCall >= cap(c.ChanAsynRet) {
-		execCb(&RetInfo{err: errors.New("too many calls"), cb: cb})
+	if int(atomic.LoadInt32(&c.pendingAsynCall)) >= cap(c.ChanAsynRet) {
+		execCb(&RetInfo{id: id, err: errors.New("too many calls"), cb: cb})
 		return
 	}
 
-	c.asynCall(id, args, cb, n)
-	c.pendingAsynCall++ // 增加计数器，待处理的异步调用
+	c.asynCall(id, args, cb, n, timeout)
+	atomic.AddInt32(&c.pendingAsynCall, 1) // 增加计数器，待处理的异步调用
 }
 
 //执行回调
 func execCb(ri *RetInfo) {
-	defer func() { //延迟处理异常
+	defer func() { //延迟处理异常，回调的panic记录日志并计数
 		if r := recover(); r != nil {
-			if conf.LenStackBuf > 0 {
-				buf := make([]byte, conf.LenStackBuf)
-				l := runtime.Stack(buf, false)
-				log.Error("%v: %s", r, buf[:l])
-			} else {
-				log.Error("%v", r)
-			}
+			e := newPanicError(ri.id, r)
+			callbackPanics.Inc(e.ID)
+			logPanic(e)
 		}
 	}()
 
@@ -416,18 +661,23 @@ func execCb(ri *RetInfo) {
 
 // 执行rpc客户端实例中的回调函数
 func (c *Client) Cb(ri *RetInfo) {
-	c.pendingAsynCall--
+	atomic.AddInt32(&c.pendingAsynCall, -1)
 	execCb(ri)
 }
 
 // 关闭rpc客户端，执行剩余异步调用
 func (c *Client) Close() {
-	for c.pendingAsynCall > 0 {
+	for atomic.LoadInt32(&c.pendingAsynCall) > 0 {
 		c.Cb(<-c.ChanAsynRet)
 	}
 }
 
 // 返回rpc客户端实例异步调用队列是否为空
 func (c *Client) Idle() bool {
-	return c.pendingAsynCall == 0
+	return atomic.LoadInt32(&c.pendingAsynCall) == 0
+}
+
+// 待处理的异步调用数
+func (c *Client) Pending() int {
+	return int(atomic.LoadInt32(&c.pendingAsynCall))
 }
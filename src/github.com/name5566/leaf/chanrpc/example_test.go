@@ -1,9 +1,12 @@
 package chanrpc_test
 
 import (
+	"context"
 	"fmt"
 	"github.com/name5566/leaf/chanrpc"
+	"reflect"
 	"sync"
+	"time"
 )
 
 func Example() {
@@ -125,3 +128,170 @@ func Example() {
 	// 1 2 3
 	// 3
 }
+
+func ExampleClient_Call1Ctx() {
+	s := chanrpc.NewServer(10)
+	s.Register("f", func(args []interface{}) interface{} {
+		fmt.Println("executed")
+		return 1
+	})
+
+	c := s.Open(10)
+
+	// 服务端没有处理调用，超时返回
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := c.Call1Ctx(ctx, "f")
+	fmt.Println(err)
+
+	c.AsynCallTimeout("f", 10*time.Millisecond, func(ret interface{}, err error) {
+		fmt.Println(err)
+	})
+	c.Cb(<-c.ChanAsynRet)
+
+	// 超时的调用不再执行
+	s.Exec(<-s.ChanCall)
+	s.Exec(<-s.ChanCall)
+	fmt.Println(c.Idle())
+
+	// Output:
+	// context deadline exceeded
+	// chanrpc call timeout
+	// true
+}
+
+func ExampleClient_Call0Wait() {
+	s := chanrpc.NewServer(10)
+	s.Register("f", func(args []interface{}) {
+		fmt.Println("executed")
+	})
+
+	// 服务端没有处理调用，不再等待
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := s.Call0Wait(ctx, "f")
+	fmt.Println(err)
+
+	// 超时后调用仍会执行
+	s.Exec(<-s.ChanCall)
+
+	// Output:
+	// context deadline exceeded
+	// executed
+}
+
+// ChanCall已满时等待入队也受ctx限制，调用在有空位后执行
+func ExampleClient_Call0Wait_full() {
+	s := chanrpc.NewServer(1)
+	s.Register("f", func(args []interface{}) {
+		fmt.Println("executed", args[0])
+	})
+	s.Go("f", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := s.Call0Wait(ctx, "f", 2)
+	fmt.Println(err)
+
+	s.Exec(<-s.ChanCall)
+	s.Exec(<-s.ChanCall)
+
+	// Output:
+	// context deadline exceeded
+	// executed 1
+	// executed 2
+}
+
+func ExampleServer_Stats() {
+	s := chanrpc.NewServer(10)
+	s.Register("f", func(args []interface{}) {
+		if args[0].(int) < 0 {
+			panic("negative")
+		}
+	})
+
+	s.Go("f", 1)
+	s.Go("f", 2)
+	s.Go("f", -1)
+	for i := 0; i < 3; i++ {
+		s.Exec(<-s.ChanCall)
+	}
+
+	st := s.Stats()
+	fmt.Println("high water:", st.HighWater)
+	for _, fs := range st.Funcs {
+		fmt.Println(fs.ID, fs.Calls, fs.Errors, fs.Panics)
+	}
+
+	// Output:
+	// high water: 3
+	// f 3 1 1
+}
+
+// 与gate.Agent相同
+type Agent interface {
+	UserData() interface{}
+}
+
+func ExampleServer_Register() {
+	type Hello struct {
+		Name string
+	}
+
+	// gate包初始化时设置为gate.Agent
+	defer func(t reflect.Type) { chanrpc.AgentType = t }(chanrpc.AgentType)
+	chanrpc.AgentType = reflect.TypeOf((*Agent)(nil)).Elem()
+
+	s := chanrpc.NewServer(10)
+
+	// 强类型函数，参数按类型转换
+	s.Register(reflect.TypeOf(&Hello{}), func(m *Hello, a Agent) string {
+		return fmt.Sprintf("hello %v %v", m.Name, a == nil)
+	})
+
+	// 签名不匹配时注册失败
+	for _, f := range []interface{}{
+		func(m *Hello) {},
+		func(m *Hello, a interface{}) {},
+		func(m Hello, a Agent) {},
+	} {
+		func() {
+			defer func() {
+				fmt.Println(recover())
+			}()
+			s.Register(reflect.TypeOf(&Hello{}), f)
+		}()
+	}
+
+	go func() {
+		s.Exec(<-s.ChanCall)
+	}()
+	r, err := s.Call1(reflect.TypeOf(&Hello{}), &Hello{"leaf"}, nil)
+	fmt.Println(r, err)
+
+	// Output:
+	// function id *chanrpc_test.Hello: message handler func(*chanrpc_test.Hello) must have 2 arguments (msg, agent)
+	// function id *chanrpc_test.Hello: second argument of func(*chanrpc_test.Hello, interface {}) must be chanrpc_test.Agent
+	// function id *chanrpc_test.Hello: first argument of func(chanrpc_test.Hello, chanrpc_test.Agent) cannot accept message *chanrpc_test.Hello
+	// hello leaf true <nil>
+}
+
+func ExamplePanicError() {
+	s := chanrpc.NewServer(10)
+	s.Register("f", func(args []interface{}) {
+		panic("bug")
+	})
+
+	go func() {
+		s.Exec(<-s.ChanCall)
+	}()
+	err := s.Call0("f")
+	if e, ok := err.(*chanrpc.PanicError); ok {
+		fmt.Println(e.ID, e.Value)
+	}
+	fmt.Println(err)
+
+	// Output:
+	// f bug
+	// function id f: panic: bug
+}
@@ -0,0 +1,215 @@
+package chanrpc
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
+)
+
+// 已创建的rpc服务器，用于统计队列长度
+var (
+	serversMutex sync.Mutex
+	servers      []*Server
+)
+
+var rpcLog = log.Module("chanrpc")
+
+var (
+	handlerSeconds = metrics.NewHistogram("leaf_chanrpc_handler_seconds", "Time spent executing chanrpc calls.", nil, "server", "id")
+	callErrors     = metrics.NewCounter("leaf_chanrpc_errors_total", "Failed chanrpc calls, including panics.", "server", "id")
+	callPanics     = metrics.NewCounter("leaf_chanrpc_panics_total", "Panics in chanrpc handlers.", "server", "id")
+	callsExpired   = metrics.NewCounter("leaf_chanrpc_expired_total", "Calls dropped because they expired while queued.", "server", "id")
+	callbackPanics = metrics.NewCounter("leaf_chanrpc_callback_panics_total", "Panics in AsynCall callbacks.", "id")
+	_              = metrics.NewGaugeFunc("leaf_chanrpc_queue_length", "Pending calls in ChanCall.", []string{"server"},
+		func(set func(float64, ...string)) {
+			for _, s := range namedServers() {
+				set(float64(len(s.ChanCall)), s.Name)
+			}
+		})
+	_ = metrics.NewGaugeFunc("leaf_chanrpc_queue_high_water", "Highest ChanCall length seen.", []string{"server"},
+		func(set func(float64, ...string)) {
+			for _, s := range namedServers() {
+				set(float64(atomic.LoadInt32(&s.highWater)), s.Name)
+			}
+		})
+)
+
+// 单个函数的调用统计
+type FuncStats struct {
+	ID      string
+	Calls   uint64        // 执行次数
+	Errors  uint64        // 失败次数，包括panic
+	Panics  uint64        // panic次数
+	Expired uint64        // 排队期间超时被丢弃的次数
+	Total   time.Duration // 总执行时间
+	Max     time.Duration // 最长执行时间
+}
+
+// rpc服务器的统计
+type Stats struct {
+	Name      string
+	QueueLen  int // ChanCall当前长度
+	QueueCap  int
+	HighWater int // ChanCall出现过的最大长度
+	Funcs     []FuncStats
+}
+
+// 有名字的rpc服务器
+func namedServers() []*Server {
+	serversMutex.Lock()
+	defer serversMutex.Unlock()
+	var ss []*Server
+	for _, s := range servers {
+		if s.Name != "" {
+			ss = append(ss, s)
+		}
+	}
+	return ss
+}
+
+// goroutine safe
+// 所有有名字的rpc服务器的统计，函数按总执行时间从多到少排列
+func AllStats() []Stats {
+	ss := namedServers()
+	all := make([]Stats, len(ss))
+	for i, s := range ss {
+		all[i] = s.Stats()
+	}
+	return all
+}
+
+// goroutine safe
+// rpc服务器的统计，函数按总执行时间从多到少排列
+func (s *Server) Stats() Stats {
+	st := Stats{
+		Name:      s.Name,
+		QueueLen:  len(s.ChanCall),
+		QueueCap:  cap(s.ChanCall),
+		HighWater: int(atomic.LoadInt32(&s.highWater)),
+	}
+	s.statsMutex.Lock()
+	for _, fs := range s.stats {
+		st.Funcs = append(st.Funcs, *fs)
+	}
+	s.statsMutex.Unlock()
+	sort.Slice(st.Funcs, func(i, j int) bool {
+		if st.Funcs[i].Total != st.Funcs[j].Total {
+			return st.Funcs[i].Total > st.Funcs[j].Total
+		}
+		return st.Funcs[i].ID < st.Funcs[j].ID
+	})
+	return st
+}
+
+// 获取id的统计，调用时需持有s.statsMutex
+func (s *Server) funcStats(id interface{}) *FuncStats {
+	fs, ok := s.stats[id]
+	if !ok {
+		fs = &FuncStats{ID: idName(id)}
+		s.stats[id] = fs
+	}
+	return fs
+}
+
+// 记录ChanCall的最大长度，在Exec中调用，加上正在执行的调用
+func (s *Server) observeQueue() {
+	l := int32(len(s.ChanCall) + 1)
+	if l > atomic.LoadInt32(&s.highWater) {
+		atomic.StoreInt32(&s.highWater, l)
+	}
+}
+
+// 记录一次执行
+func (s *Server) record(ci *CallInfo, d time.Duration, err error) {
+	s.statsMutex.Lock()
+	fs := s.funcStats(ci.id)
+	fs.Calls++
+	fs.Total += d
+	if d > fs.Max {
+		fs.Max = d
+	}
+	if err != nil {
+		fs.Errors++
+	}
+	id := fs.ID
+	s.statsMutex.Unlock()
+
+	if s.Name != "" {
+		handlerSeconds.Observe(d.Seconds(), s.Name, id)
+		if err != nil {
+			callErrors.Inc(s.Name, id)
+		}
+	}
+
+	if conf.ChanRPCSlowCall > 0 && d >= conf.ChanRPCSlowCall {
+		rpcLog.With(
+			log.F("server", s.Name),
+			log.F("id", id),
+			log.F("args", summarize(ci.args)),
+			log.F("duration", d),
+		).Release("slow chanrpc call")
+	}
+}
+
+// 记录一次panic
+func (s *Server) recordPanic(ci *CallInfo) {
+	s.statsMutex.Lock()
+	fs := s.funcStats(ci.id)
+	fs.Panics++
+	id := fs.ID
+	s.statsMutex.Unlock()
+
+	if s.Name != "" {
+		callPanics.Inc(s.Name, id)
+	}
+}
+
+// 记录一次排队期间超时
+func (s *Server) recordExpired(ci *CallInfo) {
+	s.statsMutex.Lock()
+	fs := s.funcStats(ci.id)
+	fs.Expired++
+	id := fs.ID
+	s.statsMutex.Unlock()
+
+	if s.Name != "" {
+		callsExpired.Inc(s.Name, id)
+	}
+}
+
+// 函数id的名字，消息类型的id为类型名
+func idName(id interface{}) string {
+	if t, ok := id.(reflect.Type); ok {
+		return t.String()
+	}
+	return fmt.Sprint(id)
+}
+
+// 参数摘要，只输出类型和长度，不输出内容
+// [*msg.Login, string(len=5), int(3)]
+func summarize(args []interface{}) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		v := reflect.ValueOf(arg)
+		switch v.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map, reflect.Array, reflect.Chan:
+			parts[i] = fmt.Sprintf("%T(len=%d)", arg, v.Len())
+		case reflect.Bool,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			parts[i] = fmt.Sprintf("%T(%v)", arg, arg)
+		default:
+			parts[i] = fmt.Sprintf("%T", arg)
+		}
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
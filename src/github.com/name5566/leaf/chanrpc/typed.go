@@ -0,0 +1,90 @@
+package chanrpc
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// 消息路由函数第二个参数的类型，gate包初始化时设置为gate.Agent
+// 为nil时只检查参数个数
+var AgentType reflect.Type
+
+// 将强类型函数转换为chanrpc的函数定义，注册时检查函数签名
+//
+// func(msg *account.Login, a gate.Agent)         -> func([]interface{})
+// func(a gate.Agent) bool                        -> func([]interface{}) interface{}
+// func(userID uint) (*User, error)               -> func([]interface{}) []interface{}
+//
+// id为消息类型(reflect.Type)时为消息路由，函数必须是func(msg, gate.Agent)
+// 第一个参数能接收该类型的消息，第二个参数为AgentType
+func adapt(id interface{}, f interface{}) (interface{}, error) {
+	if f == nil {
+		return nil, fmt.Errorf("function id %v: function is nil", id)
+	}
+	v := reflect.ValueOf(f)
+	t := v.Type()
+	if t.Kind() != reflect.Func {
+		return nil, fmt.Errorf("function id %v: %v is not a function", id, t)
+	}
+	if t.IsVariadic() {
+		return nil, fmt.Errorf("function id %v: variadic function %v is not supported", id, t)
+	}
+	if msgType, ok := id.(reflect.Type); ok {
+		if t.NumIn() != 2 {
+			return nil, fmt.Errorf("function id %v: message handler %v must have 2 arguments (msg, agent)", id, t)
+		}
+		if !msgType.AssignableTo(t.In(0)) {
+			return nil, fmt.Errorf("function id %v: first argument of %v cannot accept message %v", id, t, msgType)
+		}
+		if AgentType != nil && t.In(1) != AgentType {
+			return nil, fmt.Errorf("function id %v: second argument of %v must be %v", id, t, AgentType)
+		}
+	}
+
+	call := func(args []interface{}) []reflect.Value {
+		if len(args) != t.NumIn() {
+			panic(fmt.Sprintf("function id %v: expected %v arguments, got %v", id, t.NumIn(), len(args)))
+		}
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			in[i] = argValue(id, i, arg, t.In(i))
+		}
+		return v.Call(in)
+	}
+
+	switch t.NumOut() {
+	case 0:
+		return func(args []interface{}) {
+			call(args)
+		}, nil
+	case 1:
+		return func(args []interface{}) interface{} {
+			return call(args)[0].Interface()
+		}, nil
+	default:
+		return func(args []interface{}) []interface{} {
+			out := call(args)
+			ret := make([]interface{}, len(out))
+			for i, o := range out {
+				ret[i] = o.Interface()
+			}
+			return ret
+		}, nil
+	}
+}
+
+// 参数转换为函数第i个参数的类型，不匹配时panic
+func argValue(id interface{}, i int, arg interface{}, typ reflect.Type) reflect.Value {
+	if arg == nil {
+		switch typ.Kind() {
+		case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+			return reflect.Zero(typ)
+		}
+		panic(fmt.Sprintf("function id %v: argument %v is nil, expected %v", id, i, typ))
+	}
+	v := reflect.ValueOf(arg)
+	if !v.Type().AssignableTo(typ) {
+		panic(fmt.Sprintf("function id %v: argument %v is %v, expected %v", id, i, v.Type(), typ))
+	}
+	return v
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"github.com/name5566/leaf/log"
 	"path/filepath"
+	"time"
 )
 
 type Postgre struct {
@@ -33,15 +34,36 @@ var Config struct {
 var (
 	LenStackBuf = 4096
 
+	// module
+	ModuleReadyTimeout = 30 * time.Second // 模块启动后等待就绪的时间，0为一直等待
+	ModuleStopTimeout  = 30 * time.Second // 模块收到关闭信号后等待Run退出的时间，0为一直等待
+
+	// chanrpc
+	ChanRPCSlowCall time.Duration // 执行超过该时长的调用记录日志，0为不记录
+
 	// log
-	LogLevel string
-	LogPath  string
-	LogFlag  int
+	LogLevel        string
+	LogPath         string
+	LogFlag         int
+	LogEncoder      string            // console或json，默认console
+	LogModuleLevels map[string]string // 模块单独的日志级别，例如{"network": "release"}
+	LogMaxSize      int               // 单个日志文件的最大MB数，0为不按大小切割
+	LogDaily        bool              // 是否按天切割日志文件
+	LogMaxBackups   int               // 保留的旧日志文件数，0为不限制
+	LogMaxAge       int               // 旧日志文件的保留天数，0为不限制
+	LogCompress     bool              // 是否gzip压缩旧日志文件
 
 	// console
-	ConsolePort   int
-	ConsolePrompt string = "Leaf# "
-	ProfilePath   string
+	ConsolePort     int
+	ConsolePrompt   string = "Leaf# "
+	ConsoleBind     string = "localhost" // 监听的地址，不是本机地址时需要认证
+	ConsolePassword string               // 不为空时连接后需要先认证
+	ConsoleCertFile string               // 与ConsoleKeyFile同时设置时启用TLS
+	ConsoleKeyFile  string
+	ProfilePath     string
+
+	// metrics
+	MetricsAddr string // /metrics的监听地址，没有认证，只应监听本机或内网地址，为空时不启用
 
 	// cluster
 	ListenAddr      string
@@ -0,0 +1,204 @@
+package console
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/name5566/leaf/db/redis"
+	"github.com/name5566/leaf/db/redis/token"
+	"github.com/name5566/leaf/gate"
+)
+
+func parseUserID(args []string, usage string) (uint, error) {
+	if len(args) != 1 {
+		return 0, errors.New(usage)
+	}
+	userID, err := strconv.ParseUint(args[0], 10, 0)
+	if err != nil || userID == 0 {
+		return 0, fmt.Errorf("invalid user id: %v", args[0])
+	}
+	return uint(userID), nil
+}
+
+// online
+type CommandOnline struct{}
+
+func (c *CommandOnline) name() string {
+	return "online"
+}
+
+func (c *CommandOnline) help() string {
+	return "connection and user counts of this node"
+}
+
+// 在线统计
+type onlineStats struct {
+	Connections int // 连接数，包括等待恢复会话的
+	Users       int // 已登录的用户数
+	Anonymous   int // 未登录的连接数
+	Offline     int // 连接已断开，等待恢复会话的数量
+}
+
+func (c *CommandOnline) data([]string) (interface{}, error) {
+	var st onlineStats
+	users := make(map[uint]struct{})
+	gate.RangeAgents(func(a gate.Agent) {
+		info := gate.Info(a)
+		st.Connections++
+		if info.Offline {
+			st.Offline++
+		}
+		if info.UserID == 0 {
+			st.Anonymous++
+		} else {
+			users[info.UserID] = struct{}{}
+		}
+	})
+	st.Users = len(users)
+	return st, nil
+}
+
+func (c *CommandOnline) run(args []string) string {
+	ret, _ := c.data(args)
+	st := ret.(onlineStats)
+	return fmt.Sprintf("connections: %v, users: %v, anonymous: %v, offline: %v",
+		st.Connections, st.Users, st.Anonymous, st.Offline)
+}
+
+// kick
+type CommandKick struct{}
+
+func (c *CommandKick) name() string {
+	return "kick"
+}
+
+func (c *CommandKick) help() string {
+	return "close all connections of a user, usage: kick <userID>"
+}
+
+func (c *CommandKick) data(args []string) (interface{}, error) {
+	userID, err := parseUserID(args, "usage: kick <userID>")
+	if err != nil {
+		return nil, err
+	}
+	kicked := gate.Kick(func(a gate.Agent) bool {
+		return gate.Info(a).UserID == userID
+	})
+	return map[string]int{"Kicked": kicked}, nil
+}
+
+func (c *CommandKick) run(args []string) string {
+	ret, err := c.data(args)
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("kicked %v connections", ret.(map[string]int)["Kicked"])
+}
+
+// broadcast
+type CommandBroadcast struct{}
+
+func (c *CommandBroadcast) name() string {
+	return "broadcast"
+}
+
+func (c *CommandBroadcast) help() string {
+	return "send a gate.Notice to all connections, usage: broadcast <msg>"
+}
+
+func (c *CommandBroadcast) data(args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, errors.New("usage: broadcast <msg>")
+	}
+	sent := gate.Broadcast(&gate.Notice{Msg: strings.Join(args, " ")})
+	return map[string]int{"Sent": sent}, nil
+}
+
+func (c *CommandBroadcast) run(args []string) string {
+	ret, err := c.data(args)
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("sent to %v connections", ret.(map[string]int)["Sent"])
+}
+
+// 会话id和token可以用来恢复会话和登录，只显示前8位
+func mask(id string) string {
+	if len(id) > 8 {
+		return id[:8] + "..."
+	}
+	return id
+}
+
+// sessions
+type CommandSessions struct{}
+
+func (c *CommandSessions) name() string {
+	return "sessions"
+}
+
+func (c *CommandSessions) help() string {
+	return "connections of a user on this node and login sessions in redis, usage: sessions <userID>"
+}
+
+// 用户的连接和登录session
+type userSessions struct {
+	Connections []gate.AgentInfo
+	Logins      []loginSession
+}
+
+// 登录session
+type loginSession struct {
+	Token string
+	TTL   uint // 剩余秒数
+}
+
+func (c *CommandSessions) data(args []string) (interface{}, error) {
+	userID, err := parseUserID(args, "usage: sessions <userID>")
+	if err != nil {
+		return nil, err
+	}
+	us := userSessions{Connections: []gate.AgentInfo{}, Logins: []loginSession{}}
+	gate.RangeAgents(func(a gate.Agent) {
+		if info := gate.Info(a); info.UserID == userID {
+			info.SessionID = mask(info.SessionID)
+			us.Connections = append(us.Connections, info)
+		}
+	})
+	if redis.Ping() == nil {
+		tokens, err := token.GetSessionIDs(userID)
+		if err != nil {
+			return nil, err
+		}
+		for t, ttl := range tokens {
+			us.Logins = append(us.Logins, loginSession{Token: mask(t), TTL: ttl})
+		}
+		sort.Slice(us.Logins, func(i, j int) bool {
+			return us.Logins[i].TTL > us.Logins[j].TTL
+		})
+	}
+	return us, nil
+}
+
+func (c *CommandSessions) run(args []string) string {
+	ret, err := c.data(args)
+	if err != nil {
+		return err.Error()
+	}
+	us := ret.(userSessions)
+	output := fmt.Sprintf("connections: %v", len(us.Connections))
+	for _, info := range us.Connections {
+		output += fmt.Sprintf("\r\n  %v, expired: %v", info.RemoteAddr, info.Expired.Format("2006/01/02 15:04:05"))
+		if info.SessionID != "" {
+			output += fmt.Sprintf(", session: %v, seq: %v, offline: %v", info.SessionID, info.Seq, info.Offline)
+		}
+	}
+	output += fmt.Sprintf("\r\nlogin sessions: %v", len(us.Logins))
+	for _, l := range us.Logins {
+		output += fmt.Sprintf("\r\n  %v, ttl: %vs", l.Token, l.TTL)
+	}
+	return output
+}
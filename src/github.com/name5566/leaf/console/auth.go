@@ -0,0 +1,93 @@
+package console
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/db/redis"
+	"github.com/name5566/leaf/db/redis/token"
+	"github.com/name5566/leaf/gate/user"
+	"github.com/name5566/leaf/log"
+)
+
+// 使用登录token认证时需要的权限，拥有all:all或console:all也可以
+var AuthRight = user.Right("console", "admin")
+
+// 认证失败达到该次数时断开连接
+const maxAuthFailures = 3
+
+var (
+	authRequired bool // 是否需要认证，在Init中设置
+
+	errAuthRequired    = errors.New("authentication required, usage: auth <password> | auth token <token>")
+	errAuthFailed      = errors.New("authentication failed")
+	errCommandNotFound = errors.New("command not found, try `help` for help")
+	errJSONUsage       = errors.New("usage: json on|off")
+)
+
+// 认证
+// auth <password>
+// auth token <token> 登录token，用户需要拥有AuthRight
+// 失败后等待1秒再继续读取，返回是否成功
+func (a *Agent) auth(args []string) bool {
+	if args[0] != "auth" {
+		a.reply(nil, errAuthRequired)
+		return false
+	}
+
+	var err error
+	method := "password"
+	switch {
+	case len(args) == 2:
+		err = checkPassword(args[1])
+	case len(args) == 3 && args[1] == "token":
+		method = "token"
+		err = checkToken(args[2])
+	default:
+		a.reply(nil, errAuthRequired)
+		return false
+	}
+	if err != nil {
+		a.failures++
+		log.Release("console %v %v authentication failed: %v", a.conn.RemoteAddr(), method, err)
+		time.Sleep(time.Second)
+		a.reply(nil, errAuthFailed)
+		return false
+	}
+
+	a.authed = true
+	log.Release("console %v authenticated by %v", a.conn.RemoteAddr(), method)
+	a.reply("authenticated", nil)
+	return true
+}
+
+func checkPassword(password string) error {
+	if conf.ConsolePassword == "" {
+		return errors.New("password authentication disabled")
+	}
+	if subtle.ConstantTimeCompare([]byte(password), []byte(conf.ConsolePassword)) != 1 {
+		return errors.New("wrong password")
+	}
+	return nil
+}
+
+func checkToken(t string) error {
+	if err := redis.Ping(); err != nil {
+		return err
+	}
+	userID, _, _, err := token.GetTokenValByID(t)
+	if err != nil {
+		return err
+	}
+	rights, err := token.GetSessionRights(t)
+	if err != nil {
+		return err
+	}
+	if !(user.UserData{UserID: userID, Rights: rights}).HasRight(AuthRight) {
+		return fmt.Errorf("user %v has no right %v", userID, AuthRight)
+	}
+	return nil
+}
@@ -1,13 +1,17 @@
 package console
 
 import (
+	"errors"
 	"fmt"
 	"github.com/name5566/leaf/chanrpc"
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/log"
 	"os"
 	"path"
+	"runtime"
+	"runtime/debug"
 	"runtime/pprof"
+	"sort"
 	"time"
 )
 
@@ -15,6 +19,15 @@ var commands = []Command{
 	new(CommandHelp),
 	new(CommandCPUProf),
 	new(CommandProf),
+	new(CommandLogLevel),
+	new(CommandRPCStats),
+	new(CommandOnline),
+	new(CommandKick),
+	new(CommandBroadcast),
+	new(CommandSessions),
+	new(CommandGC),
+	new(CommandMemStats),
+	new(CommandReload),
 }
 
 type Command interface {
@@ -26,6 +39,12 @@ type Command interface {
 	run(args []string) string
 }
 
+// 支持json输出的命令，json模式下输出data的结果
+type dataCommand interface {
+	// must goroutine safe
+	data(args []string) (interface{}, error)
+}
+
 type ExternalCommand struct {
 	_name  string
 	_help  string
@@ -92,11 +111,20 @@ func (c *CommandHelp) run([]string) string {
 	for _, c := range commands {
 		output += c.name() + " - " + c.help() + "\r\n"
 	}
+	output += "json on|off - JSON output for scripting\r\n"
 	output += "quit - exit console"
 
 	return output
 }
 
+func (c *CommandHelp) data([]string) (interface{}, error) {
+	help := make(map[string]string, len(commands))
+	for _, c := range commands {
+		help[c.name()] = c.help()
+	}
+	return help, nil
+}
+
 // cpuprof
 type CommandCPUProf struct{}
 
@@ -213,3 +241,288 @@ func (c *CommandProf) run(args []string) string {
 
 	return fn
 }
+
+// loglevel
+type CommandLogLevel struct{}
+
+func (c *CommandLogLevel) name() string {
+	return "loglevel"
+}
+
+func (c *CommandLogLevel) help() string {
+	return "show or change log levels at runtime"
+}
+
+func (c *CommandLogLevel) usage() string {
+	return "Usage: loglevel [level] | loglevel module <name> [level]\r\n" +
+		"  level  - debug|release|error|fatal, changes the global level\r\n" +
+		"  module - changes the level of a module, resets it without level"
+}
+
+func (c *CommandLogLevel) data(args []string) (interface{}, error) {
+	var err error
+	switch {
+	case len(args) == 0:
+	case len(args) == 1:
+		err = log.SetLevel(args[0])
+	case args[0] == "module" && len(args) == 2:
+		err = log.SetModuleLevel(args[1], "")
+	case args[0] == "module" && len(args) == 3:
+		err = log.SetModuleLevel(args[1], args[2])
+	default:
+		return nil, errors.New(c.usage())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"Level":   log.Level(),
+		"Modules": log.ModuleLevels(),
+	}, nil
+}
+
+func (c *CommandLogLevel) run(args []string) string {
+	if _, err := c.data(args); err != nil {
+		return err.Error()
+	}
+
+	output := "level: " + log.Level()
+	levels := log.ModuleLevels()
+	modules := make([]string, 0, len(levels))
+	for module := range levels {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	for _, module := range modules {
+		output += "\r\n" + module + ": " + levels[module]
+	}
+	return output
+}
+
+// rpcstats
+type CommandRPCStats struct{}
+
+func (c *CommandRPCStats) name() string {
+	return "rpcstats"
+}
+
+func (c *CommandRPCStats) help() string {
+	return "chanrpc queue length and per function call statistics"
+}
+
+func (c *CommandRPCStats) data([]string) (interface{}, error) {
+	return chanrpc.AllStats(), nil
+}
+
+func (c *CommandRPCStats) run([]string) string {
+	output := ""
+	for i, st := range chanrpc.AllStats() {
+		if i > 0 {
+			output += "\r\n"
+		}
+		output += fmt.Sprintf("%v: queue %v/%v, high water %v\r\n", st.Name, st.QueueLen, st.QueueCap, st.HighWater)
+		output += fmt.Sprintf("  %-32v %10v %8v %8v %8v %12v %12v", "id", "calls", "errors", "panics", "expired", "avg", "max")
+		for _, fs := range st.Funcs {
+			var avg time.Duration
+			if fs.Calls > 0 {
+				avg = fs.Total / time.Duration(fs.Calls)
+			}
+			output += fmt.Sprintf("\r\n  %-32v %10v %8v %8v %8v %12v %12v", fs.ID, fs.Calls, fs.Errors, fs.Panics, fs.Expired, avg, fs.Max)
+		}
+	}
+	return output
+}
+
+// gc
+type CommandGC struct{}
+
+func (c *CommandGC) name() string {
+	return "gc"
+}
+
+func (c *CommandGC) help() string {
+	return "run a garbage collection and return memory to the OS"
+}
+
+// gc前后的堆内存，字节数
+type gcResult struct {
+	HeapAllocBefore uint64
+	HeapAllocAfter  uint64
+	HeapSysBefore   uint64 // 未归还给操作系统的堆内存
+	HeapSysAfter    uint64
+	Duration        string
+}
+
+func (c *CommandGC) data([]string) (interface{}, error) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	debug.FreeOSMemory()
+	d := time.Since(start)
+	runtime.ReadMemStats(&after)
+	return gcResult{
+		HeapAllocBefore: before.HeapAlloc,
+		HeapAllocAfter:  after.HeapAlloc,
+		HeapSysBefore:   before.HeapSys - before.HeapReleased,
+		HeapSysAfter:    after.HeapSys - after.HeapReleased,
+		Duration:        d.String(),
+	}, nil
+}
+
+func (c *CommandGC) run(args []string) string {
+	ret, _ := c.data(args)
+	r := ret.(gcResult)
+	return fmt.Sprintf("heap alloc: %v -> %v\r\nheap sys: %v -> %v\r\ntook %v",
+		formatBytes(r.HeapAllocBefore), formatBytes(r.HeapAllocAfter),
+		formatBytes(r.HeapSysBefore), formatBytes(r.HeapSysAfter), r.Duration)
+}
+
+// memstats
+type CommandMemStats struct{}
+
+func (c *CommandMemStats) name() string {
+	return "memstats"
+}
+
+func (c *CommandMemStats) help() string {
+	return "memory allocator statistics and goroutine count"
+}
+
+// 内存统计，字节数
+type memStats struct {
+	Alloc        uint64
+	TotalAlloc   uint64
+	Sys          uint64
+	HeapAlloc    uint64
+	HeapInuse    uint64
+	HeapIdle     uint64
+	HeapReleased uint64
+	HeapObjects  uint64
+	StackInuse   uint64
+	NumGC        uint32
+	PauseTotal   string
+	LastGC       string
+	Goroutines   int
+}
+
+func (c *CommandMemStats) data([]string) (interface{}, error) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	st := memStats{
+		Alloc:        ms.Alloc,
+		TotalAlloc:   ms.TotalAlloc,
+		Sys:          ms.Sys,
+		HeapAlloc:    ms.HeapAlloc,
+		HeapInuse:    ms.HeapInuse,
+		HeapIdle:     ms.HeapIdle,
+		HeapReleased: ms.HeapReleased,
+		HeapObjects:  ms.HeapObjects,
+		StackInuse:   ms.StackInuse,
+		NumGC:        ms.NumGC,
+		PauseTotal:   time.Duration(ms.PauseTotalNs).String(),
+		Goroutines:   runtime.NumGoroutine(),
+	}
+	if ms.LastGC > 0 {
+		st.LastGC = time.Unix(0, int64(ms.LastGC)).Format(time.RFC3339)
+	}
+	return st, nil
+}
+
+func (c *CommandMemStats) run(args []string) string {
+	ret, _ := c.data(args)
+	st := ret.(memStats)
+	return fmt.Sprintf("alloc: %v, total alloc: %v, sys: %v\r\n", formatBytes(st.Alloc), formatBytes(st.TotalAlloc), formatBytes(st.Sys)) +
+		fmt.Sprintf("heap alloc: %v, in use: %v, idle: %v, released: %v, objects: %v\r\n",
+			formatBytes(st.HeapAlloc), formatBytes(st.HeapInuse), formatBytes(st.HeapIdle), formatBytes(st.HeapReleased), st.HeapObjects) +
+		fmt.Sprintf("stack in use: %v, goroutines: %v\r\n", formatBytes(st.StackInuse), st.Goroutines) +
+		fmt.Sprintf("gc: %v, pause total: %v, last: %v", st.NumGC, st.PauseTotal, st.LastGC)
+}
+
+func formatBytes(b uint64) string {
+	switch {
+	case b >= 1<<30:
+		return fmt.Sprintf("%.2fGB", float64(b)/(1<<30))
+	case b >= 1<<20:
+		return fmt.Sprintf("%.2fMB", float64(b)/(1<<20))
+	case b >= 1<<10:
+		return fmt.Sprintf("%.2fKB", float64(b)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", b)
+}
+
+// reload
+type CommandReload struct{}
+
+// 重新加载函数，按注册顺序执行
+var reloads []struct {
+	name string
+	f    func() error
+}
+
+// 注册reload命令执行的函数，例如重新读取配置
+// you must call the function before calling console.Init
+// goroutine not safe
+func RegisterReload(name string, f func() error) {
+	reloads = append(reloads, struct {
+		name string
+		f    func() error
+	}{name, f})
+}
+
+func (c *CommandReload) name() string {
+	return "reload"
+}
+
+func (c *CommandReload) help() string {
+	return "reload configs, usage: reload [name], all without name"
+}
+
+// 重新加载结果
+type reloadResult struct {
+	Name  string
+	Error string
+}
+
+func (c *CommandReload) data(args []string) (interface{}, error) {
+	var results []reloadResult
+	for _, r := range reloads {
+		if len(args) > 0 && args[0] != r.name {
+			continue
+		}
+		result := reloadResult{Name: r.name}
+		if err := r.f(); err != nil {
+			result.Error = err.Error()
+			log.Error("console reload %v: %v", r.name, err)
+		} else {
+			log.Release("console reload %v", r.name)
+		}
+		results = append(results, result)
+	}
+	if len(args) > 0 && len(results) == 0 {
+		return nil, fmt.Errorf("unknown reload %v", args[0])
+	}
+	return results, nil
+}
+
+func (c *CommandReload) run(args []string) string {
+	ret, err := c.data(args)
+	if err != nil {
+		return err.Error()
+	}
+	results := ret.([]reloadResult)
+	if len(results) == 0 {
+		return "nothing to reload"
+	}
+	output := ""
+	for i, r := range results {
+		if i > 0 {
+			output += "\r\n"
+		}
+		if r.Error != "" {
+			output += r.Name + ": " + r.Error
+		} else {
+			output += r.Name + ": ok"
+		}
+	}
+	return output
+}
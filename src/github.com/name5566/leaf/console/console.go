@@ -2,9 +2,14 @@ package console
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/log"
 	"github.com/name5566/leaf/network"
+	"github.com/name5566/leaf/util"
 	"math"
+	"net"
 	"strconv"
 	"strings"
 )
@@ -17,11 +22,28 @@ func Init() {
 		return
 	}
 
-	server = new(network.TCPServer)                             //创建一个tcp服务器
-	server.Addr = "localhost:" + strconv.Itoa(conf.ConsolePort) //IP + 端口
-	server.MaxConnNum = int(math.MaxInt32)                      //最大连接数
-	server.PendingWriteNum = 100                                //发送缓冲区长度
-	server.NewAgent = newAgent                                  //创建代理函数
+	bind := conf.ConsoleBind
+	if bind == "" {
+		bind = "localhost"
+	}
+	//监听非本机地址或设置了密码时需要认证
+	authRequired = conf.ConsolePassword != "" || !util.IsLoopback(bind)
+	if !util.IsLoopback(bind) {
+		if conf.ConsolePassword == "" {
+			log.Release("console listens on %v without ConsolePassword, only login tokens with right %v are accepted", bind, AuthRight)
+		}
+		if conf.ConsoleCertFile == "" {
+			log.Release("console listens on %v without TLS, credentials are sent in plaintext", bind)
+		}
+	}
+
+	server = new(network.TCPServer)                                      //创建一个tcp服务器
+	server.Addr = net.JoinHostPort(bind, strconv.Itoa(conf.ConsolePort)) //IP + 端口
+	server.MaxConnNum = int(math.MaxInt32)                               //最大连接数
+	server.PendingWriteNum = 100                                         //发送缓冲区长度
+	server.CertFile = conf.ConsoleCertFile                               //TLS证书，与KeyFile同时设置时启用
+	server.KeyFile = conf.ConsoleKeyFile                                 //TLS私钥
+	server.NewAgent = newAgent                                           //创建代理函数
 
 	server.Start() //启动服务器
 }
@@ -35,8 +57,11 @@ func Destroy() {
 
 //代理类型定义
 type Agent struct {
-	conn   *network.TCPConn
-	reader *bufio.Reader //封装io.Reader or io.Writer对象，创建另外一个实现了对应接口的对象，提供缓存和文本读取的功能
+	conn     *network.TCPConn
+	reader   *bufio.Reader //封装io.Reader or io.Writer对象，创建另外一个实现了对应接口的对象，提供缓存和文本读取的功能
+	authed   bool          //是否已认证
+	failures int           //认证失败次数
+	json     bool          //是否输出json
 }
 
 //创建代理函数定义
@@ -46,6 +71,7 @@ func newAgent(conn *network.TCPConn) network.Agent {
 	a := new(Agent)                  //新建代理(定义在上面)
 	a.conn = conn                    //保存TCP连接封装
 	a.reader = bufio.NewReader(conn) //新建reader(带缓冲)
+	a.authed = !authRequired         //不需要认证时直接通过
 	return a
 }
 
@@ -53,24 +79,32 @@ func newAgent(conn *network.TCPConn) network.Agent {
 //命令格式为 命令名 命令参数1 命令参数2 .... 命令参数n
 func (a *Agent) Run() {
 	for { //死循环
-		if conf.ConsolePrompt != "" { //如果提示符不为空
+		if conf.ConsolePrompt != "" && !a.json { //如果提示符不为空，json模式下不发送
 			a.conn.Write([]byte(conf.ConsolePrompt)) //发送提示符
 		}
 
-		line, err := a.reader.ReadString('\n') //读取一个字符串，以\n分隔
-		if err != nil { //读取出错
+		line, err := a.reader.ReadSlice('\n') //读取一行，超过缓冲区长度时返回错误
+		if err != nil { //读取出错或一行太长
 			break //退出循环
 		}
 		//在windows系统下，回车换行符号是"\r\n".但是在Linux等系统下是没有"\r"符号的
-		line = strings.TrimSuffix(line[:len(line)-1], "\r") //line[:len(line)-1]去除\n,TrimSuffix去除\r
-
-		args := strings.Fields(line) //按空格分割字符串为多个子字符串
+		args := strings.Fields(strings.TrimSuffix(string(line[:len(line)-1]), "\r")) //去除\n和\r，按空格分割字符串为多个子字符串
 		if len(args) == 0 { //line只包含空格时args为空
 			continue
 		}
 		if args[0] == "quit" { //如果第一个子字符串为quit
 			break //退出循环
 		}
+		if !a.authed { //未认证时只能认证
+			if !a.auth(args) && a.failures >= maxAuthFailures {
+				break
+			}
+			continue
+		}
+		if args[0] == "json" { //切换输出格式
+			a.setJSON(args[1:])
+			continue
+		}
 		var c Command
 		for _, _c := range commands { //遍历所有命令
 			if _c.name() == args[0] { //匹配到某命令
@@ -79,14 +113,79 @@ func (a *Agent) Run() {
 			}
 		}
 		if c == nil { //未匹配到任何命令
-			a.conn.Write([]byte("command not found, try `help` for help\r\n")) //发送命令未找到消息
+			a.reply(nil, errCommandNotFound) //发送命令未找到消息
 			continue
 		}
-		output := c.run(args[1:]) //执行命令，参数为除了第一个子字符串（命令名）的剩余子字符串
+		if authRequired { //需要认证时记录执行的命令
+			log.Release("console %v: %v", a.conn.RemoteAddr(), strings.Join(args, " "))
+		}
+		a.exec(c, args[1:]) //执行命令，参数为除了第一个子字符串（命令名）的剩余子字符串
+	}
+}
+
+//执行命令并发送结果
+//json模式下支持json输出的命令发送data的结果，其他命令的输出作为字符串发送
+func (a *Agent) exec(c Command, args []string) {
+	if !a.json {
+		output := c.run(args)
 		if output != "" { //执行命令结果不为空
 			a.conn.Write([]byte(output + "\r\n")) //发送命令执行结果
 		}
+		return
+	}
+	if dc, ok := c.(dataCommand); ok {
+		a.reply(dc.data(args))
+		return
+	}
+	a.reply(c.run(args), nil)
+}
+
+//json模式下的输出，每个结果一行
+//{"OK":true,"Result":...} 或 {"OK":false,"Error":"..."}
+type jsonReply struct {
+	OK     bool
+	Result interface{} `json:",omitempty"`
+	Error  string      `json:",omitempty"`
+}
+
+//发送结果或错误
+func (a *Agent) reply(result interface{}, err error) {
+	if !a.json {
+		output, _ := result.(string)
+		if err != nil {
+			output = err.Error()
+		}
+		if output != "" {
+			a.conn.Write([]byte(output + "\r\n"))
+		}
+		return
+	}
+	r := jsonReply{OK: err == nil, Result: result}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf) //Encode结尾带换行
+	enc.SetEscapeHTML(false)
+	if e := enc.Encode(r); e != nil {
+		buf.Reset()
+		enc.Encode(jsonReply{Error: e.Error()})
+	}
+	a.conn.Write(buf.Bytes())
+}
+
+//json on|off
+func (a *Agent) setJSON(args []string) {
+	switch {
+	case len(args) == 1 && args[0] == "on":
+		a.json = true
+	case len(args) == 1 && args[0] == "off":
+		a.json = false
+	default:
+		a.reply(nil, errJSONUsage)
+		return
 	}
+	a.reply("json "+args[0], nil)
 }
 
 func (a *Agent) OnInit(arg interface{}) {}
@@ -0,0 +1,23 @@
+package model
+
+// 定时任务表，时间为毫秒时间戳
+type Job struct {
+	JobID   string `gorm:"primary_key"`
+	Handler string
+	Cron    string // 为空时为一次性任务
+	Payload string
+	NextRun int64 `gorm:"index"`
+	Created int64
+}
+
+// 定时任务执行记录表
+type JobRun struct {
+	ID        uint   `gorm:"primary_key"`
+	JobID     string `gorm:"index"`
+	Handler   string
+	Scheduled int64
+	Started   int64
+	Duration  int64 // 毫秒
+	Node      string
+	Err       string
+}
@@ -2,6 +2,7 @@
 package postgre
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 
@@ -10,10 +11,32 @@ import (
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/log"
 	"github.com/name5566/leaf/db/postgre/model"
+	"github.com/name5566/leaf/metrics"
 )
 
 var DB *gorm.DB
 
+// 连接池状态
+var (
+	_ = metrics.NewGaugeFunc("postgres_pool_connections", "Postgres pool connections by state.", []string{"state"},
+		func(set func(float64, ...string)) {
+			if DB == nil {
+				return
+			}
+			stats := DB.DB().Stats()
+			set(float64(stats.OpenConnections), "open")
+			set(float64(stats.InUse), "in_use")
+			set(float64(stats.Idle), "idle")
+		})
+	_ = metrics.NewGaugeFunc("postgres_pool_wait_count", "Total connections waited for.", nil,
+		func(set func(float64, ...string)) {
+			if DB == nil {
+				return
+			}
+			set(float64(DB.DB().Stats().WaitCount))
+		})
+)
+
 // 初始化数据库连接实例
 func InitDB() {
 	Config := conf.Config.Postgre
@@ -36,7 +59,16 @@ func InitDB() {
 func updateTable(db *gorm.DB) {
 	db.SingularTable(true)
 	db.AutoMigrate(&model.User{})
+	db.AutoMigrate(&model.Job{}, &model.JobRun{})
 	// 添加外键关联，注意user是postgre关键字
 	// 关联user表
 
 }
+
+// 检查数据库是否可用
+func Ping() error {
+	if DB == nil {
+		return errors.New("postgre not initialized")
+	}
+	return DB.DB().Ping()
+}
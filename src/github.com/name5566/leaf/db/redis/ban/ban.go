@@ -0,0 +1,218 @@
+// 封禁IP和用户
+// 封禁信息存储在redis中，所有节点共享，本地缓存定期从redis刷新
+package ban
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	rredis "github.com/gomodule/redigo/redis"
+	"github.com/name5566/leaf/db/redis"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/util"
+)
+
+const BanIPKey string = "QUANTITY_BAN:IP"     // 封禁IP的hash，field为CIDR
+const BanUserKey string = "QUANTITY_BAN:USER" // 封禁用户的hash，field为userID
+
+// 本地缓存刷新间隔
+var RefreshInterval = 5 * time.Second
+
+// 封禁信息
+type Ban struct {
+	Target  string    // CIDR或userID
+	Expired time.Time // 零值表示永久封禁
+	Reason  string
+	ipNet   *net.IPNet
+}
+
+// IP是否在封禁范围内
+func (b *Ban) MatchIP(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return b.ipNet != nil && parsed != nil && b.ipNet.Contains(parsed)
+}
+
+func (b *Ban) expired(now time.Time) bool {
+	return !b.Expired.IsZero() && now.After(b.Expired)
+}
+
+// 本地缓存
+var cache struct {
+	sync.RWMutex
+	ips       []*Ban
+	users     map[uint]*Ban
+	refreshAt time.Time
+}
+
+// 封禁IP或CIDR，duration为0时永久封禁
+func BanIP(cidr string, duration time.Duration, reason string) (*Ban, error) {
+	ipNet, err := util.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	b := &Ban{Target: ipNet.String(), Reason: reason, ipNet: ipNet}
+	if duration > 0 {
+		b.Expired = time.Now().Add(duration)
+	}
+	if err := save(BanIPKey, b); err != nil {
+		return nil, err
+	}
+	Refresh()
+	return b, nil
+}
+
+// 封禁用户，duration为0时永久封禁
+func BanUser(userID uint, duration time.Duration, reason string) (*Ban, error) {
+	b := &Ban{Target: strconv.Itoa(int(userID)), Reason: reason}
+	if duration > 0 {
+		b.Expired = time.Now().Add(duration)
+	}
+	if err := save(BanUserKey, b); err != nil {
+		return nil, err
+	}
+	Refresh()
+	return b, nil
+}
+
+// 解封IP或CIDR
+func UnbanIP(cidr string) error {
+	ipNet, err := util.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	_, err = redis.Do("hdel", BanIPKey, ipNet.String())
+	if err != nil {
+		return fmt.Errorf("unban ip err: %s", err)
+	}
+	Refresh()
+	return nil
+}
+
+// 解封用户
+func UnbanUser(userID uint) error {
+	_, err := redis.Do("hdel", BanUserKey, userID)
+	if err != nil {
+		return fmt.Errorf("unban user err: %s", err)
+	}
+	Refresh()
+	return nil
+}
+
+// 检查IP是否被封禁，返回匹配的封禁信息，未封禁返回nil
+// goroutine safe
+func CheckIP(ip string) *Ban {
+	if net.ParseIP(ip) == nil {
+		return nil
+	}
+	refreshIfNeeded()
+
+	now := time.Now()
+	cache.RLock()
+	defer cache.RUnlock()
+	for _, b := range cache.ips {
+		if b.MatchIP(ip) && !b.expired(now) {
+			return b
+		}
+	}
+	return nil
+}
+
+// 检查用户是否被封禁，返回封禁信息，未封禁返回nil
+// goroutine safe
+func CheckUser(userID uint) *Ban {
+	refreshIfNeeded()
+
+	cache.RLock()
+	defer cache.RUnlock()
+	if b, ok := cache.users[userID]; ok && !b.expired(time.Now()) {
+		return b
+	}
+	return nil
+}
+
+// 封禁的IP和用户列表
+func List() (ips []*Ban, users []*Ban, err error) {
+	ips, err = load(BanIPKey)
+	if err != nil {
+		return
+	}
+	users, err = load(BanUserKey)
+	return
+}
+
+// 从redis刷新本地缓存
+func Refresh() {
+	ips, users, err := List()
+
+	cache.Lock()
+	defer cache.Unlock()
+	cache.refreshAt = time.Now()
+	if err != nil {
+		log.Error("refresh ban list failed: %v", err)
+		return
+	}
+	cache.ips = ips
+	cache.users = make(map[uint]*Ban, len(users))
+	for _, b := range users {
+		userID, _ := strconv.Atoi(b.Target)
+		cache.users[uint(userID)] = b
+	}
+}
+
+// 到达刷新间隔时刷新，同一时间只有一个goroutine刷新
+func refreshIfNeeded() {
+	cache.Lock()
+	if time.Since(cache.refreshAt) < RefreshInterval {
+		cache.Unlock()
+		return
+	}
+	cache.refreshAt = time.Now()
+	cache.Unlock()
+	Refresh()
+}
+
+// 封禁信息以json存入redis的hash
+func save(key string, b *Ban) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	_, err = redis.Do("hset", key, b.Target, data)
+	if err != nil {
+		return fmt.Errorf("ban set err: %s", err)
+	}
+	return nil
+}
+
+// 读取hash中的封禁信息，并清理已过期的
+func load(key string) ([]*Ban, error) {
+	res, err := rredis.StringMap(redis.Do("hgetall", key))
+	if err != nil {
+		return nil, fmt.Errorf("ban get err: %s", err)
+	}
+	now := time.Now()
+	bans := make([]*Ban, 0, len(res))
+	for target, data := range res {
+		b := new(Ban)
+		if err := json.Unmarshal([]byte(data), b); err != nil {
+			log.Error("invalid ban %v: %v", target, err)
+			continue
+		}
+		if b.expired(now) {
+			redis.Do("hdel", key, target)
+			continue
+		}
+		if key == BanIPKey {
+			if b.ipNet, err = util.ParseCIDR(target); err != nil {
+				log.Error("invalid ban %v: %v", target, err)
+				continue
+			}
+		}
+		bans = append(bans, b)
+	}
+	return bans, nil
+}
@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"errors"
 	"fmt"
 	"time"
 	"strconv"
@@ -8,10 +9,22 @@ import (
 	"github.com/gomodule/redigo/redis"
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
 )
 
 var RedisClient *redis.Pool
 
+// 连接池状态，active包含空闲连接
+var _ = metrics.NewGaugeFunc("redis_pool_connections", "Redis pool connections by state.", []string{"state"},
+	func(set func(float64, ...string)) {
+		if RedisClient == nil {
+			return
+		}
+		stats := RedisClient.Stats()
+		set(float64(stats.ActiveCount), "active")
+		set(float64(stats.IdleCount), "idle")
+	})
+
 // 初始化redis连接池
 func InitPool() {
 	config := conf.Config.Redis
@@ -51,3 +64,12 @@ func Do(commandName string, args ...interface{}) (reply interface{}, err error)
 	}
 	return res, nil
 }
+
+// 检查redis是否可用
+func Ping() error {
+	if RedisClient == nil {
+		return errors.New("redis pool not initialized")
+	}
+	_, err := Do("PING")
+	return err
+}
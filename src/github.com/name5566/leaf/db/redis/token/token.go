@@ -14,6 +14,9 @@ import (
 // token为uuid, 值为User.ID
 const TokenKeyFmt string = "QUANTITY_TOKEN:%s" // 存入redis的key格式,TOKEN:uuid
 const TokenValFmt string = "%s_%s"             // 存入redis的value格式， {userID}_{token from login server}
+const RightsKeyFmt string = "QUANTITY_RIGHTS:%s" // 缓存用户权限的key格式，RIGHTS:token，值为逗号分隔的Server:Name
+const UserRightsKeyFmt string = "QUANTITY_USER_RIGHTS:%d" // 用户最近一次登陆或刷新时的权限，USER_RIGHTS:userID，不过期
+const UserTokensKeyFmt string = "QUANTITY_USER_TOKENS:%d" // 用户的token集合，USER_TOKENS:userID，过期时间不短于其中的token
 
 // 设置session到redis
 // loginName: 登录名; reqRight: 登录鉴权信息; duration: session超时时间
@@ -23,7 +26,7 @@ func SetSessionID(userID uint, duration uint, tokenLogin string) (string, error)
 		return "", fmt.Errorf("token generate err: %s", err)
 	}
 	tokenVal := fmt.Sprintf(TokenValFmt, strconv.Itoa(int(userID)), tokenLogin)
-	log.Debug("token: %s, val: %s", token, tokenVal)
+	log.Debug("token set for user %v", userID)
 	tokenKey := fmt.Sprintf(TokenKeyFmt, token) // 存入redis时格式化
 	_, err = redis.Do("set", tokenKey, tokenVal)
 	if err != nil {
@@ -33,9 +36,50 @@ func SetSessionID(userID uint, duration uint, tokenLogin string) (string, error)
 	if err != nil {
 		return "", fmt.Errorf("token set 2 err: %s", err)
 	}
+	if err = addUserToken(userID, token, duration); err != nil {
+		return "", fmt.Errorf("token set 3 err: %s", err)
+	}
 	return token, nil
 }
 
+// 记录用户的token，集合的过期时间只延长不缩短
+func addUserToken(userID uint, token string, duration uint) error {
+	tokensKey := fmt.Sprintf(UserTokensKeyFmt, userID)
+	if _, err := redis.Do("sadd", tokensKey, token); err != nil {
+		return err
+	}
+	ttl, err := rredis.Int(redis.Do("ttl", tokensKey))
+	if err != nil {
+		return err
+	}
+	if ttl < int(duration) {
+		_, err = redis.Do("expire", tokensKey, duration)
+	}
+	return err
+}
+
+// 获取用户的token，清除集合中已过期的token
+func userTokens(userID uint) ([]string, error) {
+	tokensKey := fmt.Sprintf(UserTokensKeyFmt, userID)
+	tokens, err := rredis.Strings(redis.Do("smembers", tokensKey))
+	if err != nil {
+		return nil, err
+	}
+	valid := tokens[:0]
+	for _, token := range tokens {
+		n, err := rredis.Int(redis.Do("exists", fmt.Sprintf(TokenKeyFmt, token)))
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			redis.Do("srem", tokensKey, token)
+			continue
+		}
+		valid = append(valid, token)
+	}
+	return valid, nil
+}
+
 // 从redis中读取token的值
 // 获取对应的userID
 func GetTokenValByID(token string) (userID uint, loginToken string, maxAge uint, err error) {
@@ -94,47 +138,102 @@ func GetLoginTokenByID(token string) (loginUserID uint, loginToken string, err e
 // 从redis删除对应的session
 func DelSessionID(token string) (error) {
 	tokenKey := fmt.Sprintf(TokenKeyFmt, token) // 存入redis时格式化
-	_, err := rredis.String(redis.Do("get", tokenKey))
+	userID, _, err := GetLoginTokenByID(token)
 	if err != nil {
-		if err.Error() == "redigo: nil returned" {
-			return fmt.Errorf("unlogin")
-		}
 		return err
 	}
-	_, err = redis.Do("del", tokenKey)
+	_, err = redis.Do("del", tokenKey, fmt.Sprintf(RightsKeyFmt, token))
 	if err != nil {
 		return fmt.Errorf("token delete err: %s", err)
 	}
+	redis.Do("srem", fmt.Sprintf(UserTokensKeyFmt, userID), token)
 	return nil
 }
 
 // 从redis清除对应userID的session
 func CleanSessionID(userID uint) (error) {
-	sessionQueryKey := fmt.Sprintf(TokenKeyFmt, "*")
-	tokens, err := rredis.Strings(redis.Do("keys", sessionQueryKey))
+	tokens, err := userTokens(userID)
 	if err != nil {
 		return fmt.Errorf("token clean err: %s", err)
 	}
-	for _, tokenKey := range tokens {
-		res, err := rredis.String(redis.Do("get", tokenKey))
-		if err != nil {
-			return fmt.Errorf("token clean err:  %s", err)
-		}
-		value := strings.Split(res, "_")
-		uID, err := strconv.Atoi(value[0])
-		if err != nil {
-			return fmt.Errorf("token val get from id err: %s", err)
+	keys := []interface{}{fmt.Sprintf(UserTokensKeyFmt, userID)}
+	for _, token := range tokens {
+		keys = append(keys, fmt.Sprintf(TokenKeyFmt, token), fmt.Sprintf(RightsKeyFmt, token))
+	}
+	_, err = redis.Do("del", keys...)
+	if err != nil {
+		return fmt.Errorf("token clean err:  %s", err)
+	}
+	return nil
+}
+
+// 获取对应userID的所有session及剩余秒数
+func GetSessionIDs(userID uint) (map[string]uint, error) {
+	tokens, err := userTokens(userID)
+	if err != nil {
+		return nil, fmt.Errorf("token list err: %s", err)
+	}
+	sessions := make(map[string]uint)
+	for _, token := range tokens {
+		_, _, maxAge, err := GetTokenValByID(token)
+		if err != nil { // 已过期
+			continue
 		}
-		if (uint(uID) == userID) {
-			_, err := redis.Do("del", tokenKey)
-			if err != nil {
-				return fmt.Errorf("token clean err:  %s", err)
-			}
+		sessions[token] = maxAge
+	}
+	return sessions, nil
+}
+
+// 缓存session对应用户的权限，过期时间和session一致
+func SetSessionRights(token string, rights []string, duration uint) error {
+	rightsKey := fmt.Sprintf(RightsKeyFmt, token)
+	_, err := redis.Do("set", rightsKey, strings.Join(rights, ","), "EX", duration)
+	if err != nil {
+		return fmt.Errorf("rights set err: %s", err)
+	}
+	return nil
+}
+
+// 读取session缓存的用户权限，没有缓存时返回空
+func GetSessionRights(token string) ([]string, error) {
+	rightsKey := fmt.Sprintf(RightsKeyFmt, token)
+	res, err := rredis.String(redis.Do("get", rightsKey))
+	if err != nil {
+		if err == rredis.ErrNil {
+			return nil, nil
 		}
+		return nil, fmt.Errorf("rights get err: %s", err)
+	}
+	if res == "" {
+		return nil, nil
+	}
+	return strings.Split(res, ","), nil
+}
+
+// 缓存用户的权限，用于没有session的AccessKey鉴权
+func SetUserRights(userID uint, rights []string) error {
+	_, err := redis.Do("set", fmt.Sprintf(UserRightsKeyFmt, userID), strings.Join(rights, ","))
+	if err != nil {
+		return fmt.Errorf("user rights set err: %s", err)
 	}
 	return nil
 }
 
+// 读取用户缓存的权限，用户未登陆过时返回空
+func GetUserRights(userID uint) ([]string, error) {
+	res, err := rredis.String(redis.Do("get", fmt.Sprintf(UserRightsKeyFmt, userID)))
+	if err != nil {
+		if err == rredis.ErrNil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("user rights get err: %s", err)
+	}
+	if res == "" {
+		return nil, nil
+	}
+	return strings.Split(res, ","), nil
+}
+
 // 生成token
 func genToken() (string, error) {
 	uuid, err := util.GetUUID()
@@ -2,6 +2,11 @@ package gate
 
 import (
 	"net"
+	"reflect"
+
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/gate/user"
+	"github.com/name5566/leaf/log"
 )
 
 type Agent interface {
@@ -13,3 +18,23 @@ type Agent interface {
 	UserData() interface{}        //获取用户数据
 	SetUserData(data interface{}) //设置用户数据
 }
+
+// 注册消息路由时检查第二个参数为Agent
+func init() {
+	chanrpc.AgentType = reflect.TypeOf((*Agent)(nil)).Elem()
+}
+
+// 带agent上下文字段的日志，字段为远端地址和已登陆用户的UserID
+// 在拦截器中可以再追加msg_id: gate.Logger(ctx.Agent).With(log.F("msg_id", ctx.MsgID))
+func Logger(a interface{}) *log.Entry {
+	entry := gateLog
+	agent, ok := a.(Agent)
+	if !ok {
+		return entry
+	}
+	entry = entry.With(log.F("remote", agent.RemoteAddr()))
+	if userData, ok := agent.UserData().(user.UserData); ok {
+		entry = entry.With(log.F("user_id", userData.UserID))
+	}
+	return entry
+}
@@ -1,65 +1,92 @@
 package gate
 
 import (
+	"context"
 	"net"
 	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
-	"fmt"
 
 	"github.com/name5566/leaf/chanrpc"
 	"github.com/name5566/leaf/log"
 	"github.com/name5566/leaf/network"
 	"github.com/name5566/leaf/gate/user"
+	"github.com/name5566/leaf/util"
 	"net/http"
 )
 
 //websocket和tcp协议网关服务器定义
 type Gate struct {
-	MaxConnNum      int               //最大连接数
-	PendingWriteNum int               //发送缓冲区长度
-	MaxMsgLen       uint32            //最大消息长度
-	Processor       network.Processor //json或protobuf处理器
-	AgentChanRPC    *chanrpc.Server   //RPC服务器
+	MaxConnNum      int                  //最大连接数
+	MaxConnPerIP    int                  //每个IP的最大连接数，0为不限制
+	IPFilter        func(ip string) bool //返回false时拒绝该IP的连接，nil不检查
+	AllowedOrigins  []string             //websocket和http允许的Origin，支持通配符，为空时只允许同源
+	TrustedProxies  []string             //可信代理的IP或CIDR，用于获取真实客户端地址
+	PendingWriteNum int                  //发送缓冲区长度
+	MaxMsgLen       uint32               //最大消息长度
+	Processor       network.Processor    //json或protobuf处理器，监听未单独设置处理器时使用
+	AgentChanRPC    *chanrpc.Server      //RPC服务器
+	ChanRPCTimeout  time.Duration        //同步调用AgentChanRPC的超时时间，0为不超时
+
+	// session
+	SessionTTL       time.Duration // 断开后会话保留时间，0为不启用会话恢复
+	SessionBufferLen int           // 会话缓存的最近发送消息数
 
 	// websocket
-	WSAddr      string // websocket监听地址
-	HTTPTimeout time.Duration
-	CertFile    string
-	KeyFile     string
+	WSAddr         string // websocket监听地址
+	HTTPTimeout    time.Duration
+	CertFile       string
+	KeyFile        string
+	WSProcessor    network.Processor            // websocket使用的处理器，nil时使用Processor
+	WSSubprotocols map[string]network.Processor // 子协议对应的处理器，按Sec-WebSocket-Protocol协商，未协商时使用WSProcessor
 
 	// tcp
-	TCPAddr      string // tcp监听地址
-	LenMsgLen    int    // 消息长度占用字节数
-	LittleEndian bool   // 大小端标志
+	TCPAddr       string            // tcp监听地址
+	LenMsgLen     int               // 消息长度占用字节数
+	LittleEndian  bool              // 大小端标志
+	ProxyProtocol bool              // 是否解析可信代理的PROXY protocol头
+	TCPProcessor  network.Processor // tcp使用的处理器，nil时使用Processor
 
 	// http
 	HTTPAddr     string // http监听地址
 	HTTPCertFile string
 	HTTPKeyFile  string
 	ServeMux	 http.ServeMux
+
+	ready int32 // 监听是否已启动，原子读写
 }
 
 //实现了Module接口的Run
 func (gate *Gate) Run(closeSig chan bool) {
+	if gate.SessionTTL > 0 && gate.SessionBufferLen <= 0 {
+		gate.SessionBufferLen = 100
+		log.Release("invalid SessionBufferLen, reset to %v", gate.SessionBufferLen)
+	}
+
 	var wsServer *network.WSServer
 	if gate.WSAddr != "" {
 		wsServer = new(network.WSServer) //创建websocket服务对象
 		//设置websocket服务器相关参数
 		wsServer.Addr = gate.WSAddr
 		wsServer.MaxConnNum = gate.MaxConnNum
+		wsServer.MaxConnPerIP = gate.MaxConnPerIP
+		wsServer.IPFilter = gate.IPFilter
+		wsServer.AllowedOrigins = gate.AllowedOrigins
+		wsServer.TrustedProxies = gate.TrustedProxies
 		wsServer.PendingWriteNum = gate.PendingWriteNum
 		wsServer.MaxMsgLen = gate.MaxMsgLen
 		wsServer.HTTPTimeout = gate.HTTPTimeout
 		wsServer.CertFile = gate.CertFile
 		wsServer.KeyFile = gate.KeyFile
+		for name := range gate.WSSubprotocols {
+			wsServer.Subprotocols = append(wsServer.Subprotocols, name)
+		}
+		sort.Strings(wsServer.Subprotocols)
 		wsServer.NewAgent = func(conn *network.WSConn) network.Agent { // 设置创建代理函数, 关联gate和conn
-			a := &agent{conn: conn, gate: gate}
-			fmt.Printf("gate.Agent: %v\n", a)
-			fmt.Printf("gate.AgentChanRPC: %v\n", gate.AgentChanRPC)
-			if gate.AgentChanRPC != nil {
-				gate.AgentChanRPC.Go("NewAgent", a)
-				log.Debug("new agent: %v\n", a)
-			}
+			a := gate.newAgent(conn, gate.wsProcessor(conn.Subprotocol()))
+			Logger(a).With(log.F("subprotocol", conn.Subprotocol())).Debug("new websocket agent")
 			return a
 		}
 	}
@@ -70,16 +97,20 @@ func (gate *Gate) Run(closeSig chan bool) {
 		//设置TCP服务器相关参数
 		tcpServer.Addr = gate.TCPAddr
 		tcpServer.MaxConnNum = gate.MaxConnNum
+		tcpServer.MaxConnPerIP = gate.MaxConnPerIP
+		tcpServer.IPFilter = gate.IPFilter
+		tcpServer.ProxyProtocol = gate.ProxyProtocol
+		tcpServer.TrustedProxies = gate.TrustedProxies
 		tcpServer.PendingWriteNum = gate.PendingWriteNum
 		tcpServer.LenMsgLen = gate.LenMsgLen
 		tcpServer.MaxMsgLen = gate.MaxMsgLen
 		tcpServer.LittleEndian = gate.LittleEndian
 		tcpServer.NewAgent = func(conn *network.TCPConn) network.Agent { //设置创建代理函数
-			a := &agent{conn: conn, gate: gate}
-			if gate.AgentChanRPC != nil {
-				gate.AgentChanRPC.Go("NewAgent", a)
+			processor := gate.TCPProcessor
+			if processor == nil {
+				processor = gate.Processor
 			}
-			return a
+			return gate.newAgent(conn, processor)
 		}
 	}
 
@@ -91,6 +122,8 @@ func (gate *Gate) Run(closeSig chan bool) {
 		httpServer.HTTPTimeout = gate.HTTPTimeout
 		httpServer.CertFile = gate.HTTPCertFile
 		httpServer.KeyFile = gate.HTTPKeyFile
+		httpServer.AllowedOrigins = gate.AllowedOrigins
+		httpServer.TrustedProxies = gate.TrustedProxies
 		httpServer.Handler = gate.ServeMux
 	}
 
@@ -103,7 +136,9 @@ func (gate *Gate) Run(closeSig chan bool) {
 	if httpServer != nil {
 		httpServer.Start()
 	}
+	atomic.StoreInt32(&gate.ready, 1)
 	<-closeSig
+	atomic.StoreInt32(&gate.ready, 0)
 	if wsServer != nil {
 		wsServer.Close()
 	}
@@ -113,19 +148,145 @@ func (gate *Gate) Run(closeSig chan bool) {
 	if httpServer != nil {
 		httpServer.Close()
 	}
+	// 连接已全部关闭，等待恢复的会话直接结束
+	gate.closeSessions()
 }
 
 //Module接口的OnInit
 func (gate *Gate) OnInit() {}
 
+//module.Readier接口，所有监听启动后就绪
+func (gate *Gate) Ready() bool {
+	return atomic.LoadInt32(&gate.ready) == 1
+}
+
 //Module接口的OnDestroy
 func (gate *Gate) OnDestroy() {}
 
+// websocket连接使用的处理器
+// 优先使用协商的子协议对应的处理器，其次WSProcessor，最后Processor
+func (gate *Gate) wsProcessor(subprotocol string) network.Processor {
+	if processor, ok := gate.WSSubprotocols[subprotocol]; ok && subprotocol != "" {
+		return processor
+	}
+	if gate.WSProcessor != nil {
+		return gate.WSProcessor
+	}
+	return gate.Processor
+}
+
+// 创建agent，加入在线列表并通知AgentChanRPC
+func (gate *Gate) newAgent(conn network.Conn, processor network.Processor) *agent {
+	a := &agent{conn: conn, readConn: conn, gate: gate, processor: processor}
+	gate.announce(a)
+	return a
+}
+
+// 新agent加入在线列表并通知AgentChanRPC
+func (gate *Gate) announce(a *agent) {
+	agents.Set(a, struct{}{})
+	if gate.AgentChanRPC != nil {
+		gate.AgentChanRPC.Go("NewAgent", a)
+		Logger(a).Debug("new agent")
+	}
+}
+
+// 所有网关当前在线的agent
+var agents = new(util.Map)
+
+// 网关日志，需要agent上下文时使用Logger
+var gateLog = log.Module("gate")
+
+// goroutine safe
+// 遍历当前在线的agent
+func RangeAgents(f func(a Agent)) {
+	agents.RLockRange(func(k interface{}, _ interface{}) {
+		f(k.(*agent))
+	})
+}
+
+// goroutine safe
+// 在线agent数量
+func AgentCount() int {
+	return agents.Len()
+}
+
+// goroutine safe
+// 关闭所有match返回true的agent，返回关闭的数量
+func Kick(match func(a Agent) bool) int {
+	var kicked []Agent
+	RangeAgents(func(a Agent) {
+		if match(a) {
+			kicked = append(kicked, a)
+		}
+	})
+	for _, a := range kicked {
+		a.Close()
+	}
+	return len(kicked)
+}
+
+// 系统公告，控制台broadcast命令发送给所有连接，需要注册到Processor
+type Notice struct {
+	Msg string
+}
+
+// goroutine safe
+// 发送消息给所有在线agent，返回发送的数量
+func Broadcast(msg interface{}) int {
+	var all []Agent
+	RangeAgents(func(a Agent) {
+		all = append(all, a)
+	})
+	for _, a := range all {
+		a.WriteMsg(msg)
+	}
+	return len(all)
+}
+
+// agent的连接和会话状态
+type AgentInfo struct {
+	RemoteAddr string
+	UserID     uint      // 未登录时为0
+	Expired    time.Time // 登录过期时间
+	SessionID  string    // 未启用会话恢复时为空
+	Offline    bool      // 连接已断开，等待恢复会话
+	Seq        uint64    // 最后发送的消息序号
+}
+
+// goroutine safe
+func Info(a Agent) AgentInfo {
+	info := AgentInfo{RemoteAddr: a.RemoteAddr().String()}
+	ag, ok := a.(*agent)
+	if !ok {
+		return info
+	}
+	if userData, ok := ag.getUserData().(user.UserData); ok {
+		info.UserID = userData.UserID
+		info.Expired = userData.Expired
+	}
+	ag.mutex.Lock()
+	if s := ag.session; s != nil {
+		info.SessionID = s.id
+		info.Offline = s.offline
+		info.Seq = s.seq
+	}
+	ag.mutex.Unlock()
+	return info
+}
+
 //代理类型定义
 type agent struct {
-	conn     network.Conn // 连接接口
-	gate     *Gate        // 网关类型
-	userData interface{}  // 用户数据
+	conn      network.Conn      // 连接接口，恢复会话后切换为新连接
+	readConn  network.Conn      // Run读取消息的连接
+	gate      *Gate             // 网关类型
+	processor network.Processor // 当前连接使用的处理器
+	userData  interface{}       // 用户数据
+	dataMutex sync.RWMutex      // 保护userData，登陆处理函数设置时Info等可能在其他goroutine中读取
+
+	mutex   sync.Mutex // 保护conn和session
+	session *session   // 启用会话恢复时的会话，收到第一条消息时创建
+	resumed *agent     // 当前连接恢复到的会话所属agent，只在Run和OnClose中读写
 }
 
 // 实现代理接口(network.Agent)OnInit函数
@@ -133,30 +294,50 @@ type agent struct {
 func (a *agent) OnInit(data interface{}) {
 	if userData, ok := data.(*user.UserData); ok {
 		a.SetUserData(*userData)
-		log.Debug("UserData Set: %v", a.userData)
+		Logger(a).Debug("user data set")
 	}
 }
 
 //实现代理接口(network.Agent)Run函数
 func (a *agent) Run() {
-	for {
-		data, err := a.conn.ReadMsg()
+	target := a // 消息路由的agent，恢复会话后为会话所属agent
+	for first := true; ; first = false {
+		data, err := a.readConn.ReadMsg()
 		if err != nil {
-			log.Debug("read message: %v", err)
+			Logger(target).With(log.F("err", err)).Debug("read message")
 			break
 		}
 
-		if a.gate.Processor != nil {
-			fmt.Printf("agent run, conn: %v, gate: %v, data: %v \n", a.conn, a.gate, data)
-			msg, err := a.gate.Processor.Unmarshal(data)
+		if a.processor != nil {
+			msg, err := a.processor.Unmarshal(data)
 			if err != nil {
-				log.Debug("unmarshal message error: %v", err)
+				Logger(target).With(log.F("bytes", len(data)), log.F("err", err)).Debug("unmarshal message error")
 				break
 			}
-			fmt.Printf("Route: a: %v, a.userData: %v\n", a, a.userData)
-			err = a.gate.Processor.Route(msg, a)
+			if a.gate.SessionTTL > 0 && first {
+				if r, ok := msg.(*Resume); ok {
+					if target = a.resumeSession(r); target != nil {
+						a.resumed = target
+						a.release() // 新连接的agent由会话所属agent代替
+						if a.gate.AgentChanRPC != nil {
+							a.gate.AgentChanRPC.Go("ResumeAgent", target)
+						}
+						continue
+					}
+					Logger(a).With(log.F("session_id", r.SessionID)).Debug("resume session failed")
+					target = a
+				}
+				a.openSession()
+				if _, ok := msg.(*Resume); ok {
+					continue
+				}
+			}
+			if gateLog.DebugEnabled() {
+				Logger(target).With(log.F("msg_id", msgName(msg))).Debug("route message")
+			}
+			err = a.processor.Route(msg, target)
 			if err != nil {
-				log.Debug("route message error: %v", err)
+				Logger(target).With(log.F("msg_id", msgName(msg)), log.F("err", err)).Debug("route message error")
 				break
 			}
 		}
@@ -165,56 +346,125 @@ func (a *agent) Run() {
 
 //实现代理接口(gate.Agent)OnClose函数
 func (a *agent) OnClose() {
+	if a.resumed != nil {
+		a.resumed.detachSession(a.readConn)
+		return
+	}
+	if a.getSession() != nil {
+		a.detachSession(a.readConn)
+		return
+	}
+	a.release()
+}
+
+// 从在线列表移除并通知AgentChanRPC
+func (a *agent) release() {
+	if s := a.getSession(); s != nil {
+		sessions.Del(s.id)
+	}
+	agents.Del(a)
 	if a.gate.AgentChanRPC != nil {
-		err := a.gate.AgentChanRPC.Call0("CloseAgent", a)
+		ctx := context.Background()
+		if a.gate.ChanRPCTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, a.gate.ChanRPCTimeout)
+			defer cancel()
+		}
+		// NewAgent已经执行，CloseAgent不能丢弃，超时只是不再等待
+		err := a.gate.AgentChanRPC.Call0Wait(ctx, "CloseAgent", a)
 		if err != nil {
-			log.Error("chanrpc error: %v", err)
+			Logger(a).With(log.F("err", err)).Error("close agent chanrpc error")
 		}
 	}
 }
 
 //实现代理接口(gate.Agent)WriteMsg函数
 //发送消息
+//启用会话恢复时消息会缓存到会话中，连接断开期间只缓存不发送
 func (a *agent) WriteMsg(msg interface{}) {
-	if a.gate.Processor != nil {
-		data, err := a.gate.Processor.Marshal(msg)
+	if a.processor != nil {
+		data, err := a.processor.Marshal(msg)
 		if err != nil {
-			log.Error("marshal message %v error: %v", reflect.TypeOf(msg), err)
+			marshalErrors.Inc(msgName(msg))
+			Logger(a).With(log.F("msg_type", reflect.TypeOf(msg)), log.F("err", err)).Error("marshal message error")
 			return
 		}
+		messagesOut.Inc(msgName(msg))
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		if s := a.session; s != nil {
+			s.push(data, a.gate.SessionBufferLen)
+			if s.offline || s.closed {
+				return
+			}
+		}
 		err = a.conn.WriteMsg(data...)
 		if err != nil {
-			log.Error("write message %v error: %v", reflect.TypeOf(msg), err)
+			gateLog.With(log.F("msg_type", reflect.TypeOf(msg)), log.F("err", err)).Error("write message error")
 		}
 	}
 }
 
+// 直接发送消息，不计入会话序号
+// 调用时需持有a.mutex
+func (a *agent) write(msg interface{}) {
+	data, err := a.processor.Marshal(msg)
+	if err != nil {
+		marshalErrors.Inc(msgName(msg))
+		gateLog.With(log.F("msg_type", reflect.TypeOf(msg)), log.F("err", err)).Error("marshal message error")
+		return
+	}
+	messagesOut.Inc(msgName(msg))
+	if err := a.conn.WriteMsg(data...); err != nil {
+		gateLog.With(log.F("msg_type", reflect.TypeOf(msg)), log.F("err", err)).Error("write message error")
+	}
+}
+
 func (a *agent) LocalAddr() net.Addr {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
 	return a.conn.LocalAddr()
 }
 
 func (a *agent) RemoteAddr() net.Addr {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
 	return a.conn.RemoteAddr()
 }
 
 //实现代理接口(gate.Agent)Close函数
-//关闭代理
+//关闭代理，启用会话恢复时同时结束会话
 func (a *agent) Close() {
-	a.conn.Close()
+	if a.closeSession() {
+		a.release()
+		return
+	}
+	a.mutex.Lock()
+	conn := a.conn
+	a.mutex.Unlock()
+	conn.Close()
 }
 
 //实现代理接口(network.Agent)Destroy函数
 func (a *agent) Destroy() {
-	a.conn.Destroy()
+	if a.closeSession() {
+		a.release()
+		return
+	}
+	a.mutex.Lock()
+	conn := a.conn
+	a.mutex.Unlock()
+	conn.Destroy()
 }
 
 //实现代理接口(gate.Agent)UserData函数
 //获取用户数据
 func (a *agent) UserData() interface{} {
-	if a.userData == nil {
+	data := a.getUserData()
+	if data == nil {
 		return nil
 	}
-	userData, ok := a.userData.(user.UserData)
+	userData, ok := data.(user.UserData)
 	if !ok {
 		log.Error("user data %v is not valid:  ", userData)
 		return nil
@@ -223,11 +473,20 @@ func (a *agent) UserData() interface{} {
 		log.Release("user data %v is expired: ", userData)
 		return nil
 	}
+	return data
+}
+
+// 读取用户数据，不检查是否过期
+func (a *agent) getUserData() interface{} {
+	a.dataMutex.RLock()
+	defer a.dataMutex.RUnlock()
 	return a.userData
 }
 
 //实现代理接口(gate.Agent)SetUserData函数
 //设置用户数据
 func (a *agent) SetUserData(data interface{}) {
+	a.dataMutex.Lock()
 	a.userData = data
+	a.dataMutex.Unlock()
 }
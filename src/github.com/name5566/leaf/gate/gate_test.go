@@ -0,0 +1,32 @@
+package gate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/name5566/leaf/gate/user"
+)
+
+func ExampleInfo() {
+	g, events := newSessionGate(time.Minute)
+	c := newTestConn("client")
+	a := g.serve(c)
+	<-events
+
+	// 登陆处理函数在模块goroutine中设置用户数据，Info可以同时读取
+	done := make(chan struct{})
+	go func() {
+		a.SetUserData(user.UserData{UserID: 1, Expired: time.Now().Add(time.Hour)})
+		close(done)
+	}()
+	Info(a)
+	<-done
+	fmt.Println(Info(a).UserID, Info(a).RemoteAddr)
+
+	a.Close()
+	<-c.done
+	<-events
+
+	// Output:
+	// 1 client
+}
@@ -0,0 +1,118 @@
+package gate
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/gate/user"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"github.com/name5566/leaf/util"
+)
+
+// 常用消息拦截器
+// 通过Processor.Use添加，例如:
+// msg.Processor.Use(gate.Metrics(), gate.Recover(), gate.AccessLog(), gate.RequireLogin("ApiKeyCreate"))
+
+// 指定消息需要登陆，msgIDs为空时所有消息都需要登陆
+// 未登陆时返回401，不断开连接
+func RequireLogin(msgIDs ...string) network.Interceptor {
+	match := matchMsgID(msgIDs)
+	return func(ctx *network.MsgContext, next func() error) error {
+		if !match(ctx.MsgID) {
+			return next()
+		}
+		if getUserData(ctx.Agent) == nil {
+			ctx.WriteError(401, "unlogin")
+			return nil
+		}
+		return next()
+	}
+}
+
+// 每个agent每个消息的限流, rate: 每秒允许的消息数, burst: 允许的突发消息数
+// msgIDs为空时对所有消息限流，超出限制时返回429，不断开连接
+func RateLimit(rate float64, burst int, msgIDs ...string) network.Interceptor {
+	match := matchMsgID(msgIDs)
+	limiter := util.NewRateLimiter(rate, burst)
+	return func(ctx *network.MsgContext, next func() error) error {
+		if !match(ctx.MsgID) {
+			return next()
+		}
+		if !limiter.Allow(rateLimitKey{ctx.Agent, ctx.MsgID}) {
+			ctx.WriteError(429, "too many requests")
+			return nil
+		}
+		return next()
+	}
+}
+
+type rateLimitKey struct {
+	agent interface{}
+	msgID string
+}
+
+// 访问日志，记录远端地址、用户、消息ID和处理耗时
+func AccessLog() network.Interceptor {
+	return func(ctx *network.MsgContext, next func() error) error {
+		start := time.Now()
+		err := next()
+
+		entry := Logger(ctx.Agent).With(log.F("msg_id", ctx.MsgID), log.F("cost", time.Since(start)))
+		if err != nil {
+			entry = entry.With(log.F("err", err))
+		}
+		entry.Release("access")
+		return err
+	}
+}
+
+// 捕获消息处理中的panic，记录堆栈后返回500，不断开连接
+func Recover() network.Interceptor {
+	return func(ctx *network.MsgContext, next func() error) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				entry := Logger(ctx.Agent).With(log.F("msg_id", ctx.MsgID))
+				if conf.LenStackBuf > 0 {
+					buf := make([]byte, conf.LenStackBuf)
+					l := runtime.Stack(buf, false)
+					entry.Error("message panic: %v: %s", r, buf[:l])
+				} else {
+					entry.Error("message panic: %v", r)
+				}
+				ctx.WriteError(500, "server internal error")
+				err = nil
+			}
+		}()
+		return next()
+	}
+}
+
+// msgIDs为空时匹配所有消息
+func matchMsgID(msgIDs []string) func(msgID string) bool {
+	if len(msgIDs) == 0 {
+		return func(string) bool { return true }
+	}
+	set := make(map[string]struct{}, len(msgIDs))
+	for _, msgID := range msgIDs {
+		set[msgID] = struct{}{}
+	}
+	return func(msgID string) bool {
+		_, ok := set[msgID]
+		return ok
+	}
+}
+
+// 获取agent当前登陆的用户数据，未登陆或已过期返回nil
+func getUserData(a interface{}) *user.UserData {
+	agent, ok := a.(Agent)
+	if !ok {
+		return nil
+	}
+	userData, ok := agent.UserData().(user.UserData)
+	if !ok {
+		return nil
+	}
+	return &userData
+}
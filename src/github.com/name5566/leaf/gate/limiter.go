@@ -0,0 +1,119 @@
+package gate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"github.com/name5566/leaf/util"
+)
+
+// 超出限流后的处理方式
+const (
+	LimitReject     = "reject"     // 丢弃消息并返回429
+	LimitDelay      = "delay"      // 延迟处理消息，等待超过MaxDelay时按reject处理
+	LimitDisconnect = "disconnect" // 断开连接
+)
+
+// 消息限流配置，Rate为每秒允许的消息数，Burst为允许的突发消息数，Rate为0时不限制
+type LimitConf struct {
+	AgentRate  float64                 // 每个连接
+	AgentBurst int
+	IPRate     float64                 // 每个IP
+	IPBurst    int
+	MsgRates   map[string]MsgLimitConf // 每个连接的每个消息，key为msgID
+	Action     string                  // reject|delay|disconnect，默认reject
+	MaxDelay   int                     // delay时最长等待的毫秒数
+}
+
+type MsgLimitConf struct {
+	Rate  float64
+	Burst int
+}
+
+type limiter struct {
+	action   string
+	maxDelay time.Duration
+	agent    *util.RateLimiter
+	ip       *util.RateLimiter
+	msg      map[string]*util.RateLimiter
+}
+
+// 按配置限流的消息拦截器
+// 通过Processor.Use添加
+func Limit(conf LimitConf) network.Interceptor {
+	l := new(limiter)
+	l.action = conf.Action
+	switch l.action {
+	case LimitReject, LimitDelay, LimitDisconnect:
+	case "":
+		l.action = LimitReject
+	default:
+		log.Fatal("unknown limit action: %v", conf.Action)
+	}
+	l.maxDelay = time.Duration(conf.MaxDelay) * time.Millisecond
+	if conf.AgentRate > 0 {
+		l.agent = util.NewRateLimiter(conf.AgentRate, conf.AgentBurst)
+	}
+	if conf.IPRate > 0 {
+		l.ip = util.NewRateLimiter(conf.IPRate, conf.IPBurst)
+	}
+	l.msg = make(map[string]*util.RateLimiter)
+	for msgID, c := range conf.MsgRates {
+		if c.Rate > 0 {
+			l.msg[msgID] = util.NewRateLimiter(c.Rate, c.Burst)
+		}
+	}
+
+	return l.intercept
+}
+
+func (l *limiter) intercept(ctx *network.MsgContext, next func() error) error {
+	var ip string
+	if a, ok := ctx.Agent.(Agent); ok {
+		ip = network.RemoteIP(a.RemoteAddr())
+	}
+
+	type bucket struct {
+		l   *util.RateLimiter
+		key interface{}
+	}
+	buckets := make([]bucket, 0, 3)
+	if l.agent != nil {
+		buckets = append(buckets, bucket{l.agent, ctx.Agent})
+	}
+	if l.ip != nil && ip != "" {
+		buckets = append(buckets, bucket{l.ip, ip})
+	}
+	if ml, ok := l.msg[ctx.MsgID]; ok {
+		buckets = append(buckets, bucket{ml, rateLimitKey{ctx.Agent, ctx.MsgID}})
+	}
+
+	// 先预约所有桶，取最长的等待时间，不处理消息时归还所有预约
+	// 避免前面的桶已经扣除令牌而后面的桶拒绝
+	var wait time.Duration
+	for _, b := range buckets {
+		if d := b.l.Reserve(b.key); d > wait {
+			wait = d
+		}
+	}
+	if wait == 0 {
+		return next()
+	}
+	if l.action == LimitDelay && wait <= l.maxDelay {
+		time.Sleep(wait)
+		return next()
+	}
+	for _, b := range buckets {
+		b.l.Cancel(b.key)
+	}
+
+	if l.action == LimitDisconnect {
+		log.Release("rate limit exceeded, disconnect: remote: %v, msg: %v", ip, ctx.MsgID)
+		return fmt.Errorf("rate limit exceeded: %v", ctx.MsgID)
+	}
+	log.Debug("rate limit exceeded, reject: remote: %v, msg: %v", ip, ctx.MsgID)
+	ctx.WriteError(429, "too many requests")
+	return nil
+}
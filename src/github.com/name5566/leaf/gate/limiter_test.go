@@ -0,0 +1,79 @@
+package gate
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/name5566/leaf/network"
+)
+
+// 只记录回写消息的agent
+type limitAgent struct {
+	Agent
+}
+
+func (a *limitAgent) RemoteAddr() net.Addr { return testAddr("127.0.0.1:1000") }
+
+func (a *limitAgent) WriteMsg(msg interface{}) {
+	for msgID, v := range *msg.(*map[string]interface{}) {
+		fmt.Println(msgID, v.(map[string]interface{})["status"])
+	}
+}
+
+// 依次发送消息，打印是否处理
+func sendLimited(interceptor network.Interceptor, a Agent, msgIDs ...string) {
+	for _, msgID := range msgIDs {
+		err := interceptor(&network.MsgContext{MsgID: msgID, Agent: a}, func() error {
+			fmt.Println(msgID, "ok")
+			return nil
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func Example_limitReject() {
+	l := Limit(LimitConf{
+		AgentRate:  0.001,
+		AgentBurst: 2,
+		MsgRates:   map[string]MsgLimitConf{"Hello": {Rate: 0.001, Burst: 1}},
+	})
+
+	// 被Hello的桶拒绝时不扣除连接的令牌
+	sendLimited(l, new(limitAgent), "Hello", "Hello", "Other", "Other")
+
+	// Output:
+	// Hello ok
+	// Hello 429
+	// Other ok
+	// Other 429
+}
+
+func Example_limitDelay() {
+	l := Limit(LimitConf{
+		AgentRate:  100,
+		AgentBurst: 1,
+		MsgRates:   map[string]MsgLimitConf{"Hello": {Rate: 0.001, Burst: 1}},
+		Action:     LimitDelay,
+		MaxDelay:   10,
+	})
+
+	// 等待超过MaxDelay被拒绝时归还预约，下一条消息只需等待一个令牌
+	sendLimited(l, new(limitAgent), "Hello", "Hello", "Other")
+
+	// Output:
+	// Hello ok
+	// Hello 429
+	// Other ok
+}
+
+func Example_limitDisconnect() {
+	l := Limit(LimitConf{AgentRate: 0.001, AgentBurst: 1, Action: LimitDisconnect})
+
+	sendLimited(l, new(limitAgent), "Hello", "Hello")
+
+	// Output:
+	// Hello ok
+	// rate limit exceeded: Hello
+}
@@ -0,0 +1,45 @@
+package gate
+
+import (
+	"reflect"
+
+	"github.com/name5566/leaf/metrics"
+	"github.com/name5566/leaf/network"
+)
+
+// 消息统计，msg_id为消息ID
+var (
+	messagesIn    = metrics.NewCounter("leaf_messages_in_total", "Messages received from clients.", "msg_id")
+	messagesOut   = metrics.NewCounter("leaf_messages_out_total", "Messages sent to clients.", "msg_id")
+	marshalErrors = metrics.NewCounter("leaf_marshal_errors_total", "Messages failed to marshal.", "msg_id")
+)
+
+// 统计收到的消息数，应放在拦截器链的最前面
+func Metrics() network.Interceptor {
+	return func(ctx *network.MsgContext, next func() error) error {
+		messagesIn.Inc(ctx.MsgID)
+		return next()
+	}
+}
+
+// 消息的msgID，raw消息为MsgID()，map消息为唯一的key，其余为结构体类型名
+func msgName(msg interface{}) string {
+	if m, ok := msg.(interface {
+		MsgID() string
+	}); ok {
+		return m.MsgID()
+	}
+	if m, ok := msg.(*map[string]interface{}); ok && len(*m) == 1 {
+		for msgID := range *m {
+			return msgID
+		}
+	}
+	t := reflect.TypeOf(msg)
+	if t == nil {
+		return ""
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
@@ -0,0 +1,178 @@
+package gate
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"github.com/name5566/leaf/util"
+)
+
+// 会话恢复
+// Gate.SessionTTL大于0时启用，连接建立时通知NewAgent，收到第一条消息时创建会话
+// 连接断开后agent保留SessionTTL时间，期间发送的消息缓存在会话中，超时或网关关闭时才通知CloseAgent
+// 客户端重连后第一条消息发送Resume，恢复成功时重放客户端未收到的消息，新连接的agent通知CloseAgent，会话所属agent通知ResumeAgent
+// 恢复后agent对象不变，游戏模块中对agent的引用(房间、游戏状态等)仍然有效
+// Session和Resume需要注册到Processor
+
+// 新建或恢复会话时服务器发送给客户端
+// 之后服务器发送的每条消息序号依次加1，客户端需要记录收到的最后一条消息的序号
+type Session struct {
+	ID  string
+	Seq uint64 // 之后的消息序号从Seq+1开始
+}
+
+// 客户端重连后发送的第一条消息
+// 恢复失败时服务器发送新的Session，客户端需要重新初始化
+type Resume struct {
+	SessionID string
+	Seq       uint64 // 收到的最后一条消息的序号
+}
+
+type session struct {
+	id      string
+	seq     uint64      // 最后发送的消息序号
+	buffer  [][][]byte  // 最近发送的消息，最后一条的序号为seq
+	offline bool        // 连接已断开，等待恢复
+	closed  bool        // 会话已结束
+	gen     uint64      // 断开次数，用于判断超时是否仍然有效
+	timer   *time.Timer // 等待恢复的超时
+}
+
+// 所有未结束的会话，key为会话ID
+var sessions = new(util.Map)
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Error("generate session id error: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// 缓存发送的消息，超出SessionBufferLen时丢弃最早的
+func (s *session) push(data [][]byte, bufferLen int) {
+	s.seq++
+	s.buffer = append(s.buffer, data)
+	if len(s.buffer) > bufferLen {
+		s.buffer = s.buffer[len(s.buffer)-bufferLen:]
+	}
+}
+
+// 新建会话，agent在连接建立时已通知过NewAgent
+func (a *agent) openSession() {
+	s := &session{id: newSessionID()}
+	a.mutex.Lock()
+	a.session = s
+	a.write(&Session{ID: s.id})
+	a.mutex.Unlock()
+	sessions.Set(s.id, a)
+}
+
+// goroutine safe
+func (a *agent) getSession() *session {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.session
+}
+
+// 将当前连接绑定到Resume指定的会话，并重放客户端未收到的消息
+// 成功返回会话所属的agent，会话不存在、已结束或缓存的消息不足时返回nil
+func (a *agent) resumeSession(r *Resume) *agent {
+	v := sessions.Get(r.SessionID)
+	if v == nil {
+		return nil
+	}
+	sa := v.(*agent)
+
+	sa.mutex.Lock()
+	defer sa.mutex.Unlock()
+	s := sa.session
+	if s.closed || r.Seq > s.seq || s.seq-r.Seq > uint64(len(s.buffer)) {
+		return nil
+	}
+	if sa.processor != a.processor { // 缓存的消息已按原来的格式编码
+		return nil
+	}
+	if !s.offline { // 旧连接还未检测到断开，直接关闭
+		sa.conn.Close()
+	}
+	s.offline = false
+	sa.conn = a.conn
+	sa.write(&Session{ID: s.id, Seq: r.Seq})
+	for _, data := range s.buffer[len(s.buffer)-int(s.seq-r.Seq):] {
+		if err := sa.conn.WriteMsg(data...); err != nil {
+			log.Error("replay message error: %v", err)
+			break
+		}
+	}
+	return sa
+}
+
+// 连接断开，会话保留SessionTTL时间等待恢复
+func (a *agent) detachSession(conn network.Conn) {
+	a.mutex.Lock()
+	s := a.session
+	if a.conn != conn { // 已被新连接接管
+		a.mutex.Unlock()
+		return
+	}
+	if s.closed { // 主动关闭的会话不再恢复
+		a.mutex.Unlock()
+		a.release()
+		return
+	}
+	s.offline = true
+	s.gen++
+	gen := s.gen
+	s.timer = time.AfterFunc(a.gate.SessionTTL, func() {
+		a.expireSession(gen)
+	})
+	a.mutex.Unlock()
+}
+
+// 等待恢复超时，结束会话
+func (a *agent) expireSession(gen uint64) {
+	a.mutex.Lock()
+	s := a.session
+	if s.closed || !s.offline || s.gen != gen {
+		a.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	a.mutex.Unlock()
+	a.release()
+}
+
+// 主动关闭会话，连接断开后不再恢复
+// 返回true时会话已断开，需要调用者release，没有会话时返回false
+func (a *agent) closeSession() (offline bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	s := a.session
+	if s == nil || s.closed {
+		return false
+	}
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	return s.offline
+}
+
+// 网关关闭时结束所有等待恢复的会话，不再等待超时
+func (gate *Gate) closeSessions() {
+	var all []*agent
+	sessions.RLockRange(func(_ interface{}, v interface{}) {
+		if a := v.(*agent); a.gate == gate {
+			all = append(all, a)
+		}
+	})
+	for _, a := range all {
+		if a.closeSession() {
+			a.release()
+		}
+	}
+}
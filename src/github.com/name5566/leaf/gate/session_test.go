@@ -0,0 +1,215 @@
+package gate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/name5566/leaf/chanrpc"
+	jsonprocessor "github.com/name5566/leaf/network/json"
+)
+
+type testAddr string
+
+func (a testAddr) Network() string { return "test" }
+func (a testAddr) String() string  { return string(a) }
+
+// 内存中的连接，in为客户端发送的消息，out为服务器发送的消息
+type testConn struct {
+	name      string
+	in        chan []byte
+	out       chan []byte
+	done      chan struct{} // Run和OnClose都已返回
+	closeOnce sync.Once
+}
+
+func newTestConn(name string) *testConn {
+	return &testConn{name: name, in: make(chan []byte, 10), out: make(chan []byte, 100), done: make(chan struct{})}
+}
+
+func (c *testConn) ReadMsg() ([]byte, error) {
+	data, ok := <-c.in
+	if !ok {
+		return nil, errors.New("closed")
+	}
+	return data, nil
+}
+
+func (c *testConn) WriteMsg(args ...[]byte) error {
+	c.out <- bytes.Join(args, nil)
+	return nil
+}
+
+func (c *testConn) LocalAddr() net.Addr  { return testAddr("server") }
+func (c *testConn) RemoteAddr() net.Addr { return testAddr(c.name) }
+func (c *testConn) Close()               { c.closeOnce.Do(func() { close(c.in) }) }
+func (c *testConn) Destroy()             { c.Close() }
+
+// 客户端发送消息
+func (c *testConn) send(s string) {
+	c.in <- []byte(s)
+}
+
+// 客户端读取服务器发送的消息
+func (c *testConn) recv() string {
+	select {
+	case data := <-c.out:
+		return string(data)
+	case <-time.After(time.Second):
+		return "timeout"
+	}
+}
+
+type Hello struct {
+	Name string
+}
+
+// 与network中的服务器相同，每个连接一个goroutine
+func (gate *Gate) serve(c *testConn) *agent {
+	a := gate.newAgent(c, gate.Processor)
+	go func() {
+		a.Run()
+		a.OnClose()
+		close(c.done)
+	}()
+	return a
+}
+
+// 会话测试用的网关，AgentChanRPC收到的调用写入events
+func newSessionGate(ttl time.Duration) (*Gate, chan string) {
+	p := jsonprocessor.NewProcessor()
+	p.Register(&Session{})
+	p.Register(&Resume{})
+	p.Register(&Hello{})
+
+	events := make(chan string, 10)
+	s := chanrpc.NewServer(10)
+	for _, id := range []string{"NewAgent", "CloseAgent", "ResumeAgent"} {
+		id := id
+		s.Register(id, func(args []interface{}) {
+			events <- id + " " + args[0].(Agent).RemoteAddr().String()
+		})
+	}
+	go func() {
+		for {
+			s.Exec(<-s.ChanCall)
+		}
+	}()
+
+	return &Gate{Processor: p, AgentChanRPC: s, SessionTTL: ttl, SessionBufferLen: 10}, events
+}
+
+func Example_sessionResume() {
+	g, events := newSessionGate(time.Minute)
+
+	// 连接建立时通知NewAgent，第一条消息后创建会话
+	c1 := newTestConn("client1")
+	a := g.serve(c1)
+	fmt.Println(<-events, AgentCount())
+	c1.send(`{"Hello": {"Name": "server"}}`)
+	var session struct{ Session Session }
+	json.Unmarshal([]byte(c1.recv()), &session)
+	fmt.Println(session.Session.Seq)
+
+	a.WriteMsg(&Hello{Name: "1"})
+	a.WriteMsg(&Hello{Name: "2"})
+	fmt.Println(c1.recv(), c1.recv())
+
+	// 断开期间的消息只缓存
+	c1.Close()
+	<-c1.done
+	a.WriteMsg(&Hello{Name: "3"})
+	fmt.Println(Info(a).Offline, AgentCount())
+
+	// 客户端只收到了序号1，从序号2开始重放
+	c2 := newTestConn("client2")
+	g.serve(c2)
+	fmt.Println(<-events)
+	c2.send(fmt.Sprintf(`{"Resume": {"SessionID": "%v", "Seq": 1}}`, session.Session.ID))
+	fmt.Println(c2.recv() == fmt.Sprintf(`{"Session":{"ID":"%v","Seq":1}}`, session.Session.ID))
+	fmt.Println(c2.recv(), c2.recv())
+	// 新连接的agent由会话所属agent代替，恢复后agent的地址为新连接的地址
+	fmt.Println(<-events)
+	fmt.Println(<-events)
+	fmt.Println(Info(a).Offline, AgentCount())
+
+	// 主动关闭后不再恢复
+	a.Close()
+	<-c2.done
+	fmt.Println(<-events, AgentCount())
+
+	// Output:
+	// NewAgent client1 1
+	// 0
+	// {"Hello":{"Name":"1"}} {"Hello":{"Name":"2"}}
+	// true 1
+	// NewAgent client2
+	// true
+	// {"Hello":{"Name":"2"}} {"Hello":{"Name":"3"}}
+	// CloseAgent client2
+	// ResumeAgent client2
+	// false 1
+	// CloseAgent client2 0
+}
+
+func Example_sessionExpire() {
+	g, events := newSessionGate(20 * time.Millisecond)
+
+	c1 := newTestConn("client1")
+	g.serve(c1)
+	fmt.Println(<-events)
+	c1.send(`{"Hello": {}}`)
+	var session struct{ Session Session }
+	json.Unmarshal([]byte(c1.recv()), &session)
+	c1.Close()
+	<-c1.done
+
+	// 超时后通知CloseAgent
+	fmt.Println(<-events, AgentCount())
+
+	// 会话已结束，恢复失败时创建新会话
+	c2 := newTestConn("client2")
+	a := g.serve(c2)
+	fmt.Println(<-events)
+	c2.send(fmt.Sprintf(`{"Resume": {"SessionID": "%v", "Seq": 0}}`, session.Session.ID))
+	var session2 struct{ Session Session }
+	json.Unmarshal([]byte(c2.recv()), &session2)
+	fmt.Println(session2.Session.ID != session.Session.ID, session2.Session.Seq)
+
+	a.Close()
+	<-c2.done
+	fmt.Println(<-events, AgentCount())
+
+	// Output:
+	// NewAgent client1
+	// CloseAgent client1 0
+	// NewAgent client2
+	// true 0
+	// CloseAgent client2 0
+}
+
+func Example_sessionShutdown() {
+	g, events := newSessionGate(time.Hour)
+
+	c := newTestConn("client")
+	g.serve(c)
+	fmt.Println(<-events)
+	c.send(`{"Hello": {}}`)
+	c.recv()
+	c.Close()
+	<-c.done
+	fmt.Println(AgentCount())
+
+	// 网关关闭时不再等待恢复
+	g.closeSessions()
+	fmt.Println(<-events, AgentCount(), sessions.Len())
+
+	// Output:
+	// NewAgent client
+	// 1
+	// CloseAgent client 0 0
+}
@@ -1,11 +1,37 @@
 package user
 
-import "time"
+import (
+	"strings"
+	"time"
+)
+
+// 权限中表示所有的通配值
+const RightAll = "all"
 
 // 当前连接用户数据
 type UserData struct {
 	UserID  uint
 	Token   string
 	Expired time.Time
+	Rights  []string // 用户绑定的权限，格式为 Server:Name
+}
+
+// 构造权限字符串 Server:Name
+func Right(server string, name string) string {
+	return server + ":" + name
 }
 
+// 检查用户是否拥有权限right(Server:Name)
+// 用户拥有 all:all 或 Server:all 时视为拥有该服务下所有权限
+func (u UserData) HasRight(right string) bool {
+	server := right
+	if i := strings.Index(right, ":"); i >= 0 {
+		server = right[:i]
+	}
+	for _, r := range u.Rights {
+		if r == right || r == Right(RightAll, RightAll) || r == Right(server, RightAll) {
+			return true
+		}
+	}
+	return false
+}
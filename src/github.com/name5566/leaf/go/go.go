@@ -6,6 +6,7 @@ import (
 	"github.com/name5566/leaf/log"
 	"runtime"
 	"sync"
+	"sync/atomic"
 )
 
 // 善用 goroutine 能够充分利用多核资源，Leaf 提供的 Go 机制解决了原生 goroutine 存在的一些问题：
@@ -20,7 +21,7 @@ import (
 //Go类型定义
 type Go struct {
 	ChanCb    chan func() //回调管道，用于传输回调函数
-	pendingGo int         //待处理回调函数计数器
+	pendingGo int32       //待处理回调函数计数器，原子读写，Pending可以在其他goroutine中调用
 }
 
 //线性Go类型定义
@@ -47,7 +48,7 @@ func New(l int) *Go {
 //一般的Go函数
 //执行一个比较耗时的操作，并在执行完成后，将回调函数通过回调管道发送回原goroutine执行
 func (g *Go) Go(f func(), cb func()) {
-	g.pendingGo++ //增加待处理回调函数计数器
+	atomic.AddInt32(&g.pendingGo, 1) //增加待处理回调函数计数器
 
 	go func() { //在一个新的goroutine内执行
 		defer func() { //在f执行完后执行
@@ -71,7 +72,7 @@ func (g *Go) Go(f func(), cb func()) {
 //执行回调函数
 func (g *Go) Cb(cb func()) {
 	defer func() {
-		g.pendingGo-- //处理完一个，减少待处理回调函数计数器
+		atomic.AddInt32(&g.pendingGo, -1) //处理完一个，减少待处理回调函数计数器
 		//异常处理
 		if r := recover(); r != nil {
 			if conf.LenStackBuf > 0 {
@@ -91,13 +92,18 @@ func (g *Go) Cb(cb func()) {
 
 //关闭Go
 func (g *Go) Close() {
-	for g.pendingGo > 0 { //如果有待处理的回调函数
+	for atomic.LoadInt32(&g.pendingGo) > 0 { //如果有待处理的回调函数
 		g.Cb(<-g.ChanCb) //从管道中读出来进行执行
 	}
 }
 
 func (g *Go) Idle() bool {
-	return g.pendingGo == 0
+	return atomic.LoadInt32(&g.pendingGo) == 0
+}
+
+//待处理的回调数
+func (g *Go) Pending() int {
+	return int(atomic.LoadInt32(&g.pendingGo))
 }
 
 //创建线性上下文
@@ -110,7 +116,7 @@ func (g *Go) NewLinearContext() *LinearContext {
 
 //线性上下文的Go函数
 func (c *LinearContext) Go(f func(), cb func()) {
-	c.g.pendingGo++ //增加待处理回调函数计数器
+	atomic.AddInt32(&c.g.pendingGo, 1) //增加待处理回调函数计数器
 
 	c.mutexLinearGo.Lock()                       //链表加锁
 	c.linearGo.PushBack(&LinearGo{f: f, cb: cb}) //向链表添加元素
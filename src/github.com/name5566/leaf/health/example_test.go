@@ -0,0 +1,29 @@
+package health_test
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/name5566/leaf/health"
+)
+
+func ExampleReady() {
+	health.Register("cache", func() error { return nil })
+	health.Register("queue", func() error { return errors.New("connection refused") })
+
+	report := health.Ready()
+	fmt.Println(report.Status)
+	for _, r := range report.Checks {
+		if r.Error != "" {
+			fmt.Println(r.Name, r.Status, r.Error)
+		} else {
+			fmt.Println(r.Name, r.Status)
+		}
+	}
+
+	// Output:
+	// fail
+	// cache ok
+	// drain ok
+	// queue fail connection refused
+}
@@ -0,0 +1,159 @@
+// 存活和就绪检查，供负载均衡和Kubernetes探针使用
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/name5566/leaf/module"
+)
+
+// 单项检查的超时时间，超时视为失败
+var Timeout = 3 * time.Second
+
+// 检查函数，返回nil表示正常
+type Check func() error
+
+// 单项检查结果
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"` // ok或fail
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// 检查响应
+// {"status": "ok", "checks": [{"name": "redis", "status": "ok", "latency_ms": 0.42}]}
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+var (
+	mutex  sync.Mutex
+	checks = make(map[string]Check)
+)
+
+func init() {
+	Register("drain", func() error {
+		if module.Draining() {
+			return errors.New("draining")
+		}
+		return nil
+	})
+}
+
+// goroutine safe
+// 注册就绪检查，重名时panic
+func Register(name string, check Check) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := checks[name]; ok {
+		panic("health check " + name + " already registered")
+	}
+	checks[name] = check
+}
+
+// 存活检查，所有模块的Run都在运行时正常，失败的模块会显示状态
+func Live() *Report {
+	report := &Report{Status: "ok", Checks: []Result{}}
+	for _, s := range module.Statuses() {
+		r := Result{Name: "module:" + s.Name, Status: "ok"}
+		if !s.Running {
+			r.Status = "fail"
+			r.Error = "module is " + s.State
+			report.Status = "fail"
+		}
+		report.Checks = append(report.Checks, r)
+	}
+	return report
+}
+
+// 就绪检查，并发执行所有注册的检查，全部正常时为ok
+func Ready() *Report {
+	mutex.Lock()
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	cs := make(map[string]Check, len(checks))
+	for name, check := range checks {
+		cs[name] = check
+	}
+	mutex.Unlock()
+	sort.Strings(names)
+
+	report := &Report{Status: "ok", Checks: make([]Result, len(names))}
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			report.Checks[i] = run(name, cs[name])
+		}(i, name)
+	}
+	wg.Wait()
+	for _, r := range report.Checks {
+		if r.Status != "ok" {
+			report.Status = "fail"
+		}
+	}
+	return report
+}
+
+// 执行单项检查，超过Timeout时返回失败，检查函数继续在后台执行
+func run(name string, check Check) Result {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- errors.New("panic in check")
+			}
+		}()
+		done <- check()
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(Timeout):
+		err = errors.New("timeout")
+	}
+	r := Result{
+		Name:      name,
+		Status:    "ok",
+		LatencyMs: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		r.Status = "fail"
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// /healthz的http处理器，失败时返回503
+func LiveHandler() http.Handler {
+	return handler(Live)
+}
+
+// /readyz的http处理器，失败时返回503
+func ReadyHandler() http.Handler {
+	return handler(Ready)
+}
+
+func handler(f func() *Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := f()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		if report.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
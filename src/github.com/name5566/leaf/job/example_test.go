@@ -0,0 +1,213 @@
+package job_test
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/job"
+	"github.com/name5566/leaf/module"
+)
+
+// 在goroutine中运行的模块
+type testModule struct {
+	skeleton *module.Skeleton
+	closeSig chan bool
+	done     chan struct{}
+}
+
+func newTestModule() *testModule {
+	server := chanrpc.NewServer(10)
+	server.Register("exec", func(args []interface{}) {
+		args[0].(func())()
+	})
+	m := &testModule{
+		skeleton: &module.Skeleton{
+			GoLen:              10,
+			TimerDispatcherLen: 10,
+			ChanRPCServer:      server,
+		},
+		closeSig: make(chan bool),
+		done:     make(chan struct{}),
+	}
+	m.skeleton.Init()
+	go func() {
+		m.skeleton.Run(m.closeSig)
+		close(m.done)
+	}()
+	return m
+}
+
+// 在模块goroutine中执行f，执行完后返回
+func (m *testModule) exec(f func()) {
+	m.skeleton.ChanRPCServer.Call0("exec", f)
+}
+
+func (m *testModule) close() {
+	m.closeSig <- true
+	<-m.done
+}
+
+func newScheduler(m *testModule, store job.Store) *job.Scheduler {
+	return &job.Scheduler{
+		Name:         "game",
+		PollInterval: 10 * time.Millisecond,
+		Store:        store,
+		Runner:       m.skeleton,
+		Lease:        job.LocalLease{},
+	}
+}
+
+// 等待任务有n条执行记录，执行记录在任务执行后保存
+func waitRuns(s *job.Scheduler, id string, n int) []*job.Run {
+	for i := 0; i < 100; i++ {
+		if runs, _ := s.History(id, 10); len(runs) >= n {
+			return runs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func ExampleScheduler() {
+	m := newTestModule()
+	defer m.close()
+
+	// 多个节点时使用NewRedisStore或NewPostgreStore，Lease为nil时使用redis租约
+	s := newScheduler(m, job.NewMemoryStore())
+	s.Register("dailyReset", func(j *job.Job) error {
+		fmt.Println("reset", j.Payload)
+		return nil
+	})
+	s.Register("seasonEnd", func(j *job.Job) error {
+		fmt.Println("season end", j.Payload)
+		return nil
+	})
+	m.exec(s.Start)
+	defer m.exec(s.Stop)
+
+	// 每天上海时间零点重置，每次启动时调用不会改变下次执行时间
+	if err := s.Cron("daily-reset", "dailyReset", "CRON_TZ=Asia/Shanghai @daily", "cn"); err != nil {
+		fmt.Println(err)
+		return
+	}
+	// 一次性任务，执行后删除
+	if err := s.Once("season-3", "seasonEnd", time.Now(), "3"); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, r := range waitRuns(s, "season-3", 1) {
+		fmt.Println(r.JobID, r.Handler, r.Err == "")
+	}
+	jobs, _ := s.List()
+	for _, j := range jobs {
+		fmt.Println(j.ID, j.Next.After(time.Now()))
+	}
+
+	// Output:
+	// season end 3
+	// season-3 seasonEnd true
+	// daily-reset true
+}
+
+// 停机期间错过多次执行的周期任务启动后只补执行一次
+func ExampleScheduler_catchUp() {
+	m := newTestModule()
+	defer m.close()
+
+	store := job.NewMemoryStore()
+	store.Save(&job.Job{
+		ID:      "daily-reset",
+		Handler: "dailyReset",
+		Cron:    "@daily",
+		Next:    time.Now().Add(-72 * time.Hour),
+		Created: time.Now().Add(-100 * time.Hour),
+	})
+
+	s := newScheduler(m, store)
+	s.Register("dailyReset", func(j *job.Job) error {
+		return fmt.Errorf("reset failed")
+	})
+	m.exec(s.Start)
+	defer m.exec(s.Stop)
+
+	waitRuns(s, "daily-reset", 1)
+	time.Sleep(5 * s.PollInterval)
+	runs, _ := s.History("daily-reset", 10)
+	j, _ := store.Get("daily-reset")
+	fmt.Println(len(runs), runs[0].Err, j.Next.After(time.Now()))
+
+	// Output:
+	// 1 reset failed true
+}
+
+// 多个节点同时读取到期任务时，只有更新下次执行时间成功的节点执行
+func ExampleScheduler_claim() {
+	store := job.NewMemoryStore()
+	for i := 0; i < 10; i++ {
+		store.Save(&job.Job{ID: fmt.Sprint("job-", i), Handler: "count", Next: time.Now()})
+	}
+
+	var mutex sync.Mutex
+	count := make(map[string]int)
+	for i := 0; i < 2; i++ {
+		m := newTestModule()
+		defer m.close()
+		// LocalLease使两个节点都认为自己持有租约
+		s := newScheduler(m, store)
+		s.Register("count", func(j *job.Job) error {
+			mutex.Lock()
+			count[j.ID]++
+			mutex.Unlock()
+			return nil
+		})
+		m.exec(s.Start)
+		defer m.exec(s.Stop)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	jobs, _ := store.List()
+	mutex.Lock()
+	max := 0
+	for _, n := range count {
+		if n > max {
+			max = n
+		}
+	}
+	fmt.Println(len(jobs), len(count), max)
+	mutex.Unlock()
+
+	// Output:
+	// 0 10 1
+}
+
+func ExampleMemoryStore() {
+	store := job.NewMemoryStore()
+	next := time.Now().Add(time.Hour)
+	store.Save(&job.Job{ID: "daily-reset", Handler: "dailyReset", Cron: "@daily", Next: next})
+
+	// 下次执行时间已被其他节点更新时失败
+	j, _ := store.Get("daily-reset")
+	fmt.Println(store.Claim(j, next.Add(24*time.Hour)))
+	fmt.Println(store.Claim(j, next.Add(24*time.Hour)))
+
+	// 执行记录只保留最近RunHistoryLen条
+	defer func(n int) { job.RunHistoryLen = n }(job.RunHistoryLen)
+	job.RunHistoryLen = 3
+	for i := 0; i < 5; i++ {
+		store.AddRun(&job.Run{JobID: "daily-reset", Err: fmt.Sprint(i)})
+	}
+	runs, _ := store.Runs("daily-reset", 10)
+	for _, r := range runs {
+		fmt.Println(r.Err)
+	}
+
+	// Output:
+	// true <nil>
+	// false <nil>
+	// 4
+	// 3
+	// 2
+}
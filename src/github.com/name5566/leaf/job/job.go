@@ -0,0 +1,342 @@
+// 持久化的定时任务
+// 任务保存在redis或postgre中，重启后继续执行，停机期间错过的执行在启动后补执行一次
+// 单节点或测试时可以使用MemoryStore和LocalLease
+// 多个节点通过redis租约选出一个节点执行任务，每个任务在集群中只执行一次
+//
+// 执行前先把任务的下次执行时间写入存储(一次性任务则删除)，写入成功才执行，
+// 所以租约切换时也不会重复执行，但执行中节点崩溃时该次执行会丢失
+package job
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
+	"github.com/name5566/leaf/timer"
+)
+
+var jobLog = log.Module("job")
+
+var (
+	jobRuns    = metrics.NewCounter("leaf_job_runs_total", "Scheduled job runs by result.", "scheduler", "handler", "result")
+	jobSeconds = metrics.NewHistogram("leaf_job_run_seconds", "Time spent running scheduled jobs.", nil, "scheduler", "handler")
+)
+
+// 定时任务
+type Job struct {
+	ID      string
+	Handler string    // 处理函数名
+	Cron    string    // cron表达式，为空时为一次性任务
+	Payload string    // 传给处理函数的数据
+	Next    time.Time // 下次执行时间，精确到毫秒
+	Created time.Time
+}
+
+// 一次执行记录
+type Run struct {
+	JobID     string
+	Handler   string
+	Scheduled time.Time // 计划执行时间
+	Start     time.Time
+	Duration  time.Duration
+	Node      string
+	Err       string // 为空时执行成功
+}
+
+// 任务存储
+type Store interface {
+	// 添加或替换任务
+	Save(j *Job) error
+	// 任务不存在时返回nil, nil
+	Get(id string) (*Job, error)
+	Remove(id string) error
+	List() ([]*Job, error)
+	// 下次执行时间不晚于now的任务，按执行时间排列
+	Due(now time.Time, limit int) ([]*Job, error)
+	// 任务的下次执行时间仍为j.Next时更新为next，next为零值时删除任务，返回是否更新成功
+	Claim(j *Job, next time.Time) (bool, error)
+	AddRun(r *Run) error
+	// 最近n次执行记录，从新到旧
+	Runs(id string, n int) ([]*Run, error)
+}
+
+// 模块的定时器和Go，module.Skeleton实现了该接口
+type Runner interface {
+	AfterFunc(d time.Duration, cb func()) *timer.Timer
+	Go(f func(), cb func())
+}
+
+// 任务处理函数，在模块goroutine中执行
+type Handler func(j *Job) error
+
+// 调度器
+// Register和Start、Stop在模块goroutine中调用，添加和查询任务的函数goroutine safe
+type Scheduler struct {
+	Name         string        // 租约名，同名的调度器在集群中只有一个执行任务
+	PollInterval time.Duration // 检查到期任务的间隔，默认1秒
+	LeaseTTL     time.Duration // 租约时长，默认10秒，执行任务的节点崩溃后其他节点最多等待该时长接管
+	BatchSize    int           // 每次最多执行的任务数，默认100
+	Store        Store
+	Runner       Runner
+	Lease        Lease // 为nil时使用名为Name的redis租约
+
+	handlers map[string]Handler
+	unknown  map[string]bool // 已记录日志的未注册处理函数
+	timer    *timer.Timer
+	running  bool
+}
+
+// 节点名，记录在执行记录中
+var node = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%v-%v", host, os.Getpid())
+}()
+
+// 注册处理函数，需要在Start之前调用
+func (s *Scheduler) Register(name string, h Handler) {
+	if s.handlers == nil {
+		s.handlers = make(map[string]Handler)
+	}
+	if _, ok := s.handlers[name]; ok {
+		panic(fmt.Sprintf("job handler %v: already registered", name))
+	}
+	s.handlers[name] = h
+}
+
+// 开始检查和执行到期任务
+func (s *Scheduler) Start() {
+	if s.Name == "" || s.Store == nil || s.Runner == nil {
+		panic("invalid Scheduler")
+	}
+	if s.PollInterval <= 0 {
+		s.PollInterval = time.Second
+	}
+	if s.LeaseTTL <= 0 {
+		s.LeaseTTL = 10 * time.Second
+	}
+	if s.BatchSize <= 0 {
+		s.BatchSize = 100
+	}
+	s.unknown = make(map[string]bool)
+	if s.Lease == nil {
+		s.Lease = newLease(s.Name, s.LeaseTTL)
+	}
+	s.running = true
+	s.timer = s.Runner.AfterFunc(s.PollInterval, s.poll)
+}
+
+// 停止调度并释放租约，正在执行的任务不受影响
+func (s *Scheduler) Stop() {
+	if !s.running {
+		return
+	}
+	s.running = false
+	s.timer.Stop()
+	if err := s.Lease.Release(); err != nil {
+		jobLog.Error("release job lease %v: %v", s.Name, err)
+	}
+}
+
+// 是否持有租约
+func (s *Scheduler) Leader() bool {
+	return s.Lease != nil && s.Lease.Held()
+}
+
+// 添加一次性任务，在at执行，已存在时替换
+func (s *Scheduler) Once(id string, handler string, at time.Time, payload string) error {
+	return s.Store.Save(&Job{
+		ID:      id,
+		Handler: handler,
+		Payload: payload,
+		Next:    truncate(at),
+		Created: time.Now(),
+	})
+}
+
+// 添加周期任务，cron格式同timer.NewCronExpr，可以带CRON_TZ=指定时区
+// 已存在相同的任务时保留其下次执行时间，所以可以在每次启动时调用
+func (s *Scheduler) Cron(id string, handler string, cron string, payload string) error {
+	cronExpr, err := timer.NewCronExpr(cron)
+	if err != nil {
+		return err
+	}
+	old, err := s.Store.Get(id)
+	if err != nil {
+		return err
+	}
+	if old != nil && old.Handler == handler && old.Cron == cron && old.Payload == payload {
+		return nil
+	}
+	now := time.Now()
+	next := cronExpr.Next(now)
+	if next.IsZero() {
+		return fmt.Errorf("cron %v: no next time", cron)
+	}
+	return s.Store.Save(&Job{
+		ID:      id,
+		Handler: handler,
+		Cron:    cron,
+		Payload: payload,
+		Next:    truncate(next),
+		Created: now,
+	})
+}
+
+// 删除任务
+func (s *Scheduler) Remove(id string) error {
+	return s.Store.Remove(id)
+}
+
+// 所有任务
+func (s *Scheduler) List() ([]*Job, error) {
+	return s.Store.List()
+}
+
+// 最近n次执行记录，从新到旧
+func (s *Scheduler) History(id string, n int) ([]*Run, error) {
+	return s.Store.Runs(id, n)
+}
+
+// 在Go中续约和读取到期任务，回调中执行
+func (s *Scheduler) poll() {
+	var jobs []*Job
+	var err error
+	s.Runner.Go(func() {
+		var leader bool
+		leader, err = s.Lease.Acquire()
+		if err != nil || !leader {
+			return
+		}
+		jobs, err = s.claim()
+	}, func() {
+		if err != nil {
+			jobLog.Error("poll jobs %v: %v", s.Name, err)
+		}
+		if !s.running {
+			return
+		}
+		if len(jobs) == 0 {
+			s.timer = s.Runner.AfterFunc(s.PollInterval, s.poll)
+			return
+		}
+		runs := make([]*Run, 0, len(jobs))
+		for _, j := range jobs {
+			runs = append(runs, s.run(j))
+		}
+		s.record(runs)
+	})
+}
+
+// 读取到期任务并更新下次执行时间，返回更新成功的任务
+func (s *Scheduler) claim() ([]*Job, error) {
+	due, err := s.Store.Due(time.Now(), s.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*Job
+	for _, j := range due {
+		// 处理函数未注册时不执行，滚动更新时由注册了的节点执行
+		if s.handlers[j.Handler] == nil {
+			if !s.unknown[j.Handler] {
+				s.unknown[j.Handler] = true
+				jobLog.Release("job %v: handler %v not registered on this node", j.ID, j.Handler)
+			}
+			continue
+		}
+		var next time.Time
+		if j.Cron != "" {
+			cronExpr, err := timer.NewCronExpr(j.Cron)
+			if err != nil {
+				jobLog.Error("job %v: %v", j.ID, err)
+				continue
+			}
+			// 停机期间错过的执行只补一次
+			next = truncate(cronExpr.Next(time.Now()))
+			if next.IsZero() {
+				jobLog.Error("job %v: cron %v has no next time", j.ID, j.Cron)
+				continue
+			}
+		}
+		ok, err := s.Store.Claim(j, next)
+		if err != nil {
+			return jobs, err
+		}
+		if ok {
+			jobs = append(jobs, j)
+		}
+	}
+	return jobs, nil
+}
+
+// 执行任务，返回执行记录
+func (s *Scheduler) run(j *Job) (r *Run) {
+	r = &Run{
+		JobID:     j.ID,
+		Handler:   j.Handler,
+		Scheduled: j.Next,
+		Start:     time.Now(),
+		Node:      node,
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			buf := make([]byte, conf.LenStackBuf)
+			l := runtime.Stack(buf, false)
+			r.Err = fmt.Sprintf("panic: %v", v)
+			jobLog.Error("job %v panic: %v: %s", j.ID, v, buf[:l])
+		}
+		r.Duration = time.Since(r.Start)
+
+		result := "ok"
+		if r.Err != "" {
+			result = "error"
+		}
+		jobRuns.Inc(s.Name, j.Handler, result)
+		jobSeconds.Observe(r.Duration.Seconds(), s.Name, j.Handler)
+	}()
+
+	h := s.handlers[j.Handler]
+	if h == nil {
+		r.Err = "handler not registered"
+		return
+	}
+	if err := h(j); err != nil {
+		r.Err = err.Error()
+		jobLog.Error("job %v: %v", j.ID, err)
+	}
+	return
+}
+
+// 在Go中保存执行记录，然后立即检查下一批到期任务
+func (s *Scheduler) record(runs []*Run) {
+	s.Runner.Go(func() {
+		for _, r := range runs {
+			if err := s.Store.AddRun(r); err != nil {
+				jobLog.Error("record job run %v: %v", r.JobID, err)
+			}
+		}
+	}, func() {
+		if s.running {
+			s.timer = s.Runner.AfterFunc(0, s.poll)
+		}
+	})
+}
+
+// 存储精确到毫秒
+func truncate(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return time.Unix(0, millis(t)*int64(time.Millisecond)).In(t.Location())
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
@@ -0,0 +1,114 @@
+package job
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	rredis "github.com/gomodule/redigo/redis"
+	"github.com/name5566/leaf/db/redis"
+)
+
+const LeaseKeyFmt string = "QUANTITY_JOB:LEASE:%s" // 租约的key格式，值为持有者
+
+// 持有者是自己时续约
+var renewScript = rredis.NewScript(1, `
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// 持有者是自己时删除
+var releaseScript = rredis.NewScript(1, `
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+// 选出执行任务的节点，同一时间只有一个持有者
+type Lease interface {
+	// 续约，没有持有者时获取，返回是否持有租约
+	Acquire() (bool, error)
+	// 释放租约，其他节点可以立即接管
+	Release() error
+	Held() bool
+}
+
+// 只有一个节点时使用，总是持有租约
+type LocalLease struct{}
+
+func (LocalLease) Acquire() (bool, error) { return true, nil }
+func (LocalLease) Release() error         { return nil }
+func (LocalLease) Held() bool             { return true }
+
+// redis租约，同一时间只有一个持有者
+type lease struct {
+	key    string
+	owner  string
+	ttl    time.Duration
+	leader int32
+}
+
+func newLease(name string, ttl time.Duration) *lease {
+	return &lease{
+		key:   fmt.Sprintf(LeaseKeyFmt, name),
+		owner: fmt.Sprintf("%v-%x", node, rand.Int63()),
+		ttl:   ttl,
+	}
+}
+
+// 续约，没有持有者时获取，返回是否持有租约
+func (l *lease) Acquire() (bool, error) {
+	c := redis.RedisClient.Get()
+	defer c.Close()
+
+	ttl := int64(l.ttl / time.Millisecond)
+	renewed, err := rredis.Int(renewScript.Do(c, l.key, l.owner, ttl))
+	if err == nil && renewed == 0 {
+		var reply interface{}
+		reply, err = c.Do("set", l.key, l.owner, "nx", "px", ttl)
+		renewed = 0
+		if reply != nil {
+			renewed = 1
+		}
+	}
+	if err != nil {
+		// 无法确认时视为失去租约
+		l.set(false)
+		return false, err
+	}
+	l.set(renewed == 1)
+	return renewed == 1, nil
+}
+
+func (l *lease) set(leader bool) {
+	var v int32
+	if leader {
+		v = 1
+	}
+	if atomic.SwapInt32(&l.leader, v) != v {
+		if leader {
+			jobLog.Release("job lease %v acquired by %v", l.key, l.owner)
+		} else {
+			jobLog.Release("job lease %v lost by %v", l.key, l.owner)
+		}
+	}
+}
+
+func (l *lease) Held() bool {
+	return atomic.LoadInt32(&l.leader) == 1
+}
+
+// 释放租约，其他节点可以立即接管
+func (l *lease) Release() error {
+	if !l.Held() {
+		return nil
+	}
+	c := redis.RedisClient.Get()
+	defer c.Close()
+
+	_, err := releaseScript.Do(c, l.key, l.owner)
+	l.set(false)
+	return err
+}
@@ -0,0 +1,118 @@
+package job
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 任务保存在内存中，重启后丢失，只用于单节点或测试
+type MemoryStore struct {
+	mutex sync.Mutex
+	jobs  map[string]*Job
+	runs  map[string][]*Run // 从新到旧
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		jobs: make(map[string]*Job),
+		runs: make(map[string][]*Run),
+	}
+}
+
+// 保存和返回副本，调用者修改不影响存储
+func copyJob(j *Job) *Job {
+	c := *j
+	return &c
+}
+
+func (s *MemoryStore) Save(j *Job) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.jobs[j.ID] = copyJob(j)
+	return nil
+}
+
+func (s *MemoryStore) Get(id string) (*Job, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+	return copyJob(j), nil
+}
+
+func (s *MemoryStore) Remove(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.jobs, id)
+	return nil
+}
+
+func (s *MemoryStore) List() ([]*Job, error) {
+	return s.find(func(j *Job) bool { return true }, 0), nil
+}
+
+func (s *MemoryStore) Due(now time.Time, limit int) ([]*Job, error) {
+	return s.find(func(j *Job) bool { return !j.Next.After(now) }, limit), nil
+}
+
+// 按下次执行时间排列，limit为0时不限制
+func (s *MemoryStore) find(match func(j *Job) bool, limit int) []*Job {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var jobs []*Job
+	for _, j := range s.jobs {
+		if match(j) {
+			jobs = append(jobs, copyJob(j))
+		}
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		if !jobs[i].Next.Equal(jobs[k].Next) {
+			return jobs[i].Next.Before(jobs[k].Next)
+		}
+		return jobs[i].ID < jobs[k].ID
+	})
+	if limit > 0 && len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	return jobs
+}
+
+func (s *MemoryStore) Claim(j *Job, next time.Time) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	cur, ok := s.jobs[j.ID]
+	if !ok || millis(cur.Next) != millis(j.Next) {
+		return false, nil
+	}
+	if next.IsZero() {
+		delete(s.jobs, j.ID)
+	} else {
+		cur.Next = next
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) AddRun(r *Run) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c := *r
+	runs := append([]*Run{&c}, s.runs[r.JobID]...)
+	if len(runs) > RunHistoryLen {
+		runs = runs[:RunHistoryLen]
+	}
+	s.runs[r.JobID] = runs
+	return nil
+}
+
+func (s *MemoryStore) Runs(id string, n int) ([]*Run, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	runs := s.runs[id]
+	if n < len(runs) {
+		runs = runs[:n]
+	}
+	return append([]*Run(nil), runs...), nil
+}
@@ -0,0 +1,141 @@
+package job
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/name5566/leaf/db/postgre"
+	"github.com/name5566/leaf/db/postgre/model"
+)
+
+// 任务保存在postgre中，表结构见model.Job和model.JobRun
+type PostgreStore struct{}
+
+func NewPostgreStore() *PostgreStore {
+	return new(PostgreStore)
+}
+
+func toModel(j *Job) *model.Job {
+	return &model.Job{
+		JobID:   j.ID,
+		Handler: j.Handler,
+		Cron:    j.Cron,
+		Payload: j.Payload,
+		NextRun: millis(j.Next),
+		Created: millis(j.Created),
+	}
+}
+
+func fromModel(m *model.Job) *Job {
+	return &Job{
+		ID:      m.JobID,
+		Handler: m.Handler,
+		Cron:    m.Cron,
+		Payload: m.Payload,
+		Next:    fromMillis(m.NextRun),
+		Created: fromMillis(m.Created),
+	}
+}
+
+func (s *PostgreStore) Save(j *Job) error {
+	if err := postgre.DB.Save(toModel(j)).Error; err != nil {
+		return fmt.Errorf("job save err: %s", err)
+	}
+	return nil
+}
+
+func (s *PostgreStore) Get(id string) (*Job, error) {
+	m := new(model.Job)
+	res := postgre.DB.Where("job_id = ?", id).First(m)
+	if res.RecordNotFound() {
+		return nil, nil
+	}
+	if res.Error != nil {
+		return nil, fmt.Errorf("job get err: %s", res.Error)
+	}
+	return fromModel(m), nil
+}
+
+func (s *PostgreStore) Remove(id string) error {
+	if err := postgre.DB.Where("job_id = ?", id).Delete(&model.Job{}).Error; err != nil {
+		return fmt.Errorf("job remove err: %s", err)
+	}
+	return nil
+}
+
+func (s *PostgreStore) List() ([]*Job, error) {
+	return s.find(postgre.DB.Order("next_run"))
+}
+
+func (s *PostgreStore) Due(now time.Time, limit int) ([]*Job, error) {
+	return s.find(postgre.DB.Where("next_run <= ?", millis(now)).Order("next_run").Limit(limit))
+}
+
+func (s *PostgreStore) find(db *gorm.DB) ([]*Job, error) {
+	var ms []*model.Job
+	if err := db.Find(&ms).Error; err != nil {
+		return nil, fmt.Errorf("job find err: %s", err)
+	}
+	jobs := make([]*Job, len(ms))
+	for i, m := range ms {
+		jobs[i] = fromModel(m)
+	}
+	return jobs, nil
+}
+
+func (s *PostgreStore) Claim(j *Job, next time.Time) (bool, error) {
+	db := postgre.DB.Model(&model.Job{}).Where("job_id = ? AND next_run = ?", j.ID, millis(j.Next))
+	if next.IsZero() {
+		db = db.Delete(&model.Job{})
+	} else {
+		db = db.Update("next_run", millis(next))
+	}
+	if db.Error != nil {
+		return false, fmt.Errorf("job claim err: %s", db.Error)
+	}
+	return db.RowsAffected == 1, nil
+}
+
+func (s *PostgreStore) AddRun(r *Run) error {
+	m := &model.JobRun{
+		JobID:     r.JobID,
+		Handler:   r.Handler,
+		Scheduled: millis(r.Scheduled),
+		Started:   millis(r.Start),
+		Duration:  int64(r.Duration / time.Millisecond),
+		Node:      r.Node,
+		Err:       r.Err,
+	}
+	if err := postgre.DB.Create(m).Error; err != nil {
+		return fmt.Errorf("job run add err: %s", err)
+	}
+	// 只保留最近RunHistoryLen条
+	err := postgre.DB.Exec(`DELETE FROM job_run WHERE job_id = ? AND id <= (
+		SELECT id FROM job_run WHERE job_id = ? ORDER BY id DESC OFFSET ? LIMIT 1)`,
+		r.JobID, r.JobID, RunHistoryLen).Error
+	if err != nil {
+		return fmt.Errorf("job run trim err: %s", err)
+	}
+	return nil
+}
+
+func (s *PostgreStore) Runs(id string, n int) ([]*Run, error) {
+	var ms []*model.JobRun
+	if err := postgre.DB.Where("job_id = ?", id).Order("id DESC").Limit(n).Find(&ms).Error; err != nil {
+		return nil, fmt.Errorf("job runs err: %s", err)
+	}
+	runs := make([]*Run, len(ms))
+	for i, m := range ms {
+		runs[i] = &Run{
+			JobID:     m.JobID,
+			Handler:   m.Handler,
+			Scheduled: fromMillis(m.Scheduled),
+			Start:     fromMillis(m.Started),
+			Duration:  time.Duration(m.Duration) * time.Millisecond,
+			Node:      m.Node,
+			Err:       m.Err,
+		}
+	}
+	return runs, nil
+}
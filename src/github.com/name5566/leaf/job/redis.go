@@ -0,0 +1,195 @@
+package job
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	rredis "github.com/gomodule/redigo/redis"
+	"github.com/name5566/leaf/db/redis"
+)
+
+const JobKeyFmt string = "QUANTITY_JOB:%s:JOBS"    // 任务的hash，field为任务id，值为json
+const DueKeyFmt string = "QUANTITY_JOB:%s:DUE"     // 下次执行时间的zset，score为毫秒时间戳
+const RunKeyFmt string = "QUANTITY_JOB:%s:RUNS:%s" // 执行记录的list，从新到旧
+
+// 每个任务保留的执行记录数
+var RunHistoryLen = 100
+
+// 下次执行时间仍为ARGV[2]时更新为ARGV[3]，ARGV[3]为空时删除任务
+var claimScript = rredis.NewScript(2, `
+local score = redis.call("zscore", KEYS[1], ARGV[1])
+if not score or tonumber(score) ~= tonumber(ARGV[2]) then
+	return 0
+end
+if ARGV[3] == "" then
+	redis.call("zrem", KEYS[1], ARGV[1])
+	redis.call("hdel", KEYS[2], ARGV[1])
+else
+	redis.call("zadd", KEYS[1], ARGV[3], ARGV[1])
+	redis.call("hset", KEYS[2], ARGV[1], ARGV[4])
+end
+return 1`)
+
+// 任务保存在redis中
+type RedisStore struct {
+	jobKey string
+	dueKey string
+	name   string
+}
+
+// name区分不同的任务集合，一般与Scheduler.Name相同
+func NewRedisStore(name string) *RedisStore {
+	return &RedisStore{
+		jobKey: fmt.Sprintf(JobKeyFmt, name),
+		dueKey: fmt.Sprintf(DueKeyFmt, name),
+		name:   name,
+	}
+}
+
+func (s *RedisStore) Save(j *Job) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	c := redis.RedisClient.Get()
+	defer c.Close()
+
+	c.Send("multi")
+	c.Send("hset", s.jobKey, j.ID, data)
+	c.Send("zadd", s.dueKey, millis(j.Next), j.ID)
+	if _, err := c.Do("exec"); err != nil {
+		return fmt.Errorf("job save err: %s", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Get(id string) (*Job, error) {
+	data, err := rredis.Bytes(redis.Do("hget", s.jobKey, id))
+	if err == rredis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("job get err: %s", err)
+	}
+	j := new(Job)
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("invalid job %v: %v", id, err)
+	}
+	return j, nil
+}
+
+func (s *RedisStore) Remove(id string) error {
+	c := redis.RedisClient.Get()
+	defer c.Close()
+
+	c.Send("multi")
+	c.Send("hdel", s.jobKey, id)
+	c.Send("zrem", s.dueKey, id)
+	if _, err := c.Do("exec"); err != nil {
+		return fmt.Errorf("job remove err: %s", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) List() ([]*Job, error) {
+	res, err := rredis.StringMap(redis.Do("hgetall", s.jobKey))
+	if err != nil {
+		return nil, fmt.Errorf("job list err: %s", err)
+	}
+	jobs := make([]*Job, 0, len(res))
+	for id, data := range res {
+		j := new(Job)
+		if err := json.Unmarshal([]byte(data), j); err != nil {
+			jobLog.Error("invalid job %v: %v", id, err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+func (s *RedisStore) Due(now time.Time, limit int) ([]*Job, error) {
+	ids, err := rredis.Values(redis.Do("zrangebyscore", s.dueKey, "-inf", millis(now), "limit", 0, limit))
+	if err != nil {
+		return nil, fmt.Errorf("job due err: %s", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	res, err := rredis.ByteSlices(redis.Do("hmget", append([]interface{}{s.jobKey}, ids...)...))
+	if err != nil {
+		return nil, fmt.Errorf("job due err: %s", err)
+	}
+	var jobs []*Job
+	for i, data := range res {
+		if data == nil {
+			// 只有执行时间没有任务，删除
+			redis.Do("zrem", s.dueKey, ids[i])
+			continue
+		}
+		j := new(Job)
+		if err := json.Unmarshal(data, j); err != nil {
+			jobLog.Error("invalid job %s: %v", ids[i], err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+func (s *RedisStore) Claim(j *Job, next time.Time) (bool, error) {
+	c := redis.RedisClient.Get()
+	defer c.Close()
+
+	var score, data interface{} = "", ""
+	if !next.IsZero() {
+		updated := *j
+		updated.Next = next
+		b, err := json.Marshal(&updated)
+		if err != nil {
+			return false, err
+		}
+		score, data = millis(next), b
+	}
+	ok, err := rredis.Int(claimScript.Do(c, s.dueKey, s.jobKey, j.ID, millis(j.Next), score, data))
+	if err != nil {
+		return false, fmt.Errorf("job claim err: %s", err)
+	}
+	return ok == 1, nil
+}
+
+func (s *RedisStore) AddRun(r *Run) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	c := redis.RedisClient.Get()
+	defer c.Close()
+
+	key := fmt.Sprintf(RunKeyFmt, s.name, r.JobID)
+	c.Send("multi")
+	c.Send("lpush", key, data)
+	c.Send("ltrim", key, 0, RunHistoryLen-1)
+	if _, err := c.Do("exec"); err != nil {
+		return fmt.Errorf("job run add err: %s", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Runs(id string, n int) ([]*Run, error) {
+	res, err := rredis.ByteSlices(redis.Do("lrange", fmt.Sprintf(RunKeyFmt, s.name, id), 0, n-1))
+	if err != nil {
+		return nil, fmt.Errorf("job runs err: %s", err)
+	}
+	runs := make([]*Run, 0, len(res))
+	for _, data := range res {
+		r := new(Run)
+		if err := json.Unmarshal(data, r); err != nil {
+			jobLog.Error("invalid job run %v: %v", id, err)
+			continue
+		}
+		runs = append(runs, r)
+	}
+	return runs, nil
+}
@@ -3,22 +3,39 @@ package leaf
 import (
 	"os"
 	"os/signal"
+	"time"
 	"github.com/name5566/leaf/cluster"
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/console"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
 	"github.com/name5566/leaf/module"
 	"github.com/name5566/leaf/db/postgre"
 	"github.com/name5566/leaf/db/redis"
+	"github.com/name5566/leaf/health"
 )
 
 func Run(mods ...module.Module) { //...不定参数语法，参数类型都为module.Module
 	// logger
 	if conf.LogLevel != "" { //日志级别不为空
-		logger, err := log.New(conf.LogLevel, conf.LogPath, conf.LogFlag) //创建一个logger
+		logger, err := log.NewRotate(conf.LogLevel, conf.LogPath, conf.LogFlag, log.RotateConf{
+			MaxSize:    int64(conf.LogMaxSize) << 20,
+			Daily:      conf.LogDaily,
+			MaxBackups: conf.LogMaxBackups,
+			MaxAge:     time.Duration(conf.LogMaxAge) * 24 * time.Hour,
+			Compress:   conf.LogCompress,
+		}) //创建一个logger
 		if err != nil {
 			panic(err)
 		}
+		if err := logger.SetEncoder(conf.LogEncoder); err != nil {
+			panic(err)
+		}
+		for module, level := range conf.LogModuleLevels {
+			if err := logger.SetModuleLevel(module, level); err != nil {
+				panic(err)
+			}
+		}
 		log.Export(logger)   //替换默认的gLogger
 		defer logger.Close() //Run函数返回,关闭logger
 	}
@@ -37,24 +54,31 @@ func Run(mods ...module.Module) { //...不定参数语法，参数类型都为mo
 	// console
 	console.Init() //初始化控制台
 
+	// metrics
+	metrics.Init() //单独监听运行指标
+
 	// close
 	c := make(chan os.Signal, 1)                       //新建一个管道用于接收系统Signal
 	signal.Notify(c, os.Interrupt, os.Kill)            //监听SIGINT和SIGKILL信号(linux下叫这个名字)
 	sig := <-c                                         //读信号，没有信号时会阻塞goroutine
 	log.Release("Leaf closing down (signal: %v)", sig) //关键日志 服务器关闭
+	module.Drain()                                     //标记下线，就绪检查失败
 	console.Destroy()                                  //销毁控制台
+	metrics.Destroy()                                  //关闭运行指标监听
 	cluster.Destroy()                                  //销毁集群
 	module.Destroy()                                   //销毁模块
 }
 
 // 根据config.json初始化配置
-// 初始化postgre和redis
+// 初始化postgre和redis，并注册就绪检查
 func InitDB(confPath string) {
 	conf.InitConfig(confPath)
 	log.Release("Config: %v \n", conf.Config)
 	// 初始化postgre
 	postgre.InitDB()
+	health.Register("postgres", postgre.Ping)
 	// 初始化redis
 	redis.InitPool()
+	health.Register("redis", redis.Ping)
 }
 
@@ -26,4 +26,13 @@ func Example() {
 
 	log.Debug("will not print")
 	log.Release("My name is %v", name)
+
+	// 模块日志和字段
+	log.SetModuleLevel("gate", "debug")
+	entry := log.Module("gate").With(log.F("user_id", 1))
+	entry.Debug("My name is %v", name)
+
+	// json格式
+	logger.SetEncoder(log.JSONEncoder)
+	entry.With(log.F("msg_id", "Login")).Release("My name is %v", name)
 }
@@ -0,0 +1,46 @@
+package log
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// 日志级别
+// {"level": "release", "modules": {"network": "debug"}}
+type levels struct {
+	Level   string            `json:"level"`
+	Modules map[string]string `json:"modules"`
+}
+
+// 查看和修改日志级别的http处理器
+// GET返回当前级别
+// POST level=debug设置全局级别，module=gate&level=debug设置模块级别，level为空时恢复使用全局级别
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			module := r.FormValue("module")
+			level := r.FormValue("level")
+			var err error
+			if module != "" {
+				err = SetModuleLevel(module, level)
+			} else {
+				err = SetLevel(level)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			Release("log level changed: module=%q level=%q", module, level)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(levels{Level: Level(), Modules: ModuleLevels()})
+	})
+}
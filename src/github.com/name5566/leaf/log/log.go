@@ -1,12 +1,18 @@
 package log
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"path"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,62 +31,170 @@ const (
 	printFatalLevel   = "[fatal  ] "
 )
 
+var levelNames = []string{"debug", "release", "error", "fatal"}
+
+var printLevels = []string{printDebugLevel, printReleaseLevel, printErrorLevel, printFatalLevel}
+
+// 输出格式
+const (
+	ConsoleEncoder = "console" // [debug  ] [module] message key=value
+	JSONEncoder    = "json"    // {"time": ..., "level": "debug", "module": ..., "msg": ..., "key": value}
+)
+
+// 日志字段
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// 构造日志字段
+func F(key string, value interface{}) Field {
+	return Field{key, value}
+}
+
 type Logger struct {
-	level      int
+	level      int32 // 原子读写
 	baseLogger *log.Logger
-	baseFile   *os.File
+	baseFile   *rotateWriter
+	out        io.Writer
+	flag       int
+	json       bool
+	writeMutex sync.Mutex // json格式输出时保证每行完整
+
+	mutex        sync.RWMutex
+	moduleLevels map[string]int // 模块单独设置的级别
 }
 
-func New(strLevel string, pathname string, flag int) (*Logger, error) {
-	// level
-	var level int
+func parseLevel(strLevel string) (int, error) {
 	switch strings.ToLower(strLevel) {
 	case "debug":
-		level = debugLevel
+		return debugLevel, nil
 	case "release":
-		level = releaseLevel
+		return releaseLevel, nil
 	case "error":
-		level = errorLevel
+		return errorLevel, nil
 	case "fatal":
-		level = fatalLevel
+		return fatalLevel, nil
 	default:
-		return nil, errors.New("unknown level: " + strLevel)
+		return 0, errors.New("unknown level: " + strLevel)
+	}
+}
+
+func New(strLevel string, pathname string, flag int) (*Logger, error) {
+	return NewRotate(strLevel, pathname, flag, RotateConf{})
+}
+
+// 创建日志，pathname不为空时按rc切割和清理pathname下的日志文件
+func NewRotate(strLevel string, pathname string, flag int, rc RotateConf) (*Logger, error) {
+	// level
+	level, err := parseLevel(strLevel)
+	if err != nil {
+		return nil, err
 	}
 
 	// logger
 	var baseLogger *log.Logger
-	var baseFile *os.File
+	var baseFile *rotateWriter
+	var out io.Writer
 	if pathname != "" {
-		now := time.Now()
-
-		filename := fmt.Sprintf("%d%02d%02d_%02d_%02d_%02d.log",
-			now.Year(),
-			now.Month(),
-			now.Day(),
-			now.Hour(),
-			now.Minute(),
-			now.Second())
-
-		file, err := os.Create(path.Join(pathname, filename))
+		file, err := newRotateWriter(pathname, rc)
 		if err != nil {
 			return nil, err
 		}
 
 		baseLogger = log.New(file, "", flag)
 		baseFile = file
+		out = file
 	} else {
 		baseLogger = log.New(os.Stdout, "", flag)
+		out = os.Stdout
 	}
 
 	// new
 	logger := new(Logger)
-	logger.level = level
+	logger.level = int32(level)
 	logger.baseLogger = baseLogger
 	logger.baseFile = baseFile
+	logger.out = out
+	logger.flag = flag
+	logger.moduleLevels = make(map[string]int)
 
 	return logger, nil
 }
 
+// 设置输出格式，ConsoleEncoder或JSONEncoder
+// It's dangerous to call the method on logging
+func (logger *Logger) SetEncoder(encoder string) error {
+	switch encoder {
+	case ConsoleEncoder, "":
+		logger.json = false
+	case JSONEncoder:
+		logger.json = true
+	default:
+		return errors.New("unknown encoder: " + encoder)
+	}
+	return nil
+}
+
+// goroutine safe
+// 设置日志级别
+func (logger *Logger) SetLevel(strLevel string) error {
+	level, err := parseLevel(strLevel)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&logger.level, int32(level))
+	return nil
+}
+
+// goroutine safe
+// 当前日志级别
+func (logger *Logger) Level() string {
+	return levelNames[atomic.LoadInt32(&logger.level)]
+}
+
+// goroutine safe
+// 设置模块的日志级别，strLevel为空时恢复使用全局级别
+func (logger *Logger) SetModuleLevel(module string, strLevel string) error {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	if strLevel == "" {
+		delete(logger.moduleLevels, module)
+		return nil
+	}
+	level, err := parseLevel(strLevel)
+	if err != nil {
+		return err
+	}
+	logger.moduleLevels[module] = level
+	return nil
+}
+
+// goroutine safe
+// 单独设置了级别的模块
+func (logger *Logger) ModuleLevels() map[string]string {
+	logger.mutex.RLock()
+	defer logger.mutex.RUnlock()
+	levels := make(map[string]string, len(logger.moduleLevels))
+	for module, level := range logger.moduleLevels {
+		levels[module] = levelNames[level]
+	}
+	return levels
+}
+
+// 模块生效的日志级别
+func (logger *Logger) enabled(level int, module string) bool {
+	if module != "" {
+		logger.mutex.RLock()
+		moduleLevel, ok := logger.moduleLevels[module]
+		logger.mutex.RUnlock()
+		if ok {
+			return level >= moduleLevel
+		}
+	}
+	return level >= int(atomic.LoadInt32(&logger.level))
+}
+
 // It's dangerous to call the method on logging
 func (logger *Logger) Close() {
 	if logger.baseFile != nil {
@@ -92,21 +206,93 @@ func (logger *Logger) Close() {
 }
 
 func (logger *Logger) doPrintf(level int, printLevel string, format string, a ...interface{}) {
-	if level < logger.level {
+	logger.output(4, level, "", nil, format, a...)
+}
+
+// 输出一条日志，calldepth为调用者相对output的栈深度+1，和log.Logger.Output一致
+func (logger *Logger) output(calldepth int, level int, module string, fields []Field, format string, a ...interface{}) {
+	if !logger.enabled(level, module) {
 		return
 	}
 	if logger.baseLogger == nil {
 		panic("logger closed")
 	}
 
-	format = printLevel + format
-	logger.baseLogger.Output(3, fmt.Sprintf(format, a...))
+	msg := fmt.Sprintf(format, a...)
+	if logger.json {
+		logger.writeJSON(calldepth, level, module, fields, msg)
+	} else {
+		var buf bytes.Buffer
+		buf.WriteString(printLevels[level])
+		if module != "" {
+			buf.WriteString("[" + module + "] ")
+		}
+		buf.WriteString(strings.TrimRight(msg, "\n"))
+		for _, f := range fields {
+			fmt.Fprintf(&buf, " %v=%v", f.Key, fieldValue(f.Value))
+		}
+		logger.baseLogger.Output(calldepth, buf.String())
+	}
 
 	if level == fatalLevel {
 		os.Exit(1)
 	}
 }
 
+// {"time": "2006-01-02T15:04:05.000Z07:00", "level": "debug", "module": "gate", "caller": "gate.go:10", "msg": "...", fields...}
+func (logger *Logger) writeJSON(calldepth int, level int, module string, fields []Field, msg string) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	writeJSONValue(&buf, time.Now().Format("2006-01-02T15:04:05.000Z07:00"))
+	buf.WriteString(`,"level":`)
+	writeJSONValue(&buf, levelNames[level])
+	if module != "" {
+		buf.WriteString(`,"module":`)
+		writeJSONValue(&buf, module)
+	}
+	if logger.flag&(log.Lshortfile|log.Llongfile) != 0 {
+		if _, file, line, ok := runtime.Caller(calldepth); ok {
+			if logger.flag&log.Lshortfile != 0 {
+				file = filepath.Base(file)
+			}
+			buf.WriteString(`,"caller":`)
+			writeJSONValue(&buf, fmt.Sprintf("%v:%v", file, line))
+		}
+	}
+	buf.WriteString(`,"msg":`)
+	writeJSONValue(&buf, strings.TrimRight(msg, "\n"))
+	for _, f := range fields {
+		buf.WriteByte(',')
+		writeJSONValue(&buf, f.Key)
+		buf.WriteByte(':')
+		writeJSONValue(&buf, fieldValue(f.Value))
+	}
+	buf.WriteString("}\n")
+
+	logger.writeMutex.Lock()
+	logger.out.Write(buf.Bytes())
+	logger.writeMutex.Unlock()
+}
+
+// error和Stringer输出为字符串
+func fieldValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+func writeJSONValue(buf *bytes.Buffer, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(data)
+}
+
 func (logger *Logger) Debug(format string, a ...interface{}) {
 	logger.doPrintf(debugLevel, printDebugLevel, format, a...)
 }
@@ -151,3 +337,73 @@ func Fatal(format string, a ...interface{}) {
 func Close() {
 	gLogger.Close()
 }
+
+// goroutine safe
+// 设置全局日志级别
+func SetLevel(strLevel string) error {
+	return gLogger.SetLevel(strLevel)
+}
+
+// goroutine safe
+// 当前全局日志级别
+func Level() string {
+	return gLogger.Level()
+}
+
+// goroutine safe
+// 设置模块的日志级别，strLevel为空时恢复使用全局级别
+func SetModuleLevel(module string, strLevel string) error {
+	return gLogger.SetModuleLevel(module, strLevel)
+}
+
+// goroutine safe
+// 单独设置了级别的模块
+func ModuleLevels() map[string]string {
+	return gLogger.ModuleLevels()
+}
+
+// 带模块名和字段的日志
+// 每次输出时使用当前导出的Logger，可以在Export之前创建
+type Entry struct {
+	module string
+	fields []Field
+}
+
+// 模块的日志，级别可以通过SetModuleLevel单独设置
+func Module(name string) *Entry {
+	return &Entry{module: name}
+}
+
+// 带字段的日志
+func With(fields ...Field) *Entry {
+	return new(Entry).With(fields...)
+}
+
+// 返回追加了字段的新Entry
+func (e *Entry) With(fields ...Field) *Entry {
+	all := make([]Field, 0, len(e.fields)+len(fields))
+	all = append(all, e.fields...)
+	all = append(all, fields...)
+	return &Entry{module: e.module, fields: all}
+}
+
+// 是否输出该级别的日志，可在构造开销较大的字段前判断
+func (e *Entry) DebugEnabled() bool {
+	return gLogger.enabled(debugLevel, e.module)
+}
+
+func (e *Entry) Debug(format string, a ...interface{}) {
+	gLogger.output(3, debugLevel, e.module, e.fields, format, a...)
+}
+
+func (e *Entry) Release(format string, a ...interface{}) {
+	gLogger.output(3, releaseLevel, e.module, e.fields, format, a...)
+}
+
+func (e *Entry) Error(format string, a ...interface{}) {
+	gLogger.output(3, errorLevel, e.module, e.fields, format, a...)
+}
+
+func (e *Entry) Fatal(format string, a ...interface{}) {
+	gLogger.output(3, fatalLevel, e.module, e.fields, format, a...)
+}
@@ -0,0 +1,231 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 日志文件切割和保留配置，零值为不切割
+type RotateConf struct {
+	MaxSize    int64         // 单个文件的最大字节数，0为不按大小切割
+	Daily      bool          // 是否按天切割
+	MaxBackups int           // 保留的旧文件数，0为不限制
+	MaxAge     time.Duration // 旧文件的保留时长，0为不限制
+	Compress   bool          // 是否gzip压缩旧文件
+}
+
+// 当前时间，测试时替换
+var timeNow = time.Now
+
+// 日志文件名，同一秒内切割时追加序号: 20060102_15_04_05.1.log
+var logFileName = regexp.MustCompile(`^(\d{8}_\d{2}_\d{2}_\d{2})(?:\.(\d+))?\.log(?:\.gz)?$`)
+
+// 按大小和日期切割的日志文件
+type rotateWriter struct {
+	dir  string
+	conf RotateConf
+
+	mutex    sync.Mutex
+	file     *os.File
+	size     int64
+	openDay  string // 当前文件的打开日期
+	millChan chan struct{}
+}
+
+func newRotateWriter(dir string, conf RotateConf) (*rotateWriter, error) {
+	w := &rotateWriter{dir: dir, conf: conf}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	if conf.MaxBackups > 0 || conf.MaxAge > 0 || conf.Compress {
+		w.millChan = make(chan struct{}, 1)
+		go w.mill()
+		w.millChan <- struct{}{}
+	}
+	return w, nil
+}
+
+// 按当前时间创建新文件
+func (w *rotateWriter) open() error {
+	now := timeNow()
+	base := fmt.Sprintf("%d%02d%02d_%02d_%02d_%02d",
+		now.Year(),
+		now.Month(),
+		now.Day(),
+		now.Hour(),
+		now.Minute(),
+		now.Second())
+
+	name := filepath.Join(w.dir, base+".log")
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = filepath.Join(w.dir, fmt.Sprintf("%v.%d.log", base, i))
+	}
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.size = 0
+	w.openDay = now.Format("20060102")
+	return nil
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// goroutine safe
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if (w.conf.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.conf.MaxSize) ||
+		(w.conf.Daily && timeNow().Format("20060102") != w.openDay) {
+		if err := w.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "rotate log file error: %v\n", err)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// 关闭当前文件并打开新文件，调用时需持有w.mutex
+func (w *rotateWriter) rotate() error {
+	// 新文件打开成功后再关闭旧文件，失败时继续写旧文件
+	prev := w.file
+	if err := w.open(); err != nil {
+		return err
+	}
+	prev.Close()
+	if w.millChan != nil {
+		// 已有未执行的清理时不再重复通知
+		select {
+		case w.millChan <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// 清理和压缩旧文件，在单独的goroutine中串行执行
+func (w *rotateWriter) mill() {
+	for range w.millChan {
+		if err := w.clean(); err != nil {
+			fmt.Fprintf(os.Stderr, "clean log files error: %v\n", err)
+		}
+	}
+}
+
+// 压缩为name.gz并删除原文件
+func compress(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// 按数量和时长删除旧文件，再压缩保留的未压缩文件
+func (w *rotateWriter) clean() error {
+	infos, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+	w.mutex.Lock()
+	current := ""
+	if w.file != nil {
+		current = filepath.Base(w.file.Name())
+	}
+	w.mutex.Unlock()
+
+	type oldFile struct {
+		info os.FileInfo
+		base string
+		seq  int
+	}
+	var olds []oldFile
+	for _, info := range infos {
+		m := logFileName.FindStringSubmatch(info.Name())
+		if info.IsDir() || info.Name() == current || m == nil {
+			continue
+		}
+		seq, _ := strconv.Atoi(m[2])
+		olds = append(olds, oldFile{info, m[1], seq})
+	}
+	// 按文件名中的时间和序号从新到旧，同一秒内切割的文件修改时间可能相同
+	sort.Slice(olds, func(i, j int) bool {
+		if olds[i].base != olds[j].base {
+			return olds[i].base > olds[j].base
+		}
+		return olds[i].seq > olds[j].seq
+	})
+
+	for i, old := range olds {
+		name := filepath.Join(w.dir, old.info.Name())
+		if (w.conf.MaxBackups > 0 && i >= w.conf.MaxBackups) ||
+			(w.conf.MaxAge > 0 && timeNow().Sub(old.info.ModTime()) > w.conf.MaxAge) {
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if w.conf.Compress && filepath.Ext(name) == ".log" {
+			if err := compress(name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 关闭当前文件，停止后台清理
+func (w *rotateWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	if w.millChan != nil {
+		close(w.millChan)
+		w.millChan = nil
+	}
+	return err
+}
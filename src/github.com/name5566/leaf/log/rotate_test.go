@@ -0,0 +1,136 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 列出目录中的文件和大小
+func printDir(dir string) {
+	infos, _ := ioutil.ReadDir(dir)
+	for _, info := range infos {
+		fmt.Println(info.Name(), info.Size())
+	}
+}
+
+func fixedNow(t time.Time) func() time.Time {
+	return func() time.Time {
+		return t
+	}
+}
+
+func Example_rotateSize() {
+	dir, _ := ioutil.TempDir("", "leaflog")
+	defer os.RemoveAll(dir)
+	timeNow = fixedNow(time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local))
+	defer func() { timeNow = time.Now }()
+
+	w, err := newRotateWriter(dir, RotateConf{MaxSize: 10})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	// 同一秒内切割时追加序号，超过MaxSize的单次写入不拆分
+	w.Write([]byte("012345678\n"))
+	w.Write([]byte("012345678\n"))
+	w.Write([]byte("0123456789abcd\n"))
+	w.Close()
+	printDir(dir)
+
+	// Output:
+	// 20200102_03_04_05.1.log 10
+	// 20200102_03_04_05.2.log 15
+	// 20200102_03_04_05.log 10
+}
+
+func Example_rotateDaily() {
+	dir, _ := ioutil.TempDir("", "leaflog")
+	defer os.RemoveAll(dir)
+	timeNow = fixedNow(time.Date(2020, 1, 2, 23, 59, 59, 0, time.Local))
+	defer func() { timeNow = time.Now }()
+
+	w, err := newRotateWriter(dir, RotateConf{Daily: true})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	w.Write([]byte("day 1\n"))
+	w.Write([]byte("day 1\n"))
+	timeNow = fixedNow(time.Date(2020, 1, 3, 0, 0, 1, 0, time.Local))
+	w.Write([]byte("day 2\n"))
+	w.Close()
+	printDir(dir)
+
+	// Output:
+	// 20200102_23_59_59.log 12
+	// 20200103_00_00_01.log 6
+}
+
+func Example_rotateClean() {
+	dir, _ := ioutil.TempDir("", "leaflog")
+	defer os.RemoveAll(dir)
+	now := time.Date(2020, 1, 10, 0, 0, 0, 0, time.Local)
+	timeNow = fixedNow(now)
+	defer func() { timeNow = time.Now }()
+
+	// 旧文件，修改时间为文件名中的时间
+	for _, name := range []string{
+		"20200101_00_00_00.log",
+		"20200108_00_00_00.log",
+		"20200109_00_00_00.log",
+		"20200109_00_00_00.1.log",
+		"20200109_00_00_00.2.log.gz",
+		"other.txt",
+	} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+		t := now
+		if m := logFileName.FindStringSubmatch(name); m != nil {
+			t, _ = time.ParseInLocation("20060102_15_04_05", m[1], time.Local)
+		}
+		os.Chtimes(filepath.Join(dir, name), t, t)
+	}
+
+	// 直接执行清理，不启动后台goroutine
+	w := &rotateWriter{dir: dir, conf: RotateConf{MaxBackups: 4, MaxAge: 36 * time.Hour, Compress: true}}
+	if err := w.open(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := w.clean(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	w.Close()
+
+	infos, _ := ioutil.ReadDir(dir)
+	for _, info := range infos {
+		fmt.Println(info.Name())
+	}
+
+	// 压缩后的内容
+	f, err := os.Open(filepath.Join(dir, "20200109_00_00_00.1.log.gz"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	data, _ := ioutil.ReadAll(gz)
+	fmt.Println(string(data))
+
+	// Output:
+	// 20200109_00_00_00.1.log.gz
+	// 20200109_00_00_00.2.log.gz
+	// 20200109_00_00_00.log.gz
+	// 20200110_00_00_00.log
+	// other.txt
+	// 20200109_00_00_00.1.log
+}
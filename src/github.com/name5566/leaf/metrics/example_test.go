@@ -0,0 +1,45 @@
+package metrics_test
+
+import (
+	"os"
+
+	"github.com/name5566/leaf/metrics"
+)
+
+func Example() {
+	requests := metrics.NewCounter("example_requests_total", "Requests by msgID.", "msg_id")
+	conns := metrics.NewGauge("example_connections", "Live connections.")
+	latency := metrics.NewHistogram("example_latency_seconds", "Handler latency.", []float64{0.1, 1})
+	metrics.NewGaugeFunc("example_queue_length", "Queue length.", []string{"server"}, func(set func(float64, ...string)) {
+		set(3, "game")
+	})
+
+	requests.Inc("Login")
+	requests.Add(2, "Logout")
+	conns.Inc()
+	conns.Inc()
+	conns.Dec()
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+
+	metrics.Write(os.Stdout)
+
+	// Output:
+	// # HELP example_connections Live connections.
+	// # TYPE example_connections gauge
+	// example_connections 1
+	// # HELP example_latency_seconds Handler latency.
+	// # TYPE example_latency_seconds histogram
+	// example_latency_seconds_bucket{le="0.1"} 1
+	// example_latency_seconds_bucket{le="1"} 2
+	// example_latency_seconds_bucket{le="+Inf"} 2
+	// example_latency_seconds_sum 0.55
+	// example_latency_seconds_count 2
+	// # HELP example_queue_length Queue length.
+	// # TYPE example_queue_length gauge
+	// example_queue_length{server="game"} 3
+	// # HELP example_requests_total Requests by msgID.
+	// # TYPE example_requests_total counter
+	// example_requests_total{msg_id="Login"} 1
+	// example_requests_total{msg_id="Logout"} 2
+}
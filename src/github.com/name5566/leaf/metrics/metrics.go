@@ -0,0 +1,342 @@
+// 运行指标统计，按Prometheus文本格式输出
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认的耗时分布区间，单位秒
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// 指标接口，由Counter，Gauge，Histogram和GaugeFunc实现
+type collector interface {
+	describe() *desc
+	collect(buf *bytes.Buffer)
+}
+
+// 指标描述
+type desc struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+}
+
+var (
+	mutex      sync.Mutex
+	collectors = make(map[string]collector)
+)
+
+// 注册指标，重名时panic
+func register(c collector) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	name := c.describe().name
+	if _, ok := collectors[name]; ok {
+		panic(fmt.Sprintf("metric %v already registered", name))
+	}
+	collectors[name] = c
+}
+
+// 一组标签值对应的指标
+type series struct {
+	labelValues []string
+	value       float64  // Counter和Gauge的值
+	counts      []uint64 // Histogram每个区间的计数
+	sum         float64  // Histogram的总和
+	count       uint64   // Histogram的总数
+}
+
+// 按标签值区分的指标集合
+type vec struct {
+	desc
+	mutex  sync.Mutex
+	series map[string]*series
+}
+
+func newVec(name, help, typ string, labelNames []string) vec {
+	return vec{
+		desc:   desc{name: name, help: help, typ: typ, labelNames: labelNames},
+		series: make(map[string]*series),
+	}
+}
+
+func (v *vec) describe() *desc {
+	return &v.desc
+}
+
+// 获取标签值对应的指标，调用时需持有v.mutex
+func (v *vec) get(labelValues []string) *series {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %v: expected %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+// 按标签值排序的指标
+func (v *vec) sorted() []*series {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ss := make([]*series, len(keys))
+	for i, key := range keys {
+		ss[i] = v.series[key]
+	}
+	return ss
+}
+
+// 只增不减的计数
+type Counter struct {
+	vec
+}
+
+// 创建并注册计数指标
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{newVec(name, help, "counter", labelNames)}
+	register(c)
+	return c
+}
+
+// goroutine safe
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// goroutine safe
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("counter cannot decrease")
+	}
+	c.mutex.Lock()
+	c.get(labelValues).value += delta
+	c.mutex.Unlock()
+}
+
+func (c *Counter) collect(buf *bytes.Buffer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, s := range c.sorted() {
+		writeSample(buf, c.name, c.labelNames, s.labelValues, "", "", s.value)
+	}
+}
+
+// 可增可减的数值
+type Gauge struct {
+	vec
+}
+
+// 创建并注册数值指标
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	g := &Gauge{newVec(name, help, "gauge", labelNames)}
+	register(g)
+	return g
+}
+
+// goroutine safe
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.mutex.Lock()
+	g.get(labelValues).value = value
+	g.mutex.Unlock()
+}
+
+// goroutine safe
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.mutex.Lock()
+	g.get(labelValues).value += delta
+	g.mutex.Unlock()
+}
+
+// goroutine safe
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// goroutine safe
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (g *Gauge) collect(buf *bytes.Buffer) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for _, s := range g.sorted() {
+		writeSample(buf, g.name, g.labelNames, s.labelValues, "", "", s.value)
+	}
+}
+
+// 数值分布，例如耗时
+type Histogram struct {
+	vec
+	buckets []float64
+}
+
+// 创建并注册分布指标，buckets为各区间的上限，nil时使用DefBuckets
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{vec: newVec(name, help, "histogram", labelNames), buckets: buckets}
+	register(h)
+	return h
+}
+
+// goroutine safe
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s := h.get(labelValues)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// goroutine safe
+// 记录从start开始的耗时，单位秒
+func (h *Histogram) Since(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *Histogram) collect(buf *bytes.Buffer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, s := range h.sorted() {
+		for i, upper := range h.buckets {
+			writeSample(buf, h.name+"_bucket", h.labelNames, s.labelValues, "le", formatFloat(upper), float64(s.counts[i]))
+		}
+		writeSample(buf, h.name+"_bucket", h.labelNames, s.labelValues, "le", "+Inf", float64(s.count))
+		writeSample(buf, h.name+"_sum", h.labelNames, s.labelValues, "", "", s.sum)
+		writeSample(buf, h.name+"_count", h.labelNames, s.labelValues, "", "", float64(s.count))
+	}
+}
+
+// 输出时才采集的数值，例如队列长度和连接池状态
+type GaugeFunc struct {
+	desc
+	f func(set func(value float64, labelValues ...string))
+}
+
+// 创建并注册采集函数，f中调用set设置每组标签值对应的数值
+func NewGaugeFunc(name, help string, labelNames []string, f func(set func(value float64, labelValues ...string))) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help, typ: "gauge", labelNames: labelNames}, f: f}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) describe() *desc {
+	return &g.desc
+}
+
+func (g *GaugeFunc) collect(buf *bytes.Buffer) {
+	g.f(func(value float64, labelValues ...string) {
+		if len(labelValues) != len(g.labelNames) {
+			panic(fmt.Sprintf("metric %v: expected %d label values, got %d", g.name, len(g.labelNames), len(labelValues)))
+		}
+		writeSample(buf, g.name, g.labelNames, labelValues, "", "", value)
+	})
+}
+
+// goroutine safe
+// 按Prometheus文本格式输出所有指标
+func Write(w io.Writer) error {
+	mutex.Lock()
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+	cs := make([]collector, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		cs[i] = collectors[name]
+	}
+	mutex.Unlock()
+
+	var buf bytes.Buffer
+	for _, c := range cs {
+		d := c.describe()
+		fmt.Fprintf(&buf, "# HELP %v %v\n", d.name, escape(d.help, false))
+		fmt.Fprintf(&buf, "# TYPE %v %v\n", d.name, d.typ)
+		c.collect(&buf)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// 输出指标的http处理器，挂载到/metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
+
+// name{label="value",...} value
+func writeSample(buf *bytes.Buffer, name string, labelNames, labelValues []string, extraName, extraValue string, value float64) {
+	buf.WriteString(name)
+	if len(labelNames) > 0 || extraName != "" {
+		buf.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, "%v=\"%v\"", labelName, escape(labelValues[i], true))
+		}
+		if extraName != "" {
+			if len(labelNames) > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, "%v=\"%v\"", extraName, extraValue)
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatFloat(value))
+	buf.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// 转义反斜杠和换行，标签值还需要转义双引号
+func escape(s string, quote bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quote {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
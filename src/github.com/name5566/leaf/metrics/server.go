@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/util"
+)
+
+var server *http.Server
+
+// 在conf.MetricsAddr上单独监听/metrics，不挂载到对外的http接口
+// 没有认证，只应监听本机或内网地址
+func Init() {
+	if conf.MetricsAddr == "" { // 为空时不启用
+		return
+	}
+
+	ln, err := net.Listen("tcp", conf.MetricsAddr)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	if host, _, err := net.SplitHostPort(conf.MetricsAddr); err == nil && !util.IsLoopback(host) {
+		log.Release("metrics listens on %v without authentication, do not expose it to the public network", conf.MetricsAddr)
+	}
+	log.Release("metrics server init: %v", ln.Addr())
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	server = &http.Server{
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	go server.Serve(ln)
+}
+
+func Destroy() {
+	if server != nil {
+		server.Close()
+	}
+}
@@ -1,10 +1,18 @@
 package module
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/log"
+	"reflect"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 //模块接口定义
@@ -14,16 +22,83 @@ type Module interface {
 	Run(closeSig chan bool) // 运行函数
 }
 
+//模块依赖的其他模块，可选实现
+//依赖的模块先初始化，就绪后才初始化当前模块，销毁时顺序相反
+type Depender interface {
+	Dependencies() []Module
+}
+
+//需要异步准备的模块，可选实现
+//Run开始后轮询Ready，返回true时模块就绪，未实现时Run开始即就绪
+type Readier interface {
+	Ready() bool
+}
+
+//Run意外退出时的重启策略，可选实现，未实现时不重启
+type Restarter interface {
+	RestartPolicy() RestartPolicy
+}
+
+//重启策略
+type RestartPolicy struct {
+	MaxRestarts int           // 最多重启次数
+	Delay       time.Duration // 重启前的等待时间
+}
+
+//单独设置关闭超时的模块，可选实现，未实现时使用conf.ModuleStopTimeout
+type Stopper interface {
+	StopTimeout() time.Duration
+}
+
+//报告未处理任务数的模块，可选实现，关闭超时时输出
+//Skeleton实现了该接口，嵌入Skeleton的模块不需要再实现
+type PendingReporter interface {
+	Pending() map[string]int
+}
+
+//模块状态
+type State int32
+
+const (
+	StateInitializing State = iota // OnInit执行中或等待就绪
+	StateReady                     // 运行中
+	StateDraining                  // 正在下线
+	StateStopped                   // 收到关闭信号后退出
+	StateFailed                    // Run意外退出或panic
+)
+
+var stateNames = []string{"initializing", "ready", "draining", "stopped", "failed"}
+
+func (s State) String() string {
+	return stateNames[s]
+}
+
 //模块类型定义
 type module struct {
 	mi       Module         //实现了模块接口的对象
 	closeSig chan bool      //传输关闭信号的管道
 	wg       sync.WaitGroup //等待组
+	state    int32          //State，原子读写
+	closing  int32          //是否已发送关闭信号，原子读写
+	restarts int32          //重启次数，原子读写
+	exited   int32          //Run不再重启，原子读写
+	goid     int64          //运行Run的goroutine id，原子读写
 }
 
-//模块数组，用于保存注册的模块
+//模块数组，用于保存注册的模块，Init后按依赖关系排序
 var mods []*module
 
+//是否正在下线，下线期间不再接收新流量
+var draining int32
+
+//模块运行状态
+type Status struct {
+	Name     string // 模块名，为模块类型所在包路径
+	State    string // 模块状态
+	Running  bool   // Run是否在运行
+	Restarts int    // 重启次数
+}
+
 // 注册一个新模块
 func Register(mi Module) {
 	m := new(module)                //新建一个模块
@@ -34,32 +109,346 @@ func Register(mi Module) {
 }
 
 //初始化函数，注意不是init
+//按依赖关系依次初始化和运行模块，每个模块就绪后再初始化下一个
 func Init() {
-	for i := 0; i < len(mods); i++ { //遍历所有注册的模块(从前往后)
-		mods[i].mi.OnInit() //调用各个模块的OnInit函数
+	sorted, err := sortByDeps(mods)
+	if err != nil {
+		log.Fatal("%v", err)
 	}
+	mods = sorted
 
-	for i := 0; i < len(mods); i++ { //遍历所有注册的模块(从前往后)
-		m := mods[i]
-		m.wg.Add(1) //等待goroutine数加1
-		go run(m)   //在一个新的goroutine中运行模块
+	for _, m := range mods {
+		m.mi.OnInit() //调用模块的OnInit函数
+		m.wg.Add(1)   //等待goroutine数加1
+		go run(m)     //在一个新的goroutine中运行模块
+		m.waitReady()
 	}
 }
 
 //销毁函数
+//模块超时未退出时输出诊断信息，跳过该模块的OnDestroy，继续关闭其他模块
 func Destroy() {
-	for i := len(mods) - 1; i >= 0; i-- { //遍历所有注册的模块(反序，从后往前)
-		m := mods[i]       // 取得对应索引的模块
-		m.closeSig <- true //向管道发送关闭信号(导致Run内的死循环介绍，继续执行到m.wg.Done() 60行)
-		m.wg.Wait()        //等待该模块所在goroutine执行完成
-		destroy(m)         //销毁该模块
+	for i := len(mods) - 1; i >= 0; i-- { //遍历所有模块(反序，依赖其他模块的先销毁)
+		m := mods[i] // 取得对应索引的模块
+		atomic.StoreInt32(&m.closing, 1)
+		m.closeSig <- true //向管道发送关闭信号(导致Run内的死循环结束)
+		timeout := stopTimeout(m)
+		if !m.wait(timeout) { //等待该模块所在goroutine执行完成
+			log.Error("module %v did not stop within %v\n%v", name(m.mi), timeout, m.diagnose())
+			continue
+		}
+		destroy(m) //销毁该模块
+	}
+}
+
+//模块的关闭超时
+func stopTimeout(m *module) time.Duration {
+	if s, ok := m.mi.(Stopper); ok {
+		return s.StopTimeout()
+	}
+	return conf.ModuleStopTimeout
+}
+
+//等待Run退出，超时返回false，timeout为0时一直等待
+func (m *module) wait(timeout time.Duration) bool {
+	if timeout <= 0 {
+		m.wg.Wait()
+		return true
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+//关闭超时时的诊断信息，包括未处理任务数和模块相关goroutine的调用栈
+func (m *module) diagnose() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "state: %v", m.State())
+	if p, ok := m.mi.(PendingReporter); ok {
+		pending := p.Pending()
+		keys := make([]string, 0, len(pending))
+		for k := range pending {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteString(", pending:")
+		for _, k := range keys {
+			fmt.Fprintf(&buf, " %v=%v", k, pending[k])
+		}
+	}
+	buf.WriteString("\n")
+	if stack := moduleStacks(atomic.LoadInt64(&m.goid), pkgPath(m.mi)); stack != "" {
+		buf.WriteString(stack)
+	} else {
+		buf.WriteString("goroutine stack not found")
+	}
+	return buf.String()
+}
+
+//当前goroutine的id，取自调用栈的第一行: goroutine 7 [running]:
+func goroutineID() int64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		id, _ := strconv.ParseInt(string(buf[:i]), 10, 64)
+		return id
+	}
+	return 0
+}
+
+//所有goroutine的调用栈，以空行分隔
+func allStacks() string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+//调用栈第一行中的goroutine id和最后的created by行中创建者的goroutine id
+//created by github.com/name5566/leaf/go.(*Go).Go in goroutine 7
+func parseStack(stack string) (id int64, parent int64) {
+	fmt.Sscanf(stack, "goroutine %d ", &id)
+	if i := strings.LastIndex(stack, "created by "); i >= 0 {
+		line := stack[i:]
+		if j := strings.IndexByte(line, '\n'); j >= 0 {
+			line = line[:j]
+		}
+		if j := strings.LastIndex(line, " in goroutine "); j >= 0 {
+			parent, _ = strconv.ParseInt(line[j+len(" in goroutine "):], 10, 64)
+		}
+	}
+	return
+}
+
+//模块相关的goroutine调用栈，找不到时返回空
+//包括Run所在goroutine，由其直接或间接创建的goroutine(如Skeleton.Go)，以及调用栈中出现模块包路径的goroutine
+func moduleStacks(id int64, pkg string) string {
+	if id == 0 {
+		return ""
+	}
+	stacks := strings.Split(allStacks(), "\n\n")
+	ids := make([]int64, len(stacks))
+	parents := make(map[int64]int64, len(stacks))
+	for i, stack := range stacks {
+		var parent int64
+		ids[i], parent = parseStack(stack)
+		parents[ids[i]] = parent
+	}
+	// 是否由id所在goroutine直接或间接创建
+	createdBy := func(gid int64) bool {
+		for n := 0; gid != 0 && n < len(stacks); n++ {
+			if gid == id {
+				return true
+			}
+			gid = parents[gid]
+		}
+		return false
 	}
+
+	self := goroutineID()
+	var found []string
+	for i, stack := range stacks {
+		if ids[i] == self {
+			continue
+		}
+		if createdBy(ids[i]) || (pkg != "" && strings.Contains(stack, pkg+".")) {
+			found = append(found, stack)
+		}
+	}
+	return strings.Join(found, "\n\n")
+}
+
+//模块类型所在的包路径
+func pkgPath(mi Module) string {
+	t := reflect.TypeOf(mi)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.PkgPath()
+}
+
+// goroutine safe
+// 所有注册模块的运行状态
+func Statuses() []Status {
+	statuses := make([]Status, len(mods))
+	for i, m := range mods {
+		state := m.State()
+		statuses[i] = Status{
+			Name:     name(m.mi),
+			State:    state.String(),
+			Running:  state == StateReady || state == StateDraining,
+			Restarts: int(atomic.LoadInt32(&m.restarts)),
+		}
+	}
+	return statuses
+}
+
+// goroutine safe
+// 标记开始下线，就绪检查随即失败，负载均衡不再分配新流量
+func Drain() {
+	atomic.StoreInt32(&draining, 1)
+}
+
+// goroutine safe
+// 是否正在下线
+func Draining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+//模块名，取模块类型所在的包路径，去掉末尾的/internal
+func name(mi Module) string {
+	return strings.TrimSuffix(pkgPath(mi), "/internal")
+}
+
+// goroutine safe
+// 模块状态，下线期间运行中的模块为StateDraining
+func (m *module) State() State {
+	state := State(atomic.LoadInt32(&m.state))
+	if state == StateReady && Draining() {
+		return StateDraining
+	}
+	return state
+}
+
+func (m *module) setState(state State) {
+	atomic.StoreInt32(&m.state, int32(state))
+}
+
+//等待模块就绪，超过conf.ModuleReadyTimeout或启动时失败则退出进程
+func (m *module) waitReady() {
+	var deadline time.Time
+	if conf.ModuleReadyTimeout > 0 {
+		deadline = time.Now().Add(conf.ModuleReadyTimeout)
+	}
+	r, ok := m.mi.(Readier)
+	for {
+		if m.State() == StateFailed && atomic.LoadInt32(&m.exited) == 1 {
+			log.Fatal("module %v failed during startup", name(m.mi))
+		}
+		if m.State() != StateFailed && (!ok || r.Ready()) {
+			break
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			log.Fatal("module %v is not ready after %v", name(m.mi), conf.ModuleReadyTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	atomic.CompareAndSwapInt32(&m.state, int32(StateInitializing), int32(StateReady))
+	log.Release("module %v is ready", name(m.mi))
+}
+
+//按依赖关系排序，依赖的模块排在前面，没有依赖关系的模块保持注册顺序
+func sortByDeps(ms []*module) ([]*module, error) {
+	index := make(map[Module]*module, len(ms))
+	for _, m := range ms {
+		index[m.mi] = m
+	}
+
+	var sorted []*module
+	visited := make(map[*module]int) // 1: 访问中 2: 已排序
+	var visit func(m *module, path []string) error
+	visit = func(m *module, path []string) error {
+		path = append(path, name(m.mi))
+		switch visited[m] {
+		case 1:
+			return fmt.Errorf("module dependency cycle: %v", strings.Join(path, " -> "))
+		case 2:
+			return nil
+		}
+		visited[m] = 1
+		if d, ok := m.mi.(Depender); ok {
+			for _, dep := range d.Dependencies() {
+				dm, ok := index[dep]
+				if !ok {
+					return fmt.Errorf("module %v depends on unregistered module %v", name(m.mi), name(dep))
+				}
+				if err := visit(dm, path); err != nil {
+					return err
+				}
+			}
+		}
+		visited[m] = 2
+		sorted = append(sorted, m)
+		return nil
+	}
+	for _, m := range ms {
+		if err := visit(m, nil); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
 }
 
 //运行模块函数定义
+//Run在收到关闭信号前退出或panic时模块失败，按重启策略重启
 func run(m *module) {
+	defer m.wg.Done() //等待goroutine数减1
+	defer atomic.StoreInt32(&m.exited, 1)
+	atomic.StoreInt64(&m.goid, goroutineID())
+
+	for {
+		err := runOnce(m)
+		if atomic.LoadInt32(&m.closing) == 1 {
+			m.setState(StateStopped)
+			return
+		}
+
+		m.setState(StateFailed)
+		if err != nil {
+			log.Error("module %v failed: %v", name(m.mi), err)
+		} else {
+			log.Error("module %v failed: Run returned before close", name(m.mi))
+		}
+
+		var policy RestartPolicy
+		if r, ok := m.mi.(Restarter); ok {
+			policy = r.RestartPolicy()
+		}
+		restarts := int(atomic.LoadInt32(&m.restarts))
+		if restarts >= policy.MaxRestarts {
+			return
+		}
+		time.Sleep(policy.Delay)
+		if atomic.LoadInt32(&m.closing) == 1 {
+			m.setState(StateStopped)
+			return
+		}
+		atomic.AddInt32(&m.restarts, 1)
+		log.Release("restarting module %v (%v/%v)", name(m.mi), restarts+1, policy.MaxRestarts)
+		m.setState(StateReady)
+	}
+}
+
+//执行模块的Run，捕获panic
+func runOnce(m *module) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				err = fmt.Errorf("panic: %v: %s", r, buf[:l])
+			} else {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}
+	}()
+
 	m.mi.Run(m.closeSig) //调用模块的Run函数(skeleton内实现，一个死循环)
-	m.wg.Done()          //等待goroutine数减1
+	return nil
 }
 
 //销毁模块
@@ -0,0 +1,231 @@
+package module
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 测试用的模块，deps为依赖的模块
+type fakeModule struct {
+	name          string
+	deps          []Module
+	runs          int32
+	ready         int32 // Ready被调用的次数
+	run           func(closeSig chan bool)
+	restartPolicy RestartPolicy
+}
+
+func (f *fakeModule) OnInit()    {}
+func (f *fakeModule) OnDestroy() {}
+
+func (f *fakeModule) Run(closeSig chan bool) {
+	atomic.AddInt32(&f.runs, 1)
+	if f.run != nil {
+		f.run(closeSig)
+		return
+	}
+	<-closeSig
+}
+
+func (f *fakeModule) Dependencies() []Module { return f.deps }
+
+func (f *fakeModule) RestartPolicy() RestartPolicy { return f.restartPolicy }
+
+func newModule(mi Module) *module {
+	return &module{mi: mi, closeSig: make(chan bool, 1)}
+}
+
+func printSorted(ms ...*fakeModule) {
+	var mods []*module
+	for _, f := range ms {
+		mods = append(mods, newModule(f))
+	}
+	sorted, err := sortByDeps(mods)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	names := make([]string, len(sorted))
+	for i, m := range sorted {
+		names[i] = m.mi.(*fakeModule).name
+	}
+	fmt.Println(strings.Join(names, " "))
+}
+
+func Example_sortByDeps() {
+	db := &fakeModule{name: "db"}
+	login := &fakeModule{name: "login", deps: []Module{db}}
+	game := &fakeModule{name: "game", deps: []Module{login, db}}
+	gate := &fakeModule{name: "gate", deps: []Module{game}}
+	chat := &fakeModule{name: "chat"}
+
+	// 依赖的模块排在前面，其他保持注册顺序
+	printSorted(gate, chat, game, login, db)
+	printSorted(chat, db, login, game, gate)
+
+	// Output:
+	// db login game gate chat
+	// chat db login game gate
+}
+
+func Example_sortByDepsError() {
+	a := &fakeModule{name: "a"}
+	b := &fakeModule{name: "b", deps: []Module{a}}
+	a.deps = []Module{b}
+	printSorted(a, b)
+
+	// 依赖的模块没有注册
+	c := &fakeModule{name: "c"}
+	d := &fakeModule{name: "d", deps: []Module{c}}
+	printSorted(d)
+
+	// Output:
+	// module dependency cycle: github.com/name5566/leaf/module -> github.com/name5566/leaf/module -> github.com/name5566/leaf/module
+	// module github.com/name5566/leaf/module depends on unregistered module github.com/name5566/leaf/module
+}
+
+// Run意外退出时按重启策略重启，超过次数后为失败状态
+func Example_runRestart() {
+	f := &fakeModule{
+		run:           func(closeSig chan bool) { panic("crash") },
+		restartPolicy: RestartPolicy{MaxRestarts: 2},
+	}
+	m := newModule(f)
+	m.wg.Add(1)
+	run(m)
+	fmt.Println(f.runs, m.State(), m.restarts)
+
+	// 没有重启策略时不重启
+	f = &fakeModule{run: func(closeSig chan bool) {}}
+	m = newModule(f)
+	m.wg.Add(1)
+	run(m)
+	fmt.Println(f.runs, m.State(), m.restarts)
+
+	// Output:
+	// 3 failed 2
+	// 1 failed 0
+}
+
+// 重启后正常运行，收到关闭信号后为停止状态
+func Example_runStop() {
+	restarted := make(chan struct{})
+	f := &fakeModule{restartPolicy: RestartPolicy{MaxRestarts: 1}}
+	f.run = func(closeSig chan bool) {
+		if atomic.LoadInt32(&f.runs) == 1 {
+			panic("crash")
+		}
+		close(restarted)
+		<-closeSig
+	}
+	m := newModule(f)
+	m.wg.Add(1)
+	go run(m)
+	<-restarted
+	fmt.Println(m.State(), atomic.LoadInt32(&m.restarts))
+
+	atomic.StoreInt32(&m.closing, 1)
+	m.closeSig <- true
+	fmt.Println(m.wait(0), f.runs, m.State())
+
+	// Output:
+	// ready 1
+	// true 2 stopped
+}
+
+// 实现了Readier的模块
+type readyModule struct {
+	fakeModule
+}
+
+func (r *readyModule) Ready() bool {
+	return atomic.AddInt32(&r.ready, 1) >= 3
+}
+
+// Ready返回true后才就绪
+func Example_waitReady() {
+	r := new(readyModule)
+	m := newModule(r)
+	m.wg.Add(1)
+	go run(m)
+	fmt.Println(m.State())
+	m.waitReady()
+	fmt.Println(m.State(), r.ready)
+
+	atomic.StoreInt32(&m.closing, 1)
+	m.closeSig <- true
+	m.wait(0)
+	fmt.Println(m.State())
+
+	// Output:
+	// initializing
+	// ready 3
+	// stopped
+}
+
+func blockRun(ready chan int64, release chan struct{}) {
+	ready <- goroutineID()
+	go blockChild(release)
+	<-release
+}
+
+func blockChild(release chan struct{}) {
+	go blockGrandchild(release)
+	<-release
+}
+
+func blockGrandchild(release chan struct{}) {
+	<-release
+}
+
+func blockOther(release chan struct{}) {
+	<-release
+}
+
+// 关闭超时时输出Run所在goroutine及其创建的goroutine
+func Example_moduleStacks() {
+	ready := make(chan int64)
+	release := make(chan struct{})
+	defer close(release)
+	go blockOther(release)
+	go blockRun(ready, release)
+	id := <-ready
+	time.Sleep(10 * time.Millisecond)
+
+	stacks := moduleStacks(id, "")
+	for _, f := range []string{"blockRun", "blockChild", "blockGrandchild", "blockOther", "Example_moduleStacks"} {
+		fmt.Println(f, strings.Contains(stacks, "module."+f+"("))
+	}
+
+	// 调用栈中出现模块包路径的goroutine也输出
+	stacks = moduleStacks(id, "github.com/name5566/leaf/module")
+	fmt.Println(strings.Contains(stacks, "module.blockOther("))
+
+	// Output:
+	// blockRun true
+	// blockChild true
+	// blockGrandchild true
+	// blockOther false
+	// Example_moduleStacks false
+	// true
+}
+
+// Pending可以在模块goroutine之外调用
+func ExampleSkeleton_Pending() {
+	s := &Skeleton{GoLen: 10, TimerDispatcherLen: 10}
+	s.Init()
+	s.Go(func() {}, nil)
+
+	done := make(chan int)
+	go func() {
+		done <- s.Pending()["go"]
+	}()
+	s.g.Cb(<-s.g.ChanCb)
+	n := <-done
+	fmt.Println(n <= 1, s.Pending()["go"])
+
+	// Output:
+	// true 0
+}
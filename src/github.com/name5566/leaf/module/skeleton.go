@@ -11,6 +11,7 @@ import (
 type Skeleton struct {
 	GoLen              int //Go管道长度
 	TimerDispatcherLen int //定时器分发器管道长度
+	TimerTick          time.Duration //大于0时定时器使用时间轮，精度为TimerTick
 	AsynCallLen        int
 	ChanRPCServer      *chanrpc.Server   //RPC服务器引用（外部传入）
 	g                  *g.Go             //leaf的Go机制
@@ -33,7 +34,11 @@ func (s *Skeleton) Init() {
 	}
 
 	s.g = g.New(s.GoLen)                                     //创建Go
-	s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen) //创建分发器
+	if s.TimerTick > 0 {
+		s.dispatcher = timer.NewWheelDispatcher(s.TimerDispatcherLen, s.TimerTick) //创建时间轮分发器
+	} else {
+		s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen) //创建分发器
+	}
 	s.client = chanrpc.NewClient(s.AsynCallLen)
 	s.server = s.ChanRPCServer //外部传入的，内部引用
 
@@ -58,6 +63,7 @@ func (s *Skeleton) Run(closeSig chan bool) {
 				s.g.Close() //关闭Go
 				s.client.Close()
 			}
+			s.dispatcher.Close() //停止时间轮
 			return
 		case ri := <-s.client.ChanAsynRet:
 			s.client.Cb(ri)
@@ -69,10 +75,26 @@ func (s *Skeleton) Run(closeSig chan bool) {
 			s.g.Cb(cb)           //执行回调函数（不用自己写 d.Cb(<-d.ChanCb)了 ）
 		case t := <-s.dispatcher.ChanTimer: //从分发器中读取到时定时器
 			t.Cb()                          //执行定时器回调
+		case ts := <-s.dispatcher.ChanTimers: //时间轮同一个tick到期的定时器
+			for _, t := range ts {
+				t.Cb()
+			}
 		}
 	}
 }
 
+//未处理的任务数，模块关闭超时时输出
+//在模块goroutine之外调用时只是近似值
+func (s *Skeleton) Pending() map[string]int {
+	return map[string]int{
+		"chanrpc":  len(s.server.ChanCall),
+		"command":  len(s.commandServer.ChanCall),
+		"asyncall": s.client.Pending(),
+		"go":       s.g.Pending(),
+		"timer":    len(s.dispatcher.ChanTimer) + len(s.dispatcher.ChanTimers),
+	}
+}
+
 //注册定时器
 func (s *Skeleton) AfterFunc(d time.Duration, cb func()) *timer.Timer {
 	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
@@ -118,7 +140,19 @@ func (s *Skeleton) AsynCall(server *chanrpc.Server, id interface{}, args ...inte
 	s.client.AsynCall(id, args...)
 }
 
+// 带超时的异步调用，timeout内没有返回时回调收到chanrpc.ErrTimeout
+func (s *Skeleton) AsynCallTimeout(server *chanrpc.Server, id interface{}, timeout time.Duration, args ...interface{}) {
+	if s.AsynCallLen == 0 {
+		panic("invalid AsynCallLen")
+	}
+
+	s.client.Attach(server)
+	s.client.AsynCallTimeout(id, timeout, args...)
+}
+
 //向管道RPC注册函数
+//f可以是func([]interface{})等切片参数的函数，也可以是强类型函数，例如func(msg *msg.Login, a gate.Agent)
+//id为消息类型时f必须是func(msg, gate.Agent)，签名不匹配时在注册时panic
 func (s *Skeleton) RegisterChanRPC(id interface{}, f interface{}) {
 	if s.ChanRPCServer == nil { //外部没有传入RPC服务器
 		panic("invalid ChanRPCServer") //抛错
@@ -0,0 +1,48 @@
+package network
+
+import (
+	"net"
+	"net/http"
+)
+
+// 获取地址中的IP，解析失败时返回地址原值
+func RemoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// http请求的远端地址
+func remoteAddr(r *http.Request) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return addr
+}
+
+// 按IP统计连接数
+// goroutine not safe
+type ipConnCounter map[string]int
+
+// 增加ip的连接数，超过max时返回false，max小于等于0时不限制
+func (c ipConnCounter) add(ip string, max int) bool {
+	if max > 0 && c[ip] >= max {
+		return false
+	}
+	c[ip]++
+	return true
+}
+
+func (c ipConnCounter) del(ip string) {
+	if c[ip] <= 1 {
+		delete(c, ip)
+		return
+	}
+	c[ip]--
+}
@@ -2,8 +2,13 @@ package network
 
 import (
 	"net"
+
+	"github.com/name5566/leaf/log"
 )
 
+// 网络层日志，级别可以通过log.SetModuleLevel("network", level)单独设置
+var netLog = log.Module("network")
+
 type Conn interface {
 	ReadMsg() ([]byte, error)
 	WriteMsg(args ...[]byte) error
@@ -0,0 +1,128 @@
+package network
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/name5566/leaf/log"
+)
+
+// 跨域资源共享(CORS)配置
+// AllowedOrigins支持完整匹配和通配符，例如 "https://game.example.com"、"https://*.example.com"、"*"
+type CORS struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string // 默认 GET, POST, PUT, DELETE, OPTIONS
+	AllowedHeaders   []string // 默认允许预检请求中的所有头
+	AllowCredentials bool     // 为true时忽略AllowedOrigins中的"*"，避免任意网站携带凭证访问
+	MaxAge           int      // 预检结果缓存秒数
+}
+
+// 检查origin是否在允许列表中
+func MatchOrigin(allowed []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+		if i := strings.Index(pattern, "*"); i >= 0 {
+			prefix, suffix := pattern[:i], pattern[i+1:]
+			if len(origin) > len(prefix)+len(suffix) &&
+				strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 去掉允许列表中的"*"，ignored表示是否有"*"
+func withoutWildcard(allowed []string) (ret []string, ignored bool) {
+	for _, pattern := range allowed {
+		if pattern == "*" {
+			ignored = true
+			continue
+		}
+		ret = append(ret, pattern)
+	}
+	return
+}
+
+// websocket升级时检查Origin
+// 没有配置AllowedOrigins时只允许同源请求，没有Origin头的请求(非浏览器客户端)允许
+// websocket通过cookie认证，忽略AllowedOrigins中的"*"，避免跨站劫持
+func CheckOrigin(allowed []string) func(r *http.Request) bool {
+	allowed, _ = withoutWildcard(allowed)
+	return checkOrigin(allowed)
+}
+
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if len(allowed) == 0 {
+			u, err := url.Parse(origin)
+			return err == nil && strings.EqualFold(u.Host, r.Host)
+		}
+		return MatchOrigin(allowed, origin)
+	}
+}
+
+// 包装handler，设置CORS响应头并处理预检请求
+func (c *CORS) Handler(h http.Handler) http.Handler {
+	methods := "GET, POST, PUT, DELETE, OPTIONS"
+	if len(c.AllowedMethods) > 0 {
+		methods = strings.Join(c.AllowedMethods, ", ")
+	}
+	allowed := c.AllowedOrigins
+	if c.AllowCredentials {
+		var ignored bool
+		if allowed, ignored = withoutWildcard(allowed); ignored {
+			log.Error("CORS: origin \"*\" is ignored when credentials are allowed")
+		}
+	}
+	check := checkOrigin(allowed)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" { // 非跨域请求
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+		if !check(r) {
+			if preflight {
+				http.Error(w, "Origin not allowed", 403)
+				return
+			}
+			h.ServeHTTP(w, r) // 不设置CORS头，由浏览器拦截
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if c.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// 预检请求
+		w.Header().Set("Access-Control-Allow-Methods", methods)
+		if len(c.AllowedHeaders) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+		} else if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+			w.Header().Set("Access-Control-Allow-Headers", reqHeaders)
+		}
+		if c.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+		}
+		w.WriteHeader(204)
+	})
+}
@@ -0,0 +1,57 @@
+package network_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/name5566/leaf/network"
+)
+
+func ExampleCORS_Handler() {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, c := range []*network.CORS{
+		{AllowedOrigins: []string{"*"}},
+		// 携带凭证时忽略"*"，只允许明确配置的Origin
+		{AllowedOrigins: []string{"*", "https://*.example.com"}, AllowCredentials: true},
+	} {
+		for _, origin := range []string{"https://evil.com", "https://game.example.com"} {
+			r := httptest.NewRequest("GET", "http://api.example.com/", nil)
+			r.Header.Set("Origin", origin)
+			w := httptest.NewRecorder()
+			c.Handler(h).ServeHTTP(w, r)
+			fmt.Printf("%q %q\n", w.Header().Get("Access-Control-Allow-Origin"), w.Header().Get("Access-Control-Allow-Credentials"))
+		}
+	}
+
+	// Output:
+	// "https://evil.com" ""
+	// "https://game.example.com" ""
+	// "" ""
+	// "https://game.example.com" "true"
+}
+
+func ExampleCheckOrigin() {
+	// websocket通过cookie认证，"*"被忽略
+	check := network.CheckOrigin([]string{"*", "https://*.example.com"})
+	for _, origin := range []string{"", "https://evil.com", "https://game.example.com"} {
+		r := httptest.NewRequest("GET", "http://ws.example.com/", nil)
+		r.Header.Set("Origin", origin)
+		fmt.Println(check(r))
+	}
+
+	// 只有"*"时只允许同源
+	check = network.CheckOrigin([]string{"*"})
+	for _, origin := range []string{"https://evil.com", "http://ws.example.com"} {
+		r := httptest.NewRequest("GET", "http://ws.example.com/", nil)
+		r.Header.Set("Origin", origin)
+		fmt.Println(check(r))
+	}
+
+	// Output:
+	// true
+	// false
+	// true
+	// false
+	// true
+}
@@ -16,6 +16,8 @@ type HttpServer struct {
 	HTTPTimeout     time.Duration
 	CertFile        string
 	KeyFile         string
+	AllowedOrigins  []string // 允许跨域的Origin，支持通配符，为空时只允许同源
+	TrustedProxies  []string // 可信代理的IP或CIDR，从这些地址来的X-Forwarded-For/X-Real-IP才会被采用
 	ln              net.Listener
 	Handler      	http.ServeMux
 }
@@ -31,6 +33,10 @@ func (server *HttpServer) Start() {
 		server.HTTPTimeout = 10 * time.Second
 		log.Release("invalid HTTPTimeout, reset to %v", server.HTTPTimeout)
 	}
+	trustedProxies, err := NewTrustedProxies(server.TrustedProxies)
+	if err != nil {
+		log.Fatal("invalid TrustedProxies: %v", err)
+	}
 	if server.CertFile != "" || server.KeyFile != "" {
 		config := &tls.Config{}
 		config.NextProtos = []string{"http/1.1"}
@@ -46,9 +52,14 @@ func (server *HttpServer) Start() {
 	}
 	log.Release("http server init: %s", server.Addr)
 	server.ln = ln
+	// http接口使用AccessKey头认证，不需要携带cookie
+	cors := &CORS{
+		AllowedOrigins: server.AllowedOrigins,
+		MaxAge:         600,
+	}
 	httpServer := &http.Server{
 		Addr:           server.Addr,
-		Handler:        &server.Handler,
+		Handler:        trustedProxies.Handler(cors.Handler(&server.Handler)),
 		ReadTimeout:    server.HTTPTimeout,
 		WriteTimeout:   server.HTTPTimeout,
 		MaxHeaderBytes: 1024,
@@ -0,0 +1,103 @@
+package network
+
+import (
+	"github.com/name5566/leaf/util"
+)
+
+// 消息上下文
+// 由Processor在路由消息前构造，传递给拦截器链
+type MsgContext struct {
+	MsgID string      // 消息ID，json为消息名，protobuf为消息类型名
+	Msg   interface{} // 解码后的消息，raw消息为未解码的数据
+	Agent interface{} // 发送消息的agent
+}
+
+// 消息拦截器
+// 调用next继续执行后续拦截器和消息路由
+// 不调用next即中断调用链，可以通过ctx.WriteMsg回写响应
+// 返回error时agent会断开连接
+type Interceptor func(ctx *MsgContext, next func() error) error
+
+// 构造错误响应消息，默认格式和server中的msg.MakeResponse一致
+// {msgID: {"status": status, "message": message, "data": data}}
+var MakeErrorResponse = func(msgID string, status int, message interface{}, data interface{}) interface{} {
+	return &map[string]interface{}{
+		msgID: map[string]interface{}{
+			"status":  status,
+			"message": message,
+			"data":    data,
+		},
+	}
+}
+
+// 回写消息给agent
+func (ctx *MsgContext) WriteMsg(msg interface{}) {
+	if a, ok := ctx.Agent.(interface {
+		WriteMsg(msg interface{})
+	}); ok {
+		a.WriteMsg(msg)
+	}
+}
+
+// 回写错误响应给agent
+func (ctx *MsgContext) WriteError(status int, message interface{}) {
+	ctx.WriteMsg(MakeErrorResponse(ctx.MsgID, status, message, nil))
+}
+
+// 回写参数校验失败的400响应，data.errors列出每个不合法的字段
+// {msgID: {"status": 400, "message": "invalid request", "data": {"errors": [{"field": "Email", "message": "must be a valid email"}]}}}
+func (ctx *MsgContext) WriteInvalid(errs util.ValidationErrors) {
+	ctx.WriteMsg(MakeErrorResponse(ctx.MsgID, 400, "invalid request", map[string]interface{}{"errors": errs}))
+}
+
+// 按顺序执行拦截器链，最后执行handler
+func ChainInterceptors(interceptors []Interceptor, ctx *MsgContext, handler func() error) error {
+	if len(interceptors) == 0 {
+		return handler()
+	}
+	var next func(i int) error
+	next = func(i int) error {
+		if i == len(interceptors) {
+			return handler()
+		}
+		return interceptors[i](ctx, func() error {
+			return next(i + 1)
+		})
+	}
+	return next(0)
+}
+
+// 消息权限声明
+type MsgAuth struct {
+	Login  bool     // 是否需要登陆
+	Rights []string // 需要的权限(Server:Name)，需要全部拥有
+}
+
+// 已登陆用户的数据需要实现该接口，如gate/user.UserData
+// agent.UserData()未实现该接口时视为未登陆
+type RightChecker interface {
+	HasRight(right string) bool
+}
+
+// 检查agent是否有权限处理消息
+// 返回0表示通过，401表示未登陆，403表示没有权限
+func Authorize(auth *MsgAuth, agent interface{}) (status int, message string) {
+	if auth == nil || (!auth.Login && len(auth.Rights) == 0) {
+		return 0, ""
+	}
+	var checker RightChecker
+	if a, ok := agent.(interface {
+		UserData() interface{}
+	}); ok {
+		checker, _ = a.UserData().(RightChecker)
+	}
+	if checker == nil {
+		return 401, "unlogin"
+	}
+	for _, right := range auth.Rights {
+		if !checker.HasRight(right) {
+			return 403, "permission denied: " + right
+		}
+	}
+	return 0, ""
+}
@@ -6,12 +6,16 @@ import (
 	"fmt"
 	"github.com/name5566/leaf/chanrpc"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"github.com/name5566/leaf/util"
 	"reflect"
+	"sort"
 )
 
 // 处理器类型定义
 type Processor struct {
-	msgInfo map[string]*MsgInfo  //消息信息映射
+	msgInfo      map[string]*MsgInfo    //消息信息映射
+	interceptors []network.Interceptor //消息拦截器链
 }
 
 // 消息信息类型定义
@@ -20,6 +24,7 @@ type MsgInfo struct {
 	msgRouter     *chanrpc.Server  //处理消息的RPC服务器
 	msgHandler    MsgHandler  //消息处理函数
 	msgRawHandler MsgHandler  //原始消息处理函数
+	msgAuth       *network.MsgAuth  //消息权限声明
 	// 处理消息有两种方式，一种的RPC服务器，一种是处理函数，可以同时处理
 }
 
@@ -31,6 +36,14 @@ type MsgRaw struct {
 	msgRawData json.RawMessage
 }
 
+// 原始消息的ID，用于日志和统计
+func (r MsgRaw) MsgID() string {
+	return r.msgID
+}
+
+// 网络层日志
+var netLog = log.Module("network")
+
 //创建一个处理器
 func NewProcessor() *Processor {
 	p := new(Processor)
@@ -52,6 +65,9 @@ func (p *Processor) Register(msg interface{}) string {
 	if _, ok := p.msgInfo[msgID]; ok {  //判断消息是否已经注册
 		log.Fatal("message %v is already registered", msgID)
 	}
+	if err := util.CheckRules(msg); err != nil {  //检查校验规则，tag错误时启动失败
+		log.Fatal("%v", err)
+	}
 
 	i := new(MsgInfo)  //新建一个消息信息
 	i.msgType = msgType  //保存消息类型
@@ -102,6 +118,23 @@ func (p *Processor)SetRawHandler(msgID string, msgRawHandler MsgHandler) {
 	i.msgRawHandler = msgRawHandler
 }
 
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+// 声明消息需要的登陆状态和权限，Route时检查，不满足时返回401/403
+func (p *Processor) SetAuth(msgID string, msgAuth *network.MsgAuth) {
+	i, ok := p.msgInfo[msgID]
+	if !ok {
+		log.Fatal("message %v not registered", msgID)
+	}
+
+	i.msgAuth = msgAuth
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+// 添加消息拦截器，按添加顺序执行
+func (p *Processor) Use(interceptors ...network.Interceptor) {
+	p.interceptors = append(p.interceptors, interceptors...)
+}
+
 // goroutine safe
 //路由
 func (p *Processor) Route(msg interface{}, userData interface{}) error {
@@ -111,10 +144,21 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 		if !ok {
 			return fmt.Errorf("message %v not registered", msgRaw.msgID)
 		}
-		if i.msgRawHandler != nil {
-			i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData})
-		}
-		return nil
+		ctx := &network.MsgContext{MsgID: msgRaw.msgID, Msg: msgRaw.msgRawData, Agent: userData}
+		return network.ChainInterceptors(p.interceptors, ctx, func() error {
+			if status, message := network.Authorize(i.msgAuth, userData); status != 0 {
+				ctx.WriteError(status, message)
+				return nil
+			}
+			if errs := i.validateRaw(msgRaw.msgRawData); errs != nil {  //按注册的类型校验原始消息
+				ctx.WriteInvalid(errs)
+				return nil
+			}
+			if i.msgRawHandler != nil {
+				i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData})
+			}
+			return nil
+		})
 	}
 
 	// json
@@ -127,11 +171,59 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 	if !ok {  //获取失败
 		return fmt.Errorf("message %v not registered", msgID)
 	}
-	if i.msgHandler != nil {  //调用消息处理函数
-		i.msgHandler([]interface{}{msg, userData})
+	ctx := &network.MsgContext{MsgID: msgID, Msg: msg, Agent: userData}
+	return network.ChainInterceptors(p.interceptors, ctx, func() error {
+		if status, message := network.Authorize(i.msgAuth, userData); status != 0 {  //检查权限
+			ctx.WriteError(status, message)
+			return nil
+		}
+		if errs := util.Validate(msg); errs != nil {  //校验消息字段
+			ctx.WriteInvalid(errs)
+			return nil
+		}
+		if i.msgHandler != nil {  //调用消息处理函数
+			i.msgHandler([]interface{}{msg, userData})
+		}
+		if i.msgRouter != nil {  //调用RPC服务器
+			i.msgRouter.Go(msgType, msg, userData)  //rpc服务器自己发起调用
+		}
+		return nil
+	})
+}
+
+// 原始消息注册的类型为结构体时，解码到该类型并校验
+// 解码失败的字段也作为校验错误返回
+func (i *MsgInfo) validateRaw(data json.RawMessage) util.ValidationErrors {
+	if i.msgType.Elem().Kind() != reflect.Struct {
+		return nil
 	}
-	if i.msgRouter != nil {  //调用RPC服务器
-		i.msgRouter.Go(msgType, msg, userData)  //rpc服务器自己发起调用
+	msg := reflect.New(i.msgType.Elem()).Interface()
+	if err := json.Unmarshal(data, msg); err != nil {
+		if e, ok := err.(*json.UnmarshalTypeError); ok {
+			return util.ValidationErrors{{Field: e.Field, Message: "must be " + e.Type.String()}}
+		}
+		return util.ValidationErrors{{Message: err.Error()}}
+	}
+	return util.Validate(msg)
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+// 按msgID顺序遍历已注册的消息
+func (p *Processor) Range(f func(msgID string, t reflect.Type)) {
+	msgIDs := make([]string, 0, len(p.msgInfo))
+	for msgID := range p.msgInfo {
+		msgIDs = append(msgIDs, msgID)
+	}
+	sort.Strings(msgIDs)
+	for _, msgID := range msgIDs {
+		f(msgID, p.msgInfo[msgID].msgType)
+	}
+}
+
+// 消息的权限声明，未声明时返回nil
+func (p *Processor) GetAuth(msgID string) *network.MsgAuth {
+	if i, ok := p.msgInfo[msgID]; ok {
+		return i.msgAuth
 	}
 	return nil
 }
@@ -140,7 +232,6 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 //解码消息
 func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 	var m map[string]json.RawMessage  //存储解码数据。RawMessage is a raw encoded JSON object,used to delay JSON decoding
-	fmt.Printf("unmarshal1: %s, %v\n", data, m)
 	err := json.Unmarshal(data, &m)  //解码
 	if err != nil {
 		return nil, err
@@ -150,7 +241,7 @@ func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 	}
 
 	for msgID, data := range m {  //取出msgID和未解码的data
-		fmt.Printf("msgID: %v, data: %s\n" ,msgID, string(data))
+		netLog.With(log.F("msg_id", msgID), log.F("bytes", len(data))).Debug("unmarshal message")
 		i, ok := p.msgInfo[msgID]  //取出消息信息
 		if !ok {
 			return nil, fmt.Errorf("message %v not registered", msgID)
@@ -161,7 +252,6 @@ func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 			return MsgRaw{msgID, data}, nil
 		} else {
 			msg := reflect.New(i.msgType.Elem()).Interface()  //存储解码数据，msgType本身为一个Ptr
-			fmt.Printf("unmarshal2: %s, %v\n", data, msg)
 			return msg, json.Unmarshal(data, msg)  //解码data
 		}
 	}
@@ -190,7 +280,6 @@ func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
 			}
 		}
 	}
-	//fmt.Printf("marshal msgID: %s, msgInfo: %v\n", msgID, p.msgInfo)
 	if _, ok := p.msgInfo[msgID]; !ok {
 		return nil, fmt.Errorf("message %v not registered", msgID)
 	}
@@ -0,0 +1,13 @@
+package network
+
+import (
+	"github.com/name5566/leaf/metrics"
+)
+
+// 连接统计，transport为ws或tcp
+var (
+	connections    = metrics.NewGauge("leaf_connections", "Live client connections.", "transport")
+	connects       = metrics.NewCounter("leaf_connects_total", "Accepted client connections.", "transport")
+	disconnects    = metrics.NewCounter("leaf_disconnects_total", "Closed client connections.", "transport")
+	writeOverflows = metrics.NewCounter("leaf_write_queue_overflows_total", "Connections closed because the write queue was full.", "transport")
+)
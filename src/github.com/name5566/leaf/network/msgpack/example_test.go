@@ -0,0 +1,99 @@
+package msgpack_test
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/name5566/leaf/network/msgpack"
+	vmsgpack "github.com/vmihailenco/msgpack/v4"
+)
+
+type Hello struct {
+	Name string `json:"name"`
+	Age  int
+}
+
+type Login struct {
+	LoginName string
+	Password  string
+}
+
+func ExampleProcessor() {
+	p := msgpack.NewProcessor()
+	p.Register(&Hello{})
+	p.SetHandler(&Hello{}, func(args []interface{}) {
+		fmt.Printf("%+v\n", *args[0].(*Hello))
+	})
+
+	data, err := p.Marshal(&Hello{Name: "leaf", Age: 3})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	msg, err := p.Unmarshal(data[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	p.Route(msg, nil)
+
+	// 数字ID
+	p.SetNumID("Hello", 1)
+	data, _ = p.Marshal(&Hello{Name: "leaf"})
+	fmt.Printf("%x\n", data[0])
+	msg, _ = p.Unmarshal(data[0])
+	p.Route(msg, nil)
+
+	// Output:
+	// {Name:leaf Age:3}
+	// 810182a46e616d65a46c656166a341676500
+	// {Name:leaf Age:0}
+}
+
+func ExampleProcessor_SetRawHandler() {
+	p := msgpack.NewProcessor()
+	p.Register(&Login{})
+	// 原始数据转换为json，处理函数与json处理器通用
+	p.SetRawHandler("Login", func(args []interface{}) {
+		fmt.Println(args[0], string(args[1].(json.RawMessage)))
+	})
+
+	data, _ := vmsgpack.Marshal(map[string]interface{}{
+		"Login": map[string]interface{}{"LoginName": "leaf", "Password": "secret"},
+	})
+	msg, err := p.Unmarshal(data)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	p.Route(msg, nil)
+
+	// Output:
+	// Login {"LoginName":"leaf","Password":"secret"}
+}
+
+func ExampleProcessor_Marshal() {
+	p := msgpack.NewProcessor()
+	p.Register(&Hello{})
+
+	// 转发的json响应按json内容编码
+	data, err := p.Marshal(&map[string]interface{}{
+		"Hello": map[string]interface{}{
+			"status": 200,
+			"data":   json.RawMessage(`{"id": 12, "score": 1.5, "tags": ["a"]}`),
+		},
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var m map[string]map[string]interface{}
+	if err := vmsgpack.Unmarshal(data[0], &m); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(m["Hello"]["data"])
+
+	// Output:
+	// map[id:12 score:1.5 tags:[a]]
+}
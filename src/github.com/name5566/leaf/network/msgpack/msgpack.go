@@ -0,0 +1,362 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"github.com/name5566/leaf/util"
+	"github.com/vmihailenco/msgpack/v4"
+	"github.com/vmihailenco/msgpack/v4/codes"
+)
+
+// MessagePack处理器
+// 消息格式与json处理器相同，为只有一个键值对的map: {msgID: msg}
+// msgID为消息名(结构体类型名)，通过SetNumID设置数字ID后编码时使用数字ID，解码时两者都支持
+// 结构体字段名优先使用msgpack tag，其次使用json tag
+// 原始消息处理函数收到的数据会转换为json.RawMessage，与json处理器相同，处理函数不需要区分格式
+// 编码时json.RawMessage按其中的json内容编码
+type Processor struct {
+	msgInfo      map[string]*MsgInfo
+	msgName      map[uint16]string // 数字ID对应的消息名
+	interceptors []network.Interceptor
+}
+
+type MsgInfo struct {
+	msgType       reflect.Type
+	numID         uint16
+	hasNumID      bool
+	msgRouter     *chanrpc.Server
+	msgHandler    MsgHandler
+	msgRawHandler MsgHandler
+	msgAuth       *network.MsgAuth
+}
+
+type MsgHandler func([]interface{})
+
+type MsgRaw struct {
+	msgID      string
+	msgRawData json.RawMessage // 从msgpack转换的json
+}
+
+// 原始消息的ID，用于日志和统计
+func (r MsgRaw) MsgID() string {
+	return r.msgID
+}
+
+func NewProcessor() *Processor {
+	p := new(Processor)
+	p.msgInfo = make(map[string]*MsgInfo)
+	p.msgName = make(map[uint16]string)
+	return p
+}
+
+// 获取消息名
+func msgName(msg interface{}) (reflect.Type, string, error) {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		return nil, "", errors.New("msgpack message pointer required")
+	}
+	return msgType, msgType.Elem().Name(), nil
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) Register(msg interface{}) string {
+	msgType, msgID, err := msgName(msg)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	if msgID == "" {
+		log.Fatal("unnamed msgpack message")
+	}
+	if _, ok := p.msgInfo[msgID]; ok {
+		log.Fatal("message %v is already registered", msgID)
+	}
+	if err := util.CheckRules(msg); err != nil {
+		log.Fatal("%v", err)
+	}
+
+	i := new(MsgInfo)
+	i.msgType = msgType
+	p.msgInfo[msgID] = i
+	return msgID
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+// 设置消息的数字ID，编码时使用数字ID代替消息名以减小消息长度
+func (p *Processor) SetNumID(msgID string, numID uint16) {
+	i, ok := p.msgInfo[msgID]
+	if !ok {
+		log.Fatal("message %v not registered", msgID)
+	}
+	if name, ok := p.msgName[numID]; ok {
+		log.Fatal("numeric id %v is already used by message %v", numID, name)
+	}
+	if i.hasNumID {
+		delete(p.msgName, i.numID)
+	}
+
+	i.numID = numID
+	i.hasNumID = true
+	p.msgName[numID] = msgID
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetRouter(msg interface{}, msgRouter *chanrpc.Server) {
+	_, msgID, err := msgName(msg)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	i, ok := p.msgInfo[msgID]
+	if !ok {
+		log.Fatal("message %v not registered", msgID)
+	}
+
+	i.msgRouter = msgRouter
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetHandler(msg interface{}, msgHandler MsgHandler) {
+	_, msgID, err := msgName(msg)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	i, ok := p.msgInfo[msgID]
+	if !ok {
+		log.Fatal("message %v not registered", msgID)
+	}
+
+	i.msgHandler = msgHandler
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetRawHandler(msgID string, msgRawHandler MsgHandler) {
+	i, ok := p.msgInfo[msgID]
+	if !ok {
+		log.Fatal("message %v not registered", msgID)
+	}
+
+	i.msgRawHandler = msgRawHandler
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+// 声明消息需要的登陆状态和权限，Route时检查，不满足时返回401/403
+func (p *Processor) SetAuth(msgID string, msgAuth *network.MsgAuth) {
+	i, ok := p.msgInfo[msgID]
+	if !ok {
+		log.Fatal("message %v not registered", msgID)
+	}
+
+	i.msgAuth = msgAuth
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+// 添加消息拦截器，按添加顺序执行
+func (p *Processor) Use(interceptors ...network.Interceptor) {
+	p.interceptors = append(p.interceptors, interceptors...)
+}
+
+// goroutine safe
+func (p *Processor) Route(msg interface{}, userData interface{}) error {
+	// raw
+	if msgRaw, ok := msg.(MsgRaw); ok {
+		i, ok := p.msgInfo[msgRaw.msgID]
+		if !ok {
+			return fmt.Errorf("message %v not registered", msgRaw.msgID)
+		}
+		ctx := &network.MsgContext{MsgID: msgRaw.msgID, Msg: msgRaw.msgRawData, Agent: userData}
+		return network.ChainInterceptors(p.interceptors, ctx, func() error {
+			if status, message := network.Authorize(i.msgAuth, userData); status != 0 {
+				ctx.WriteError(status, message)
+				return nil
+			}
+			if errs := i.validateRaw(msgRaw.msgRawData); errs != nil {
+				ctx.WriteInvalid(errs)
+				return nil
+			}
+			if i.msgRawHandler != nil {
+				i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData})
+			}
+			return nil
+		})
+	}
+
+	// msgpack
+	msgType, msgID, err := msgName(msg)
+	if err != nil {
+		return err
+	}
+	i, ok := p.msgInfo[msgID]
+	if !ok {
+		return fmt.Errorf("message %v not registered", msgID)
+	}
+	ctx := &network.MsgContext{MsgID: msgID, Msg: msg, Agent: userData}
+	return network.ChainInterceptors(p.interceptors, ctx, func() error {
+		if status, message := network.Authorize(i.msgAuth, userData); status != 0 {
+			ctx.WriteError(status, message)
+			return nil
+		}
+		if errs := util.Validate(msg); errs != nil {
+			ctx.WriteInvalid(errs)
+			return nil
+		}
+		if i.msgHandler != nil {
+			i.msgHandler([]interface{}{msg, userData})
+		}
+		if i.msgRouter != nil {
+			i.msgRouter.Go(msgType, msg, userData)
+		}
+		return nil
+	})
+}
+
+// 原始消息注册的类型为结构体时，解码到该类型并校验
+func (i *MsgInfo) validateRaw(data json.RawMessage) util.ValidationErrors {
+	if i.msgType.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	msg := reflect.New(i.msgType.Elem()).Interface()
+	if err := json.Unmarshal(data, msg); err != nil {
+		if e, ok := err.(*json.UnmarshalTypeError); ok {
+			return util.ValidationErrors{{Field: e.Field, Message: "must be " + e.Type.String()}}
+		}
+		return util.ValidationErrors{{Message: err.Error()}}
+	}
+	return util.Validate(msg)
+}
+
+// 原始消息转换为json
+func toJSON(d *msgpack.Decoder) (json.RawMessage, error) {
+	v, err := d.DecodeInterface()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func init() {
+	msgpack.Register(json.RawMessage(nil), encodeRawJSON, nil)
+	msgpack.Register(json.Number(""), encodeJSONNumber, nil)
+}
+
+// json.RawMessage按json内容编码，而不是二进制数据
+func encodeRawJSON(e *msgpack.Encoder, v reflect.Value) error {
+	if v.Len() == 0 {
+		return e.EncodeNil()
+	}
+	d := json.NewDecoder(bytes.NewReader(v.Bytes()))
+	d.UseNumber()
+	var x interface{}
+	if err := d.Decode(&x); err != nil {
+		return err
+	}
+	return e.Encode(x)
+}
+
+// 整数编码为整数，其余编码为浮点数
+func encodeJSONNumber(e *msgpack.Encoder, v reflect.Value) error {
+	if n, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+		return e.EncodeInt(n)
+	}
+	f, err := strconv.ParseFloat(v.String(), 64)
+	if err != nil {
+		return err
+	}
+	return e.EncodeFloat64(f)
+}
+
+// goroutine safe
+func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
+	r := bytes.NewReader(data)
+	d := msgpack.NewDecoder(r).UseJSONTag(true)
+	n, err := d.DecodeMapLen()
+	if err != nil {
+		return nil, err
+	}
+	if n != 1 {
+		return nil, errors.New("invalid msgpack data")
+	}
+
+	// msgID为消息名或数字ID
+	var msgID string
+	c, err := d.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+	if codes.IsString(c) {
+		if msgID, err = d.DecodeString(); err != nil {
+			return nil, err
+		}
+	} else {
+		numID, err := d.DecodeUint64()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := p.msgName[uint16(numID)]
+		if !ok || numID > 0xffff {
+			return nil, fmt.Errorf("message %v not registered", numID)
+		}
+		msgID = name
+	}
+	i, ok := p.msgInfo[msgID]
+	if !ok {
+		return nil, fmt.Errorf("message %v not registered", msgID)
+	}
+
+	// msg
+	if i.msgRawHandler != nil {
+		raw, err := toJSON(d)
+		if err != nil {
+			return nil, err
+		}
+		return MsgRaw{msgID, raw}, nil
+	}
+	msg := reflect.New(i.msgType.Elem()).Interface()
+	return msg, d.Decode(msg)
+}
+
+// goroutine safe
+// 与json处理器相同，支持*map[string]interface{}{msgID: msg}形式的消息
+func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
+	_, msgID, err := msgName(msg)
+	if err != nil {
+		return nil, err
+	}
+	if msgID == "" {
+		if msgDict, ok := msg.(*map[string]interface{}); ok && len(*msgDict) == 1 {
+			for k, v := range *msgDict {
+				msgID = k
+				msg = v
+			}
+		}
+	}
+	i, ok := p.msgInfo[msgID]
+	if !ok {
+		return nil, fmt.Errorf("message %v not registered", msgID)
+	}
+
+	// data
+	var buf bytes.Buffer
+	e := msgpack.NewEncoder(&buf).UseJSONTag(true).UseCompactEncoding(true) // 整数使用最短编码
+	if err := e.EncodeMapLen(1); err != nil {
+		return nil, err
+	}
+	if i.hasNumID {
+		err = e.EncodeUint(uint64(i.numID))
+	} else {
+		err = e.EncodeString(msgID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := e.Encode(msg); err != nil {
+		return nil, err
+	}
+	return [][]byte{buf.Bytes()}, nil
+}
@@ -0,0 +1,76 @@
+package multi_test
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/name5566/leaf/network/multi"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+type Login struct {
+	LoginName string `validate:"required"`
+	Password  string
+}
+
+type Hello struct {
+	Name string
+}
+
+func ExampleProcessor() {
+	p := multi.NewProcessor()
+	p.Register(&Hello{})
+	p.SetHandler(&Hello{}, func(args []interface{}) {
+		fmt.Println("hello", args[0].(*Hello).Name)
+	})
+
+	for _, name := range p.Names() {
+		processor := p.Get(name)
+		data, err := processor.Marshal(&Hello{Name: name})
+		if err != nil { // Hello没有实现proto.Message
+			fmt.Println(err)
+			continue
+		}
+		msg, err := processor.Unmarshal(data[0])
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		processor.Route(msg, nil)
+	}
+
+	// Output:
+	// hello json
+	// hello msgpack
+	// message *multi_test.Hello not registered
+}
+
+func ExampleProcessor_SetRawHandler() {
+	p := multi.NewProcessor()
+	p.Register(&Login{})
+	// 各格式的处理函数都收到json
+	p.SetRawHandler("Login", func(args []interface{}) {
+		fmt.Println(args[0], string(args[1].(json.RawMessage)))
+	})
+
+	jsonData := []byte(`{"Login": {"LoginName": "leaf", "Password": "secret"}}`)
+	msgpackData, _ := msgpack.Marshal(map[string]interface{}{
+		"Login": map[string]interface{}{"LoginName": "leaf", "Password": "secret"},
+	})
+	for _, m := range []struct {
+		name string
+		data []byte
+	}{{multi.JSON, jsonData}, {multi.Msgpack, msgpackData}} {
+		processor := p.Get(m.name)
+		msg, err := processor.Unmarshal(m.data)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		processor.Route(msg, nil)
+	}
+
+	// Output:
+	// Login {"LoginName": "leaf", "Password": "secret"}
+	// Login {"LoginName":"leaf","Password":"secret"}
+}
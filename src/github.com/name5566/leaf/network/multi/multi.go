@@ -0,0 +1,184 @@
+package multi
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"github.com/name5566/leaf/network/json"
+	"github.com/name5566/leaf/network/msgpack"
+	"github.com/name5566/leaf/network/protobuf"
+)
+
+// 多格式处理器集合
+// 消息、处理函数、权限和拦截器只需注册一次，会同时注册到所有格式的处理器中
+// 通过Gate.WSSubprotocols按websocket子协议选择格式，或通过Get取出单个处理器用于某个监听
+// protobuf只注册实现了proto.Message的消息
+// 原始消息处理函数(SetRawHandler)只设置到json和msgpack，两者传给处理函数的数据都为json.RawMessage
+type Processor struct {
+	processors map[string]network.Processor
+	msgs       map[string]interface{} // 已注册的消息，key为消息名
+}
+
+// 格式名
+const (
+	JSON     = "json"
+	Protobuf = "protobuf"
+	Msgpack  = "msgpack"
+)
+
+// 创建包含json、protobuf和msgpack处理器的集合
+func NewProcessor() *Processor {
+	p := new(Processor)
+	p.processors = map[string]network.Processor{
+		JSON:     json.NewProcessor(),
+		Protobuf: protobuf.NewProcessor(),
+		Msgpack:  msgpack.NewProcessor(),
+	}
+	p.msgs = make(map[string]interface{})
+	return p
+}
+
+// 获取指定格式的处理器，不存在时返回nil
+func (p *Processor) Get(name string) network.Processor {
+	return p.processors[name]
+}
+
+// 所有格式的处理器，key为格式名
+func (p *Processor) Processors() map[string]network.Processor {
+	return p.processors
+}
+
+// 格式名列表
+func (p *Processor) Names() []string {
+	names := make([]string, 0, len(p.processors))
+	for name := range p.processors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) Register(msg interface{}) string {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		log.Fatal("message pointer required")
+	}
+	msgID := msgType.Elem().Name()
+	p.msgs[msgID] = msg
+
+	for _, processor := range p.processors {
+		switch processor := processor.(type) {
+		case *json.Processor:
+			processor.Register(msg)
+		case *msgpack.Processor:
+			processor.Register(msg)
+		case *protobuf.Processor:
+			if pm, ok := msg.(proto.Message); ok {
+				processor.Register(pm)
+			}
+		}
+	}
+	return msgID
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetRouter(msg interface{}, msgRouter *chanrpc.Server) {
+	for _, processor := range p.processors {
+		switch processor := processor.(type) {
+		case *json.Processor:
+			processor.SetRouter(msg, msgRouter)
+		case *msgpack.Processor:
+			processor.SetRouter(msg, msgRouter)
+		case *protobuf.Processor:
+			if pm, ok := msg.(proto.Message); ok {
+				processor.SetRouter(pm, msgRouter)
+			}
+		}
+	}
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+// 各格式的处理函数参数相同: []interface{}{msg, agent}
+func (p *Processor) SetHandler(msg interface{}, msgHandler func([]interface{})) {
+	for _, processor := range p.processors {
+		switch processor := processor.(type) {
+		case *json.Processor:
+			processor.SetHandler(msg, msgHandler)
+		case *msgpack.Processor:
+			processor.SetHandler(msg, msgHandler)
+		case *protobuf.Processor:
+			if pm, ok := msg.(proto.Message); ok {
+				processor.SetHandler(pm, msgHandler)
+			}
+		}
+	}
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+// 处理函数参数为[]interface{}{msgID, json.RawMessage, agent}
+// protobuf的原始数据为protobuf编码，不设置
+func (p *Processor) SetRawHandler(msgID string, msgRawHandler func([]interface{})) {
+	if _, ok := p.msgs[msgID]; !ok {
+		log.Fatal("message %v not registered", msgID)
+	}
+	for _, processor := range p.processors {
+		switch processor := processor.(type) {
+		case *json.Processor:
+			processor.SetRawHandler(msgID, msgRawHandler)
+		case *msgpack.Processor:
+			processor.SetRawHandler(msgID, msgRawHandler)
+		}
+	}
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+// 声明消息需要的登陆状态和权限
+func (p *Processor) SetAuth(msgID string, msgAuth *network.MsgAuth) {
+	msg, ok := p.msgs[msgID]
+	if !ok {
+		log.Fatal("message %v not registered", msgID)
+	}
+	for _, processor := range p.processors {
+		switch processor := processor.(type) {
+		case *json.Processor:
+			processor.SetAuth(msgID, msgAuth)
+		case *msgpack.Processor:
+			processor.SetAuth(msgID, msgAuth)
+		case *protobuf.Processor:
+			if pm, ok := msg.(proto.Message); ok {
+				processor.SetAuth(pm, msgAuth)
+			}
+		}
+	}
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+// 添加消息拦截器，按添加顺序执行
+func (p *Processor) Use(interceptors ...network.Interceptor) {
+	for _, processor := range p.processors {
+		switch processor := processor.(type) {
+		case *json.Processor:
+			processor.Use(interceptors...)
+		case *msgpack.Processor:
+			processor.Use(interceptors...)
+		case *protobuf.Processor:
+			processor.Use(interceptors...)
+		}
+	}
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+// 按msgID顺序遍历已注册的消息
+func (p *Processor) Range(f func(msgID string, t reflect.Type)) {
+	p.processors[JSON].(*json.Processor).Range(f)
+}
+
+// 消息的权限声明，未声明时返回nil
+func (p *Processor) GetAuth(msgID string) *network.MsgAuth {
+	return p.processors[JSON].(*json.Processor).GetAuth(msgID)
+}
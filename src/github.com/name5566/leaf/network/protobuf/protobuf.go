@@ -7,6 +7,8 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/name5566/leaf/chanrpc"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"github.com/name5566/leaf/util"
 	"math"
 	"reflect"
 )
@@ -18,6 +20,7 @@ type Processor struct {
 	littleEndian bool
 	msgInfo      []*MsgInfo
 	msgID        map[reflect.Type]uint16
+	interceptors []network.Interceptor
 }
 
 type MsgInfo struct {
@@ -25,6 +28,7 @@ type MsgInfo struct {
 	msgRouter     *chanrpc.Server
 	msgHandler    MsgHandler
 	msgRawHandler MsgHandler
+	msgAuth       *network.MsgAuth
 }
 
 type MsgHandler func([]interface{})
@@ -58,6 +62,9 @@ func (p *Processor) Register(msg proto.Message) uint16 {
 	if len(p.msgInfo) >= math.MaxUint16 {
 		log.Fatal("too many protobuf messages (max = %v)", math.MaxUint16)
 	}
+	if err := util.CheckRules(msg); err != nil {
+		log.Fatal("%v", err)
+	}
 
 	i := new(MsgInfo)
 	i.msgType = msgType
@@ -98,6 +105,22 @@ func (p *Processor) SetRawHandler(id uint16, msgRawHandler MsgHandler) {
 	p.msgInfo[id].msgRawHandler = msgRawHandler
 }
 
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetAuth(msg proto.Message, msgAuth *network.MsgAuth) {
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+	}
+
+	p.msgInfo[id].msgAuth = msgAuth
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) Use(interceptors ...network.Interceptor) {
+	p.interceptors = append(p.interceptors, interceptors...)
+}
+
 // goroutine safe
 func (p *Processor) Route(msg interface{}, userData interface{}) error {
 	// raw
@@ -106,10 +129,21 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 			return fmt.Errorf("message id %v not registered", msgRaw.msgID)
 		}
 		i := p.msgInfo[msgRaw.msgID]
-		if i.msgRawHandler != nil {
-			i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData})
-		}
-		return nil
+		ctx := &network.MsgContext{MsgID: i.msgType.Elem().Name(), Msg: msgRaw.msgRawData, Agent: userData}
+		return network.ChainInterceptors(p.interceptors, ctx, func() error {
+			if status, message := network.Authorize(i.msgAuth, userData); status != 0 {
+				ctx.WriteError(status, message)
+				return nil
+			}
+			if errs := i.validateRaw(msgRaw.msgRawData); errs != nil {
+				ctx.WriteInvalid(errs)
+				return nil
+			}
+			if i.msgRawHandler != nil {
+				i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData})
+			}
+			return nil
+		})
 	}
 
 	// protobuf
@@ -119,13 +153,33 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 		return fmt.Errorf("message %s not registered", msgType)
 	}
 	i := p.msgInfo[id]
-	if i.msgHandler != nil {
-		i.msgHandler([]interface{}{msg, userData})
-	}
-	if i.msgRouter != nil {
-		i.msgRouter.Go(msgType, msg, userData)
+	ctx := &network.MsgContext{MsgID: msgType.Elem().Name(), Msg: msg, Agent: userData}
+	return network.ChainInterceptors(p.interceptors, ctx, func() error {
+		if status, message := network.Authorize(i.msgAuth, userData); status != 0 {
+			ctx.WriteError(status, message)
+			return nil
+		}
+		if errs := util.Validate(msg); errs != nil {
+			ctx.WriteInvalid(errs)
+			return nil
+		}
+		if i.msgHandler != nil {
+			i.msgHandler([]interface{}{msg, userData})
+		}
+		if i.msgRouter != nil {
+			i.msgRouter.Go(msgType, msg, userData)
+		}
+		return nil
+	})
+}
+
+// 原始消息解码到注册的类型并校验，解码失败也作为校验错误返回
+func (i *MsgInfo) validateRaw(data []byte) util.ValidationErrors {
+	msg := reflect.New(i.msgType.Elem()).Interface().(proto.Message)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return util.ValidationErrors{{Message: err.Error()}}
 	}
-	return nil
+	return util.Validate(msg)
 }
 
 // goroutine safe
@@ -0,0 +1,213 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/name5566/leaf/util"
+)
+
+// 可信代理
+// 只有来自可信代理的X-Forwarded-For/X-Real-IP和PROXY protocol头才会被采用
+type TrustedProxies []*net.IPNet
+
+func NewTrustedProxies(cidrs []string) (TrustedProxies, error) {
+	t := make(TrustedProxies, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		ipNet, err := util.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		t = append(t, ipNet)
+	}
+	return t, nil
+}
+
+// ip是否为可信代理
+func (t TrustedProxies) Contains(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range t {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// 获取http请求的真实客户端地址
+// 直接连接的地址是可信代理时，从右向左查找X-Forwarded-For中第一个非可信代理的地址，没有则使用X-Real-IP
+func (t TrustedProxies) RemoteAddr(r *http.Request) net.Addr {
+	addr := remoteAddr(r)
+	if len(t) == 0 || !t.Contains(RemoteIP(addr)) {
+		return addr
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		ips := strings.Split(xff, ",")
+		for i := len(ips) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(ips[i])
+			if net.ParseIP(ip) == nil {
+				break
+			}
+			if i == 0 || !t.Contains(ip) {
+				return &net.TCPAddr{IP: net.ParseIP(ip)}
+			}
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return &net.TCPAddr{IP: ip}
+	}
+	return addr
+}
+
+// 替换http请求的RemoteAddr为真实客户端地址
+func (t TrustedProxies) Handler(h http.Handler) http.Handler {
+	if len(t) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if addr := t.RemoteAddr(r); addr != nil {
+			r.RemoteAddr = addr.String()
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// PROXY protocol
+// reference: https://www.haproxy.org/download/1.8/doc/proxy-protocol.txt
+
+// 读取PROXY protocol头的超时时间
+var ProxyHeaderTimeout = 5 * time.Second
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// 带PROXY protocol头的连接，RemoteAddr返回头中的客户端地址
+type proxyConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *proxyConn) SetLinger(sec int) error {
+	if l, ok := c.Conn.(interface {
+		SetLinger(sec int) error
+	}); ok {
+		return l.SetLinger(sec)
+	}
+	return nil
+}
+
+// 读取并解析PROXY protocol v1/v2头
+// 返回的连接RemoteAddr为真实客户端地址，LOCAL命令和UNKNOWN协议时保留原地址
+func readProxyHeader(conn net.Conn) (net.Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(ProxyHeaderTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	reader := bufio.NewReaderSize(conn, 256)
+	sig, err := reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+
+	var addr net.Addr
+	if bytes.Equal(sig, proxyV2Signature) {
+		addr, err = readProxyV2(reader)
+	} else if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		addr, err = readProxyV1(reader)
+	} else {
+		err = errors.New("proxy protocol header not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if addr == nil {
+		addr = conn.RemoteAddr()
+	}
+	return &proxyConn{Conn: conn, reader: reader, remoteAddr: addr}, nil
+}
+
+// PROXY TCP4 255.255.255.255 255.255.255.255 65535 65535\r\n
+func readProxyV1(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("invalid proxy protocol v1 header")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid proxy protocol v1 header: %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid proxy protocol v1 header: %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// | signature(12) | ver_cmd(1) | fam(1) | len(2) | addresses |
+func readProxyV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("invalid proxy protocol version: %d", header[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+
+	// LOCAL命令为代理自身的连接(如健康检查)
+	if header[12]&0xf == 0 {
+		return nil, nil
+	}
+	switch header[13] {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, errors.New("invalid proxy protocol v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, errors.New("invalid proxy protocol v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:]))}, nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,136 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+)
+
+// PROXY protocol v2头，addrs为源地址、目的地址、源端口、目的端口
+func proxyV2(verCmd byte, fam byte, addrs []byte) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, verCmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+// ip和端口按v2地址格式编码
+func proxyV2Addrs(src, dst string, srcPort, dstPort uint16) []byte {
+	srcIP, dstIP := net.ParseIP(src), net.ParseIP(dst)
+	if ip4 := srcIP.To4(); ip4 != nil {
+		srcIP, dstIP = ip4, dstIP.To4()
+	}
+	addrs := append(append([]byte{}, srcIP...), dstIP...)
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports, srcPort)
+	binary.BigEndian.PutUint16(ports[2:], dstPort)
+	return append(addrs, ports...)
+}
+
+func Example_readProxyHeader() {
+	v2TCP4 := proxyV2Addrs("192.168.0.1", "10.0.0.1", 56324, 443)
+	v2TCP6 := proxyV2Addrs("2001:db8::1", "2001:db8::2", 56324, 443)
+	truncated := proxyV2(0x21, 0x11, v2TCP4)
+
+	for _, c := range []struct {
+		name string
+		data []byte
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\nhello")},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n")},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\nhello")},
+		{"v1 truncated", []byte("PROXY TCP4 192.168.0.1")},
+		{"v1 no crlf", []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\n")},
+		{"v1 bad port", []byte("PROXY TCP4 192.168.0.1 10.0.0.1 70000 443\r\n")},
+		{"v1 bad ip", []byte("PROXY TCP4 192.168.0 10.0.0.1 56324 443\r\n")},
+		{"short", []byte("PROXY")},
+		{"no header", []byte("GET / HTTP/1.1\r\n")},
+		{"v2 tcp4", append(proxyV2(0x21, 0x11, v2TCP4), "hello"...)},
+		{"v2 tcp6", proxyV2(0x21, 0x21, v2TCP6)},
+		{"v2 local", append(proxyV2(0x20, 0x00, nil), "hello"...)},
+		{"v2 unix", proxyV2(0x21, 0x31, make([]byte, 216))},
+		{"v2 truncated header", truncated[:14]},
+		{"v2 truncated address", truncated[:20]},
+		{"v2 short address", proxyV2(0x21, 0x11, v2TCP4[:4])},
+		{"v2 bad version", proxyV2(0x11, 0x11, v2TCP4)},
+	} {
+		client, server := net.Pipe()
+		go func() {
+			client.Write(c.data)
+			client.Close()
+		}()
+		conn, err := readProxyHeader(server)
+		if err != nil {
+			fmt.Printf("%v: %v\n", c.name, err)
+			server.Close()
+			continue
+		}
+		rest, _ := ioutil.ReadAll(conn)
+		fmt.Printf("%v: %v %q\n", c.name, conn.RemoteAddr(), rest)
+		conn.Close()
+	}
+
+	// Output:
+	// v1 tcp4: 192.168.0.1:56324 "hello"
+	// v1 tcp6: [2001:db8::1]:56324 ""
+	// v1 unknown: pipe "hello"
+	// v1 truncated: EOF
+	// v1 no crlf: invalid proxy protocol v1 header
+	// v1 bad port: invalid proxy protocol v1 header: "PROXY TCP4 192.168.0.1 10.0.0.1 70000 443\r\n"
+	// v1 bad ip: invalid proxy protocol v1 header: "PROXY TCP4 192.168.0 10.0.0.1 56324 443\r\n"
+	// short: EOF
+	// no header: proxy protocol header not found
+	// v2 tcp4: 192.168.0.1:56324 "hello"
+	// v2 tcp6: [2001:db8::1]:56324 ""
+	// v2 local: pipe "hello"
+	// v2 unix: pipe ""
+	// v2 truncated header: unexpected EOF
+	// v2 truncated address: unexpected EOF
+	// v2 short address: invalid proxy protocol v2 address
+	// v2 bad version: invalid proxy protocol version: 1
+}
+
+func ExampleTrustedProxies_RemoteAddr() {
+	t, err := NewTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1"})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, c := range []struct {
+		remote, xff, realIP string
+	}{
+		// 不是可信代理时忽略请求头
+		{"1.2.3.4:1000", "5.6.7.8", "5.6.7.8"},
+		// 从右向左第一个非可信代理的地址，客户端伪造的左侧地址不采用
+		{"10.0.0.1:1000", "9.9.9.9, 5.6.7.8, 10.0.0.2", ""},
+		// 都是可信代理时使用最左侧的地址
+		{"127.0.0.1:1000", "10.0.0.3, 10.0.0.2", ""},
+		// 遇到不合法的地址停止，使用X-Real-IP
+		{"10.0.0.1:1000", "unknown, 10.0.0.2", "5.6.7.8"},
+		{"10.0.0.1:1000", "", "5.6.7.8"},
+		{"10.0.0.1:1000", "2001:db8::1", ""},
+		{"10.0.0.1:1000", "", ""},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		if c.xff != "" {
+			r.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-IP", c.realIP)
+		}
+		fmt.Println(t.RemoteAddr(r))
+	}
+
+	// Output:
+	// 1.2.3.4:1000
+	// 5.6.7.8:0
+	// 10.0.0.3:0
+	// 5.6.7.8:0
+	// 5.6.7.8:0
+	// [2001:db8::1]:0
+	// 10.0.0.1:1000
+}
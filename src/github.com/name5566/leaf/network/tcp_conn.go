@@ -44,7 +44,11 @@ func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser *MsgParser) *TCPCo
 }
 
 func (tcpConn *TCPConn) doDestroy() {
-	tcpConn.conn.(*net.TCPConn).SetLinger(0)
+	if l, ok := tcpConn.conn.(interface { // *net.TCPConn或proxyConn
+		SetLinger(sec int) error
+	}); ok {
+		l.SetLinger(0)
+	}
 	tcpConn.conn.Close()
 
 	if !tcpConn.closeFlag {
@@ -74,6 +78,7 @@ func (tcpConn *TCPConn) Close() {
 func (tcpConn *TCPConn) doWrite(b []byte) {
 	if len(tcpConn.writeChan) == cap(tcpConn.writeChan) {
 		log.Debug("close conn: channel full")
+		writeOverflows.Inc("tcp")
 		tcpConn.doDestroy()
 		return
 	}
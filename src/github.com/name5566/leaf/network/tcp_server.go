@@ -1,6 +1,7 @@
 package network
 
 import (
+	"crypto/tls"
 	"github.com/name5566/leaf/log"
 	"net"
 	"sync"
@@ -10,10 +11,19 @@ import (
 type TCPServer struct {
 	Addr            string
 	MaxConnNum      int
+	MaxConnPerIP    int                  // 每个IP的最大连接数，0为不限制
+	IPFilter        func(ip string) bool // 返回false时拒绝该IP的连接，nil不检查
+	ProxyProtocol   bool                 // 是否解析PROXY protocol v1/v2头
+	TrustedProxies  []string             // 可信代理的IP或CIDR，只解析从这些地址来的连接的PROXY protocol头
 	PendingWriteNum int
+	CertFile        string // 与KeyFile同时设置时启用TLS
+	KeyFile         string
 	NewAgent        func(*TCPConn) Agent
 	ln              net.Listener
+	tlsConfig       *tls.Config
+	trustedProxies  TrustedProxies
 	conns           ConnSet
+	ipConns         ipConnCounter
 	mutexConns      sync.Mutex
 	wgLn            sync.WaitGroup
 	wgConns         sync.WaitGroup
@@ -48,9 +58,27 @@ func (server *TCPServer) init() {
 	if server.NewAgent == nil {
 		log.Fatal("NewAgent must not be nil")
 	}
+	server.trustedProxies, err = NewTrustedProxies(server.TrustedProxies)
+	if err != nil {
+		log.Fatal("invalid TrustedProxies: %v", err)
+	}
+	if server.ProxyProtocol && len(server.trustedProxies) == 0 {
+		log.Release("ProxyProtocol is enabled but no TrustedProxies, PROXY protocol header will be ignored")
+	}
+
+	if server.CertFile != "" || server.KeyFile != "" {
+		config := &tls.Config{}
+		config.Certificates = make([]tls.Certificate, 1)
+		config.Certificates[0], err = tls.LoadX509KeyPair(server.CertFile, server.KeyFile)
+		if err != nil {
+			log.Fatal("%v", err)
+		}
+		server.tlsConfig = config
+	}
 
 	server.ln = ln
 	server.conns = make(ConnSet)
+	server.ipConns = make(ipConnCounter)
 
 	// msg parser
 	msgParser := NewMsgParser()
@@ -84,33 +112,73 @@ func (server *TCPServer) run() {
 		}
 		tempDelay = 0
 
-		server.mutexConns.Lock()
-		if len(server.conns) >= server.MaxConnNum {
-			server.mutexConns.Unlock()
-			conn.Close()
-			log.Debug("too many connections")
-			continue
-		}
-		server.conns[conn] = struct{}{}
-		server.mutexConns.Unlock()
-
 		server.wgConns.Add(1)
+		go server.handle(conn)
+	}
+}
+
+// 处理新连接，解析PROXY protocol头后创建agent
+func (server *TCPServer) handle(conn net.Conn) {
+	defer server.wgConns.Done()
+
+	rawConn := conn
+	if server.ProxyProtocol && server.trustedProxies.Contains(RemoteIP(conn.RemoteAddr())) {
+		var err error
+		if conn, err = readProxyHeader(conn); err != nil {
+			rawConn.Close()
+			log.Debug("read proxy protocol header from %v error: %v", rawConn.RemoteAddr(), err)
+			return
+		}
+	}
 
-		tcpConn := newTCPConn(conn, server.PendingWriteNum, server.msgParser)
-		agent := server.NewAgent(tcpConn)
-		go func() {
-			agent.Run()
+	ip := RemoteIP(conn.RemoteAddr())
+	if server.IPFilter != nil && !server.IPFilter(ip) {
+		conn.Close()
+		log.Debug("connection from %v refused", ip)
+		return
+	}
 
-			// cleanup
-			tcpConn.Close()
-			server.mutexConns.Lock()
-			delete(server.conns, conn)
-			server.mutexConns.Unlock()
-			agent.OnClose()
+	// 在PROXY protocol头之后握手，握手在第一次读写时进行
+	if server.tlsConfig != nil {
+		conn = tls.Server(conn, server.tlsConfig)
+	}
 
-			server.wgConns.Done()
-		}()
+	server.mutexConns.Lock()
+	if server.conns == nil { // 服务器已关闭
+		server.mutexConns.Unlock()
+		conn.Close()
+		return
+	}
+	if len(server.conns) >= server.MaxConnNum {
+		server.mutexConns.Unlock()
+		conn.Close()
+		log.Debug("too many connections")
+		return
 	}
+	if !server.ipConns.add(ip, server.MaxConnPerIP) {
+		server.mutexConns.Unlock()
+		conn.Close()
+		log.Debug("too many connections from %v", ip)
+		return
+	}
+	server.conns[rawConn] = struct{}{}
+	server.mutexConns.Unlock()
+	connections.Inc("tcp")
+	connects.Inc("tcp")
+
+	tcpConn := newTCPConn(conn, server.PendingWriteNum, server.msgParser)
+	agent := server.NewAgent(tcpConn)
+	agent.Run()
+
+	// cleanup
+	tcpConn.Close()
+	server.mutexConns.Lock()
+	delete(server.conns, rawConn)
+	server.ipConns.del(ip)
+	server.mutexConns.Unlock()
+	connections.Dec("tcp")
+	disconnects.Inc("tcp")
+	agent.OnClose()
 }
 
 func (server *TCPServer) Close() {
@@ -100,7 +100,7 @@ reconnect:
 	client.conns[conn] = struct{}{}
 	client.Unlock()
 
-	wsConn := newWSConn(conn, client.PendingWriteNum, client.MaxMsgLen)
+	wsConn := newWSConn(conn, client.PendingWriteNum, client.MaxMsgLen, conn.RemoteAddr())
 	agent := client.NewAgent(wsConn)
 	agent.OnInit(&client.closeFlag)
 	agent.Run()
@@ -13,26 +13,27 @@ type WebsocketConnSet map[*websocket.Conn]struct{}
 
 type WSConn struct {
 	sync.Mutex
-	conn      *websocket.Conn
-	writeChan chan []byte
-	maxMsgLen uint32
-	closeFlag bool
+	conn       *websocket.Conn
+	writeChan  chan []byte
+	maxMsgLen  uint32
+	closeFlag  bool
+	remoteAddr net.Addr // 客户端地址，经过可信代理时为真实客户端地址
 }
 
 // 通过websocket连接conn新建WSConn对象,
 // 启动go协程去队列writeChan中取数据写入conn返回给客户端
 // 写完之后置该对象关闭标志为true
-// pendingWriteNum: 写入队列容量, maxMsgLen: 最大消息长度
-func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32) *WSConn {
+// pendingWriteNum: 写入队列容量, maxMsgLen: 最大消息长度, remoteAddr: 客户端地址
+func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32, remoteAddr net.Addr) *WSConn {
 	wsConn := new(WSConn)
 	wsConn.conn = conn
+	wsConn.remoteAddr = remoteAddr
 
 	wsConn.writeChan = make(chan []byte, pendingWriteNum)
 	wsConn.maxMsgLen = maxMsgLen
-	fmt.Printf("ws wrtite chan init: maxMsgLen: %d, pendingWriteNum: %d\n", maxMsgLen, pendingWriteNum)
+	netLog.With(log.F("remote", remoteAddr), log.F("max_msg_len", maxMsgLen), log.F("pending_write_num", pendingWriteNum)).Debug("new ws conn")
 	go func() {
 		for b := range wsConn.writeChan {
-			fmt.Sprintf("new ws conn write message: %v\n", b)
 			if b == nil {
 				break
 			}
@@ -88,10 +89,10 @@ func (wsConn *WSConn) Close() {
 func (wsConn *WSConn) doWrite(b []byte) {
 	if len(wsConn.writeChan) == cap(wsConn.writeChan) {
 		log.Debug("close conn: channel full")
+		writeOverflows.Inc("ws")
 		wsConn.doDestroy()
 		return
 	}
-	fmt.Printf("ws do write: write chan: %v,content: %s\n", wsConn.writeChan, string(b))
 	wsConn.writeChan <- b
 }
 
@@ -100,14 +101,21 @@ func (wsConn *WSConn) LocalAddr() net.Addr {
 }
 
 func (wsConn *WSConn) RemoteAddr() net.Addr {
-	return wsConn.conn.RemoteAddr()
+	return wsConn.remoteAddr
+}
+
+// 握手时协商的子协议，未协商时为空
+func (wsConn *WSConn) Subprotocol() string {
+	return wsConn.conn.Subprotocol()
 }
 
 // goroutine not safe
 // 从对应WSConn的conn中读取数据
 func (wsConn *WSConn) ReadMsg() ([]byte, error) {
 	msgType, b, err := wsConn.conn.ReadMessage()
-	fmt.Printf("ws read msg: msg type: %v, content: %v,err: %v\n", msgType, string(b), err)
+	if netLog.DebugEnabled() {
+		netLog.With(log.F("remote", wsConn.remoteAddr), log.F("msg_type", msgType), log.F("bytes", len(b)), log.F("err", err)).Debug("ws read msg")
+	}
 	return b, err
 }
 
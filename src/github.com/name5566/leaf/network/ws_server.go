@@ -11,12 +11,18 @@ import (
 	"github.com/name5566/leaf/log"
 	"github.com/name5566/leaf/util"
 	tk "github.com/name5566/leaf/db/redis/token"
+	"github.com/name5566/leaf/db/redis/ban"
 	"github.com/name5566/leaf/gate/user"
 )
 
 type WSServer struct {
 	Addr            string
 	MaxConnNum      int
+	MaxConnPerIP    int                  // 每个IP的最大连接数，0为不限制
+	IPFilter        func(ip string) bool // 返回false时拒绝该IP的连接，nil不检查
+	AllowedOrigins  []string             // 允许的Origin，支持通配符，为空时只允许同源，"*"被忽略
+	TrustedProxies  []string             // 可信代理的IP或CIDR，从这些地址来的X-Forwarded-For/X-Real-IP才会被采用
+	Subprotocols    []string             // 支持的子协议，按客户端Sec-WebSocket-Protocol中的顺序选择第一个支持的
 	PendingWriteNum int
 	MaxMsgLen       uint32
 	HTTPTimeout     time.Duration
@@ -29,11 +35,15 @@ type WSServer struct {
 
 type WSHandler struct {
 	maxConnNum      int
+	maxConnPerIP    int
+	ipFilter        func(ip string) bool
+	trustedProxies  TrustedProxies
 	pendingWriteNum int
 	maxMsgLen       uint32
 	newAgent        func(*WSConn) Agent
 	upgrader        websocket.Upgrader
 	conns           WebsocketConnSet
+	ipConns         ipConnCounter
 	mutexConns      sync.Mutex
 	wg              sync.WaitGroup
 }
@@ -46,11 +56,18 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", 405)
 		return
 	}
+	addr := handler.trustedProxies.RemoteAddr(r) // 真实客户端地址
+	ip := RemoteIP(addr)
+	if handler.ipFilter != nil && !handler.ipFilter(ip) {
+		log.Debug("connection from %v refused", ip)
+		http.Error(w, "Forbidden", 403)
+		return
+	}
 	responseHeader := http.Header{}
 	log.Debug("INIT HANDLER:  handler adr: %p, \n", handler)
 	var userData *user.UserData
 	cookies := r.Cookies()
-	fmt.Println("Cookies: ", cookies)
+	log.Debug("request cookies: %d", len(cookies))
 	if cookies != nil {
 		var err error
 		if userData, err = checkCookies(cookies); err != nil {
@@ -79,10 +96,21 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		log.Debug("too many connections")
 		return
 	}
+	if !handler.ipConns.add(ip, handler.maxConnPerIP) { // 单个IP连接数超过限制
+		handler.mutexConns.Unlock()
+		conn.Close()
+		log.Debug("too many connections from %v", ip)
+		return
+	}
 	handler.conns[conn] = struct{}{}
 	handler.mutexConns.Unlock()
+	connections.Inc("ws")
+	connects.Inc("ws")
 
-	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.maxMsgLen)
+	if addr == nil {
+		addr = conn.RemoteAddr()
+	}
+	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.maxMsgLen, addr)
 	agent := handler.newAgent(wsConn) // 调用gate的gate.Run中实现的NewAgent方法，创建当前连接的network.Agent
 	if userData != nil {
 		agent.OnInit(userData)
@@ -93,7 +121,10 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	wsConn.Close()
 	handler.mutexConns.Lock()
 	delete(handler.conns, conn)
+	handler.ipConns.del(ip)
 	handler.mutexConns.Unlock()
+	connections.Dec("ws")
+	disconnects.Inc("ws")
 	agent.OnClose()
 }
 
@@ -123,6 +154,13 @@ func (server *WSServer) Start() {
 	if server.NewAgent == nil {
 		log.Fatal("NewAgent must not be nil")
 	}
+	trustedProxies, err := NewTrustedProxies(server.TrustedProxies)
+	if err != nil {
+		log.Fatal("invalid TrustedProxies: %v", err)
+	}
+	if _, ignored := withoutWildcard(server.AllowedOrigins); ignored {
+		log.Error("websocket: origin \"*\" is ignored, connections are authenticated by cookie")
+	}
 
 	if server.CertFile != "" || server.KeyFile != "" {
 		config := &tls.Config{}
@@ -141,13 +179,18 @@ func (server *WSServer) Start() {
 	server.ln = ln
 	server.handler = &WSHandler{
 		maxConnNum:      server.MaxConnNum,
+		maxConnPerIP:    server.MaxConnPerIP,
+		ipFilter:        server.IPFilter,
+		trustedProxies:  trustedProxies,
 		pendingWriteNum: server.PendingWriteNum,
 		maxMsgLen:       server.MaxMsgLen,
 		newAgent:        server.NewAgent,
 		conns:           make(WebsocketConnSet),
+		ipConns:         make(ipConnCounter),
 		upgrader: websocket.Upgrader{
 			HandshakeTimeout: server.HTTPTimeout,
-			CheckOrigin:      func(_ *http.Request) bool { return true },
+			CheckOrigin:      CheckOrigin(server.AllowedOrigins),
+			Subprotocols:     server.Subprotocols,
 		},
 	}
 
@@ -186,26 +229,21 @@ func checkCookies(cookies [] *http.Cookie) (*user.UserData, error) {
 			if err != nil {
 				return nil, err
 			}
+			if b := ban.CheckUser(userID); b != nil {
+				return nil, fmt.Errorf("user[%d] is banned: %s", userID, b.Reason)
+			}
+			rights, err := tk.GetSessionRights(token)
+			if err != nil {
+				return nil, err
+			}
 			userData := user.UserData{
 				UserID:  userID,
 				Token:   token,
 				Expired: util.GetExpiredTime(int(maxAge)),
+				Rights:  rights,
 			}
 			return &userData, nil
 		}
 	}
 	return nil, nil
 }
-
-// 设置跨域资源共享(CORS)请求头
-func setCORS(r *http.Request, h *http.Header) {
-	reqOrigin := r.Header.Get("Origin")
-	log.Debug("req origin: %s", reqOrigin)
-	if reqOrigin != "" {
-		h.Set("Access-Control-Allow-Origin", reqOrigin)
-		//responseHeader.Set("Access-Control-Allow-Headers", "*")
-		//responseHeader.Set("Access-Control-Allow-Methods", "GET")
-		h.Set("Access-Control-Allow-Credentials", "true")
-	}
-
-}
@@ -9,14 +9,29 @@ import (
 	"time"
 )
 
-// Field name   | Mandatory? | Allowed values | Allowed special characters
-// ----------   | ---------- | -------------- | --------------------------
-// Seconds      | No         | 0-59           | * / , -
-// Minutes      | Yes        | 0-59           | * / , -
-// Hours        | Yes        | 0-23           | * / , -
-// Day of month | Yes        | 1-31           | * / , -
-// Month        | Yes        | 1-12           | * / , -
-// Day of week  | Yes        | 0-6            | * / , -
+// Field name   | Mandatory? | Allowed values  | Allowed special characters
+// ----------   | ---------- | --------------  | --------------------------
+// Seconds      | No         | 0-59            | * / , -
+// Minutes      | Yes        | 0-59            | * / , -
+// Hours        | Yes        | 0-23            | * / , -
+// Day of month | Yes        | 1-31            | * / , - ? L W
+// Month        | Yes        | 1-12 or JAN-DEC | * / , -
+// Day of week  | Yes        | 0-7 or SUN-SAT  | * / , - ? L #
+//
+// 星期中0和7都是周日，SAT-SUN表示周六到周日
+// L: 日为L表示当月最后一天，L-3表示倒数第4天；星期为5L表示当月最后一个周五
+// W: 15W表示离15号最近的工作日(不跨月)，LW表示当月最后一个工作日
+// #: 星期为5#3表示当月第三个周五
+//
+// 预定义表达式:
+// @yearly(@annually), @monthly, @weekly, @daily(@midnight), @hourly
+//
+// 时区:
+// 表达式前加CRON_TZ=或TZ=指定时区，如CRON_TZ=Asia/Shanghai 0 0 * * *
+// 未指定时区时按Next参数的时区计算
+//
+// 夏令时:
+// 拨快时被跳过的时间在跳过后的第一刻执行，拨慢时重复的时间只执行一次
 type CronExpr struct {
 	sec   uint64
 	min   uint64
@@ -24,11 +39,88 @@ type CronExpr struct {
 	dom   uint64
 	month uint64
 	dow   uint64
+
+	domSpecs []domSpec
+	dowSpecs []dowSpec
+	loc      *time.Location
+}
+
+// 日中的L和W
+type domSpec struct {
+	day     int  // 几号，last为true时不使用
+	last    bool // 从月末开始计算
+	offset  int  // last为true时距月末的天数
+	weekday bool // 取最近的工作日
+}
+
+// 星期中的L和#
+type dowSpec struct {
+	weekday int
+	nth     int // 当月第几个，-1为最后一个
+}
+
+// 字段取值范围和名字
+type cronBounds struct {
+	min    int
+	max    int
+	names  map[string]int
+	sunday bool // 星期字段，7为周日，范围结束的0视为7
+}
+
+var (
+	secBounds   = cronBounds{min: 0, max: 59}
+	minBounds   = cronBounds{min: 0, max: 59}
+	hourBounds  = cronBounds{min: 0, max: 23}
+	domBounds   = cronBounds{min: 1, max: 31}
+	monthBounds = cronBounds{min: 1, max: 12, names: map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}}
+	dowBounds = cronBounds{min: 0, max: 7, sunday: true, names: map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}}
+)
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
 }
 
 // goroutine safe
 func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
+	return NewCronExprIn(expr, nil)
+}
+
+// goroutine safe
+// 按loc时区计算，表达式中的CRON_TZ=优先，loc为nil时按Next参数的时区计算
+func NewCronExprIn(expr string, loc *time.Location) (cronExpr *CronExpr, err error) {
 	fields := strings.Fields(expr)
+	if len(fields) > 0 {
+		for _, prefix := range []string{"CRON_TZ=", "TZ="} {
+			if strings.HasPrefix(fields[0], prefix) {
+				loc, err = time.LoadLocation(strings.TrimPrefix(fields[0], prefix))
+				if err != nil {
+					err = fmt.Errorf("invalid expr %v: %v", expr, err)
+					return
+				}
+				fields = fields[1:]
+				break
+			}
+		}
+	}
+	if len(fields) == 1 && strings.HasPrefix(fields[0], "@") {
+		macro, ok := cronMacros[strings.ToLower(fields[0])]
+		if !ok {
+			err = fmt.Errorf("invalid expr %v: unknown macro %v", expr, fields[0])
+			return
+		}
+		fields = strings.Fields(macro)
+	}
 	if len(fields) != 5 && len(fields) != 6 {
 		err = fmt.Errorf("invalid expr %v: expected 5 or 6 fields, got %v", expr, len(fields))
 		return
@@ -39,33 +131,34 @@ func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
 	}
 
 	cronExpr = new(CronExpr)
+	cronExpr.loc = loc
 	// Seconds
-	cronExpr.sec, err = parseCronField(fields[0], 0, 59)
+	cronExpr.sec, err = parseCronField(fields[0], secBounds)
 	if err != nil {
 		goto onError
 	}
 	// Minutes
-	cronExpr.min, err = parseCronField(fields[1], 0, 59)
+	cronExpr.min, err = parseCronField(fields[1], minBounds)
 	if err != nil {
 		goto onError
 	}
 	// Hours
-	cronExpr.hour, err = parseCronField(fields[2], 0, 23)
+	cronExpr.hour, err = parseCronField(fields[2], hourBounds)
 	if err != nil {
 		goto onError
 	}
 	// Day of month
-	cronExpr.dom, err = parseCronField(fields[3], 1, 31)
+	cronExpr.dom, cronExpr.domSpecs, err = parseDomField(fields[3])
 	if err != nil {
 		goto onError
 	}
 	// Month
-	cronExpr.month, err = parseCronField(fields[4], 1, 12)
+	cronExpr.month, err = parseCronField(fields[4], monthBounds)
 	if err != nil {
 		goto onError
 	}
 	// Day of week
-	cronExpr.dow, err = parseCronField(fields[5], 0, 6)
+	cronExpr.dow, cronExpr.dowSpecs, err = parseDowField(fields[5])
 	if err != nil {
 		goto onError
 	}
@@ -76,13 +169,97 @@ onError:
 	return
 }
 
+// 表达式的时区，未指定时为nil
+func (e *CronExpr) Location() *time.Location {
+	return e.loc
+}
+
+// 数字或名字
+func (b cronBounds) value(s string) (int, error) {
+	if v, ok := b.names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// 日字段，L和W单独解析，其余按普通字段解析
+func parseDomField(field string) (cronField uint64, specs []domSpec, err error) {
+	var rest []string
+	for _, item := range strings.Split(field, ",") {
+		upper := strings.ToUpper(item)
+		switch {
+		case upper == "L":
+			specs = append(specs, domSpec{last: true})
+		case upper == "LW":
+			specs = append(specs, domSpec{last: true, weekday: true})
+		case strings.HasPrefix(upper, "L-"):
+			offset, e := strconv.Atoi(upper[2:])
+			if e != nil || offset < 0 || offset > 30 {
+				err = fmt.Errorf("invalid last day offset: %v", item)
+				return
+			}
+			specs = append(specs, domSpec{last: true, offset: offset})
+		case len(upper) > 1 && strings.HasSuffix(upper, "W"):
+			day, e := strconv.Atoi(upper[:len(upper)-1])
+			if e != nil || day < domBounds.min || day > domBounds.max {
+				err = fmt.Errorf("invalid weekday: %v", item)
+				return
+			}
+			specs = append(specs, domSpec{day: day, weekday: true})
+		default:
+			rest = append(rest, item)
+		}
+	}
+	if len(rest) > 0 {
+		cronField, err = parseCronField(strings.Join(rest, ","), domBounds)
+	}
+	return
+}
+
+// 星期字段，L和#单独解析，其余按普通字段解析
+func parseDowField(field string) (cronField uint64, specs []dowSpec, err error) {
+	var rest []string
+	for _, item := range strings.Split(field, ",") {
+		var weekday, nth int
+		if i := strings.Index(item, "#"); i >= 0 {
+			nth, err = strconv.Atoi(item[i+1:])
+			if err != nil || nth < 1 || nth > 5 {
+				err = fmt.Errorf("invalid nth weekday: %v", item)
+				return
+			}
+			weekday, err = dowBounds.value(item[:i])
+		} else if len(item) > 1 && strings.ToUpper(item[len(item)-1:]) == "L" {
+			nth = -1
+			weekday, err = dowBounds.value(item[:len(item)-1])
+		} else {
+			rest = append(rest, item)
+			continue
+		}
+		if err != nil || weekday < dowBounds.min || weekday > dowBounds.max {
+			err = fmt.Errorf("invalid weekday: %v", item)
+			return
+		}
+		specs = append(specs, dowSpec{weekday: weekday % 7, nth: nth})
+	}
+	if len(rest) > 0 {
+		cronField, err = parseCronField(strings.Join(rest, ","), dowBounds)
+		// 7和0都是周日
+		if cronField&(1<<7) != 0 {
+			cronField = cronField&^(1<<7) | 1
+		}
+	}
+	return
+}
+
 // 1. *
 // 2. num
 // 3. num-num
 // 4. */num
 // 5. num/num (means num-max/num)
 // 6. num-num/num
-func parseCronField(field string, min int, max int) (cronField uint64, err error) {
+// num可以是名字，如JAN、MON，?同*
+func parseCronField(field string, b cronBounds) (cronField uint64, err error) {
+	min, max := b.min, b.max
 	fields := strings.Split(field, ",")
 	for _, field := range fields {
 		rangeAndIncr := strings.Split(field, "/")
@@ -99,7 +276,7 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 		}
 
 		var start, end int
-		if startAndEnd[0] == "*" {
+		if startAndEnd[0] == "*" || startAndEnd[0] == "?" {
 			if len(startAndEnd) != 1 {
 				err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
 				return
@@ -108,7 +285,7 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 			end = max
 		} else {
 			// start
-			start, err = strconv.Atoi(startAndEnd[0])
+			start, err = b.value(startAndEnd[0])
 			if err != nil {
 				err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
 				return
@@ -121,11 +298,15 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 					end = start
 				}
 			} else {
-				end, err = strconv.Atoi(startAndEnd[1])
+				end, err = b.value(startAndEnd[1])
 				if err != nil {
 					err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
 					return
 				}
+				// SAT-SUN
+				if b.sunday && end == 0 && start > end {
+					end = 7
+				}
 			}
 		}
 
@@ -171,23 +352,143 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 	return
 }
 
+// 当月天数
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// 离day最近的工作日，不跨月
+func nearestWeekday(year int, month time.Month, day int, last int) int {
+	switch time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Weekday() {
+	case time.Saturday:
+		if day == 1 {
+			return day + 2
+		}
+		return day - 1
+	case time.Sunday:
+		if day == last {
+			return day - 2
+		}
+		return day + 1
+	}
+	return day
+}
+
+func (s domSpec) match(t time.Time) bool {
+	last := daysIn(t.Year(), t.Month())
+	day := s.day
+	if s.last {
+		day = last - s.offset
+	}
+	if day < 1 || day > last {
+		return false
+	}
+	if s.weekday {
+		day = nearestWeekday(t.Year(), t.Month(), day, last)
+	}
+	return t.Day() == day
+}
+
+func (s dowSpec) match(t time.Time) bool {
+	if int(t.Weekday()) != s.weekday {
+		return false
+	}
+	if s.nth < 0 {
+		return t.Day()+7 > daysIn(t.Year(), t.Month())
+	}
+	return (t.Day()-1)/7+1 == s.nth
+}
+
+func (e *CronExpr) matchDom(t time.Time) bool {
+	if 1<<uint(t.Day())&e.dom != 0 {
+		return true
+	}
+	for _, s := range e.domSpecs {
+		if s.match(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *CronExpr) matchDow(t time.Time) bool {
+	if 1<<uint(t.Weekday())&e.dow != 0 {
+		return true
+	}
+	for _, s := range e.dowSpecs {
+		if s.match(t) {
+			return true
+		}
+	}
+	return false
+}
+
 func (e *CronExpr) matchDay(t time.Time) bool {
 	// day-of-month blank
 	if e.dom == 0xfffffffe {
-		return 1<<uint(t.Weekday())&e.dow != 0
+		return e.matchDow(t)
 	}
 
 	// day-of-week blank
 	if e.dow == 0x7f {
-		return 1<<uint(t.Day())&e.dom != 0
+		return e.matchDom(t)
 	}
 
-	return 1<<uint(t.Weekday())&e.dow != 0 ||
-		1<<uint(t.Day())&e.dom != 0
+	return e.matchDow(t) || e.matchDom(t)
+}
+
+// 当地时间的年月日时分秒
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// 当地时间转换为loc时区的时间
+// 拨快时被跳过的当地时间转换为跳过后的第一刻，拨慢时重复的当地时间取第一次
+func fromWallClock(wall time.Time, loc *time.Location) time.Time {
+	t := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), loc)
+	start, end := t.ZoneBounds()
+	if w := wallClock(t); !w.Equal(wall) {
+		if w.After(wall) {
+			return start
+		}
+		return end
+	}
+	// 与上一个时区的时间重叠时取较早的
+	if !start.IsZero() {
+		_, offset := t.Zone()
+		_, prevOffset := start.Add(-time.Second).Zone()
+		if earlier := t.Add(-time.Duration(prevOffset-offset) * time.Second); earlier.Before(start) &&
+			wallClock(earlier.In(loc)).Equal(wall) {
+			return earlier.In(loc)
+		}
+	}
+	return t
 }
 
 // goroutine safe
+// 返回t之后的下一个时间，时区为表达式的时区，未指定时为t的时区
 func (e *CronExpr) Next(t time.Time) time.Time {
+	loc := e.loc
+	if loc == nil {
+		loc = t.Location()
+	}
+	t = t.In(loc)
+
+	// 按当地时间计算，每个当地时间最多执行一次
+	wall := wallClock(t)
+	for {
+		wall = e.next(wall)
+		if wall.IsZero() {
+			return time.Time{}
+		}
+		if next := fromWallClock(wall, loc); next.After(t) {
+			return next
+		}
+	}
+}
+
+// 按UTC计算t之后的下一个当地时间
+func (e *CronExpr) next(t time.Time) time.Time {
 	// the upcoming second
 	t = t.Truncate(time.Second).Add(time.Second)
 
@@ -43,6 +43,94 @@ func ExampleCronExpr() {
 	// 2000-01-01 21:00:00 +0000 UTC
 }
 
+func ExampleCronExpr_special() {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, expr := range []string{
+		"0 0 L * *",             // 当月最后一天
+		"0 0 LW * *",            // 当月最后一个工作日
+		"0 0 1W * *",            // 离1号最近的工作日
+		"0 0 * * FRI#3",         // 当月第三个周五
+		"0 0 * * 5L",            // 当月最后一个周五
+		"0 9 * JUN-AUG MON-FRI", // 6到8月的工作日
+		"@weekly",
+	} {
+		cronExpr, err := timer.NewCronExpr(expr)
+		if err != nil {
+			return
+		}
+		// 2024-06-01是周六
+		t := cronExpr.Next(from.AddDate(0, 5, 0))
+		fmt.Println(t.Format("2006-01-02 15:04 Mon"))
+	}
+
+	// Output:
+	// 2024-06-30 00:00 Sun
+	// 2024-06-28 00:00 Fri
+	// 2024-06-03 00:00 Mon
+	// 2024-06-21 00:00 Fri
+	// 2024-06-28 00:00 Fri
+	// 2024-06-03 09:00 Mon
+	// 2024-06-02 00:00 Sun
+}
+
+func ExampleNewCronExprIn() {
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		return
+	}
+	// 按玩家所在地区的零点重置，UTC 20:10是北京时间次日04:10
+	cronExpr, err := timer.NewCronExprIn("@daily", loc)
+	if err != nil {
+		return
+	}
+
+	fmt.Println(cronExpr.Next(time.Date(
+		2000, 1, 1,
+		20, 10, 5,
+		0, time.UTC,
+	)))
+
+	// Output:
+	// 2000-01-03 00:00:00 +0800 CST
+}
+
+func ExampleCronExpr_dst() {
+	next := func(expr string, t time.Time, n int) {
+		cronExpr, err := timer.NewCronExpr(expr)
+		if err != nil {
+			return
+		}
+		for i := 0; i < n; i++ {
+			t = cronExpr.Next(t)
+			fmt.Println(t)
+		}
+	}
+
+	// 2018-03-11 02:00拨快到03:00，跳过的02:30在03:00执行
+	next("CRON_TZ=America/New_York 30 2 * * *", time.Date(2018, 3, 10, 12, 0, 0, 0, time.UTC), 2)
+	// 2018-11-04 02:00拨慢到01:00，重复的01:00-02:00只执行一次
+	next("CRON_TZ=America/New_York */20 1 * * *", time.Date(2018, 11, 4, 0, 0, 0, 0, time.UTC), 4)
+	// 从重复的第二个01:30开始，不再执行当天的01:40
+	next("CRON_TZ=America/New_York */20 1 * * *", time.Date(2018, 11, 4, 6, 30, 0, 0, time.UTC), 1)
+	// 2018-11-04 00:00拨快到01:00，当天的零点重置在01:00执行
+	next("CRON_TZ=America/Sao_Paulo @daily", time.Date(2018, 11, 3, 12, 0, 0, 0, time.UTC), 2)
+	// 2019-02-17 00:00拨慢到2019-02-16 23:00，零点不重复
+	next("CRON_TZ=America/Sao_Paulo 0 0 * * *", time.Date(2019, 2, 16, 12, 0, 0, 0, time.UTC), 2)
+
+	// Output:
+	// 2018-03-11 03:00:00 -0400 EDT
+	// 2018-03-12 02:30:00 -0400 EDT
+	// 2018-11-04 01:00:00 -0400 EDT
+	// 2018-11-04 01:20:00 -0400 EDT
+	// 2018-11-04 01:40:00 -0400 EDT
+	// 2018-11-05 01:00:00 -0500 EST
+	// 2018-11-05 01:00:00 -0500 EST
+	// 2018-11-04 01:00:00 -0200 -02
+	// 2018-11-05 00:00:00 -0200 -02
+	// 2019-02-17 00:00:00 -0300 -03
+	// 2019-02-18 00:00:00 -0300 -03
+}
+
 func ExampleCron() {
 	d := timer.NewDispatcher(10)
 
@@ -65,3 +153,82 @@ func ExampleCron() {
 	// Output:
 	// My name is Leaf
 }
+
+func ExampleNewWheelDispatcher() {
+	d := timer.NewWheelDispatcher(10, time.Millisecond)
+	defer d.Close()
+
+	d.AfterFunc(5*time.Millisecond, func() {
+		fmt.Println("timer 1")
+	})
+	d.AfterFunc(5*time.Millisecond, func() {
+		fmt.Println("timer 2")
+	})
+	t := d.AfterFunc(5*time.Millisecond, func() {
+		fmt.Println("will not print")
+	})
+	t.Stop()
+
+	// 同一个tick到期的定时器一起发送，两个定时器可能跨过tick边界，读到都执行为止
+	for n := 0; n < 2; {
+		for _, t := range <-d.ChanTimers {
+			t.Cb()
+			n++
+		}
+	}
+
+	// Output:
+	// timer 1
+	// timer 2
+}
+
+func ExampleNewWheelDispatcher_cascade() {
+	d := timer.NewWheelDispatcher(10, time.Millisecond)
+	defer d.Close()
+
+	// 超过第0层的256个tick，需要从上层格子降级
+	start := time.Now()
+	d.AfterFunc(300*time.Millisecond, func() {
+		fmt.Println("timer 300ms", time.Since(start) >= 300*time.Millisecond)
+	})
+	d.AfterFunc(100*time.Millisecond, func() {
+		fmt.Println("timer 100ms", time.Since(start) >= 100*time.Millisecond)
+	})
+
+	for n := 0; n < 2; {
+		for _, t := range <-d.ChanTimers {
+			t.Cb()
+			n++
+		}
+	}
+
+	// Output:
+	// timer 100ms true
+	// timer 300ms true
+}
+
+func ExampleNewWheelDispatcher_stalled() {
+	d := timer.NewWheelDispatcher(1, time.Millisecond)
+	defer d.Close()
+
+	// 不处理到期的定时器，时间轮阻塞在发送上
+	d.AfterFunc(time.Millisecond, func() {})
+	d.AfterFunc(2*time.Millisecond, func() {})
+	time.Sleep(50 * time.Millisecond)
+
+	// 阻塞期间添加的定时器仍按实际时间到期
+	start := time.Now()
+	done := false
+	d.AfterFunc(20*time.Millisecond, func() {
+		fmt.Println(time.Since(start) >= 20*time.Millisecond)
+		done = true
+	})
+	for !done {
+		for _, t := range <-d.ChanTimers {
+			t.Cb()
+		}
+	}
+
+	// Output:
+	// true
+}
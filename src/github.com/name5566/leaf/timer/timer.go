@@ -4,12 +4,15 @@ import (
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/log"
 	"runtime"
+	"sync/atomic"
 	"time"
 )
 
 // one dispatcher per goroutine (goroutine not safe)
 type Dispatcher struct {
-	ChanTimer chan *Timer
+	ChanTimer  chan *Timer   // 每个定时器单独发送
+	ChanTimers chan []*Timer // 时间轮同一个tick到期的定时器一起发送，普通Dispatcher为nil
+	wheel      *wheel
 }
 
 func NewDispatcher(l int) *Dispatcher {
@@ -18,14 +21,40 @@ func NewDispatcher(l int) *Dispatcher {
 	return disp
 }
 
+// 使用时间轮的Dispatcher，精度为tick，适合大量定时器
+// 到期的定时器按tick批量发送到ChanTimers
+func NewWheelDispatcher(l int, tick time.Duration) *Dispatcher {
+	if tick <= 0 {
+		panic("invalid tick")
+	}
+	disp := NewDispatcher(l)
+	disp.ChanTimers = make(chan []*Timer, l)
+	disp.wheel = newWheel(tick, disp.ChanTimers)
+	return disp
+}
+
+// 停止时间轮，普通Dispatcher不需要关闭
+func (disp *Dispatcher) Close() {
+	if disp.wheel != nil {
+		disp.wheel.close()
+	}
+}
+
 // Timer
 type Timer struct {
 	t  *time.Timer
 	cb func()
+
+	// 时间轮
+	expire  uint64 // 到期的tick
+	stopped int32  // 原子读写，停止后不再发送
 }
 
 func (t *Timer) Stop() {
-	t.t.Stop()
+	if t.t != nil {
+		t.t.Stop()
+	}
+	atomic.StoreInt32(&t.stopped, 1)
 	t.cb = nil
 }
 
@@ -51,6 +80,10 @@ func (t *Timer) Cb() {
 func (disp *Dispatcher) AfterFunc(d time.Duration, cb func()) *Timer {
 	t := new(Timer)
 	t.cb = cb
+	if disp.wheel != nil {
+		disp.wheel.add(t, d)
+		return t
+	}
 	t.t = time.AfterFunc(d, func() {
 		disp.ChanTimer <- t
 	})
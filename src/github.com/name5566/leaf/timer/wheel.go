@@ -0,0 +1,150 @@
+package timer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 分层时间轮，第0层256格，其余4层各64格，共可表示2^32个tick
+const (
+	wheelBits0  = 8
+	wheelBitsN  = 6
+	wheelSize0  = 1 << wheelBits0
+	wheelSizeN  = 1 << wheelBitsN
+	wheelLevels = 5
+	maxTicks    = 1<<(wheelBits0+(wheelLevels-1)*wheelBitsN) - 1
+)
+
+// 时间轮，所有定时器共用一个goroutine和一个time.Ticker
+// 同一个tick到期的定时器一起发送到ChanTimers
+type wheel struct {
+	tick     time.Duration
+	start    time.Time
+	chanOut  chan []*Timer
+	closeSig chan struct{}
+
+	mutex   sync.Mutex
+	current uint64 // 已处理的tick数
+	slots0  [wheelSize0][]*Timer
+	slotsN  [wheelLevels - 1][wheelSizeN][]*Timer
+}
+
+func newWheel(tick time.Duration, chanOut chan []*Timer) *wheel {
+	w := &wheel{
+		tick:     tick,
+		start:    time.Now(),
+		chanOut:  chanOut,
+		closeSig: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// goroutine safe
+// 添加定时器，到期时间按实际经过的时间计算，不足一个tick时在下一个tick到期
+// 不能从w.current开始计算，发送阻塞或ticker落后时w.current会停止增长
+func (w *wheel) add(t *Timer, d time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if d < 0 {
+		d = 0
+	}
+	expire := uint64((time.Since(w.start) + d + w.tick - 1) / w.tick)
+	if expire < w.current {
+		expire = w.current
+	}
+	if expire-w.current > maxTicks {
+		expire = w.current + maxTicks
+	}
+	t.expire = expire
+	w.place(t)
+}
+
+// 按到期时间放入对应层的格子，调用时需持有w.mutex
+func (w *wheel) place(t *Timer) {
+	delta := t.expire - w.current
+	if t.expire < w.current {
+		delta = 0
+		t.expire = w.current
+	}
+	if delta < wheelSize0 {
+		i := t.expire & (wheelSize0 - 1)
+		w.slots0[i] = append(w.slots0[i], t)
+		return
+	}
+	for level := 0; level < wheelLevels-1; level++ {
+		shift := uint(wheelBits0 + level*wheelBitsN)
+		if delta < 1<<(shift+wheelBitsN) || level == wheelLevels-2 {
+			i := (t.expire >> shift) & (wheelSizeN - 1)
+			w.slotsN[level][i] = append(w.slotsN[level][i], t)
+			return
+		}
+	}
+}
+
+// 把上层格子中的定时器重新放入下层，返回格子序号
+func (w *wheel) cascade(level int) uint64 {
+	shift := uint(wheelBits0 + level*wheelBitsN)
+	i := (w.current >> shift) & (wheelSizeN - 1)
+	ts := w.slotsN[level][i]
+	w.slotsN[level][i] = nil
+	for _, t := range ts {
+		if atomic.LoadInt32(&t.stopped) == 0 {
+			w.place(t)
+		}
+	}
+	return i
+}
+
+// 处理一个tick，返回到期的定时器，调用时需持有w.mutex
+func (w *wheel) advance(expired []*Timer) []*Timer {
+	i := w.current & (wheelSize0 - 1)
+	if i == 0 {
+		for level := 0; level < wheelLevels-1 && w.cascade(level) == 0; level++ {
+		}
+	}
+	for _, t := range w.slots0[i] {
+		if atomic.LoadInt32(&t.stopped) == 0 {
+			expired = append(expired, t)
+		}
+	}
+	w.slots0[i] = nil
+	w.current++
+	return expired
+}
+
+func (w *wheel) run() {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.closeSig:
+			return
+		case now := <-ticker.C:
+			// 按实际经过的时间处理，ticker丢失的tick在这里补上
+			due := uint64(now.Sub(w.start) / w.tick)
+			var expired []*Timer
+			w.mutex.Lock()
+			for w.current <= due {
+				expired = w.advance(expired)
+			}
+			w.mutex.Unlock()
+
+			if len(expired) == 0 {
+				continue
+			}
+			select {
+			case w.chanOut <- expired:
+			case <-w.closeSig:
+				return
+			}
+		}
+	}
+}
+
+func (w *wheel) close() {
+	close(w.closeSig)
+}
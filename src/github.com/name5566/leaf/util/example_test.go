@@ -90,3 +90,90 @@ func ExampleDeepClone() {
 	// 2
 	// 3
 }
+
+func ExampleTokenBucket() {
+	b := util.NewTokenBucket(1, 2)
+
+	fmt.Println(b.Allow())
+	fmt.Println(b.Allow())
+	fmt.Println(b.Allow())
+
+	// Output:
+	// true
+	// true
+	// false
+}
+
+func ExampleValidate() {
+	type Right struct {
+		Server string `validate:"required"`
+		Name   string `validate:"required"`
+	}
+	type Login struct {
+		Email    string `validate:"email"`
+		Password string `json:"password" validate:"required,min=6,max=20"`
+		Age      int    `validate:"max=150"`
+		Right    Right
+	}
+
+	errs := util.Validate(&Login{
+		Email: "name@example",
+		Age:   200,
+		Right: Right{Server: "login"},
+	})
+	for _, e := range errs {
+		fmt.Println(e.Field, e.Message)
+	}
+
+	// Output:
+	// Email must be a valid email
+	// password is required
+	// Age must be at most 150
+	// Right.Name is required
+}
+
+type Account struct {
+	LoginName string
+	Email     string `validate:"email"`
+}
+
+// 至少填写一个
+func (a *Account) Validate() util.ValidationErrors {
+	if a.LoginName == "" && a.Email == "" {
+		return util.ValidationErrors{{Field: "LoginName", Message: "one of LoginName, Email is required"}}
+	}
+	return nil
+}
+
+func ExampleValidator() {
+	type Login struct {
+		Account  Account
+		Password string `validate:"required"`
+	}
+
+	fmt.Println(util.Validate(&Account{}))
+	fmt.Println(util.Validate(&Login{Account: Account{Email: "name@example"}}))
+	fmt.Println(len(util.Validate(&Login{Account: Account{Email: "name@example.com"}, Password: "123456"})))
+
+	// Output:
+	// LoginName: one of LoginName, Email is required
+	// Account.Email: must be a valid email; Password: is required
+	// 0
+}
+
+func ExampleCheckRules() {
+	type Right struct {
+		Name string `validate:"max=a"`
+	}
+	type Login struct {
+		Password string `validate:"required,min=6"`
+		Right    *Right
+	}
+
+	fmt.Println(util.CheckRules(&Account{}))
+	fmt.Println(util.CheckRules(&Login{}))
+
+	// Output:
+	// <nil>
+	// util_test.Right.Name: invalid max: a
+}
@@ -0,0 +1,36 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// 解析IP或CIDR，单个IP视为/32(/128)
+func ParseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip: %s", s)
+		}
+		if ip.To4() != nil {
+			s += "/32"
+		} else {
+			s += "/128"
+		}
+	}
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	return ipNet, nil
+}
+
+// 是否本机地址，host为主机名或IP
+func IsLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
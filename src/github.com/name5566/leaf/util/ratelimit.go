@@ -0,0 +1,150 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// 令牌桶
+// rate: 每秒生成的令牌数，小于等于0时不限制, burst: 桶容量
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst <= 0 {
+		burst = 1
+	}
+	b := new(TokenBucket)
+	b.rate = rate
+	b.burst = float64(burst)
+	b.tokens = b.burst
+	b.last = time.Now()
+	return b
+}
+
+// goroutine not safe
+// 取一个令牌，没有可用令牌时返回false
+func (b *TokenBucket) Allow() bool {
+	if b.Reserve() == 0 {
+		return true
+	}
+	b.Cancel()
+	return false
+}
+
+// goroutine not safe
+// 预约一个令牌，返回可用前需要等待的时间
+// 返回0表示立即可用
+func (b *TokenBucket) Reserve() time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// goroutine not safe
+// 归还一个令牌，用于放弃Reserve的预约
+func (b *TokenBucket) Cancel() {
+	if b.rate <= 0 {
+		return
+	}
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// 桶是否已满（长时间空闲）
+func (b *TokenBucket) full(now time.Time) bool {
+	if b.rate <= 0 {
+		return true
+	}
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// 按key分组的令牌桶
+// goroutine safe
+type RateLimiter struct {
+	sync.Mutex
+	rate    float64
+	burst   int
+	buckets map[interface{}]*TokenBucket
+	sweepAt time.Time
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	l := new(RateLimiter)
+	l.rate = rate
+	l.burst = burst
+	l.buckets = make(map[interface{}]*TokenBucket)
+	l.sweepAt = time.Now()
+	return l
+}
+
+func (l *RateLimiter) Allow(key interface{}) bool {
+	l.Lock()
+	defer l.Unlock()
+	return l.bucket(key).Allow()
+}
+
+// 预约key对应桶中的一个令牌，返回值同TokenBucket.Reserve
+func (l *RateLimiter) Reserve(key interface{}) time.Duration {
+	l.Lock()
+	defer l.Unlock()
+	return l.bucket(key).Reserve()
+}
+
+// 归还key对应桶中的一个令牌，用于放弃Reserve的预约
+func (l *RateLimiter) Cancel(key interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	if b, ok := l.buckets[key]; ok {
+		b.Cancel()
+	}
+}
+
+func (l *RateLimiter) bucket(key interface{}) *TokenBucket {
+	l.sweep()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = NewTokenBucket(l.rate, l.burst)
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// 删除key对应的桶
+func (l *RateLimiter) Del(key interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.buckets, key)
+}
+
+// 定期清理已经装满的桶，装满的桶和新建的桶没有区别
+func (l *RateLimiter) sweep() {
+	now := time.Now()
+	if now.Sub(l.sweepAt) < time.Minute {
+		return
+	}
+	l.sweepAt = now
+	for key, b := range l.buckets {
+		if b.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 字段校验错误
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationErrors []FieldError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Field + ": " + e.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// 按结构体字段的validate tag校验，全部合法时返回nil
+// 规则以逗号分隔:
+//   required       必填，不能为零值
+//   min=n, max=n   字符串为字符数，数字为取值范围，切片和map为长度
+//   email          邮箱格式，空字符串不检查
+//   datetime=布局   时间格式，例如datetime=2006-01-02 15:04:05，空字符串不检查
+// 嵌套的结构体(或指针)不为零值或标记required时递归校验，字段名以.连接
+// 字段名优先使用json tag
+// 结构体实现Validator时再调用其Validate，用于"至少填写一个"等规则
+func Validate(v interface{}) ValidationErrors {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	var errs ValidationErrors
+	validateStruct(rv, "", &errs)
+	return errs
+}
+
+type fieldRule struct {
+	index    int
+	name     string
+	required bool
+	min, max *float64
+	email    bool
+	datetime string
+}
+
+// 结构体类型对应的校验规则缓存
+var validateRules sync.Map
+
+// 结构体实现该接口时，在字段规则之后调用，用于校验字段之间的关系
+// 返回的字段名不需要包含嵌套结构体的前缀
+type Validator interface {
+	Validate() ValidationErrors
+}
+
+// 检查v的类型及嵌套结构体中的validate tag是否合法
+// 在注册消息时调用，tag错误在启动时发现而不是第一次校验时panic
+func CheckRules(v interface{}) error {
+	return checkRules(reflect.TypeOf(v), make(map[reflect.Type]bool))
+}
+
+func checkRules(t reflect.Type, checked map[reflect.Type]bool) error {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) || checked[t] {
+		return nil
+	}
+	checked[t] = true
+	rules, err := parseRules(t)
+	if err != nil {
+		return err
+	}
+	validateRules.Store(t, rules)
+	for _, r := range rules {
+		if err := checkRules(t.Field(r.index).Type, checked); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func structRules(t reflect.Type) []fieldRule {
+	if rules, ok := validateRules.Load(t); ok {
+		return rules.([]fieldRule)
+	}
+	rules, err := parseRules(t)
+	if err != nil { // 没有通过CheckRules检查的类型
+		panic(err)
+	}
+	validateRules.Store(t, rules)
+	return rules
+}
+
+func parseRules(t reflect.Type) ([]fieldRule, error) {
+	var rules []fieldRule
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" { // 未导出字段
+			continue
+		}
+		r := fieldRule{index: i, name: f.Name}
+		if name := strings.Split(f.Tag.Get("json"), ",")[0]; name == "-" {
+			continue
+		} else if name != "" {
+			r.name = name
+		}
+		for _, rule := range strings.Split(f.Tag.Get("validate"), ",") {
+			kv := strings.SplitN(strings.TrimSpace(rule), "=", 2)
+			switch kv[0] {
+			case "":
+			case "required":
+				r.required = true
+			case "email":
+				r.email = true
+			case "min", "max":
+				if len(kv) != 2 {
+					return nil, fmt.Errorf("%v.%v: %v requires a value", t, f.Name, kv[0])
+				}
+				n, err := strconv.ParseFloat(kv[1], 64)
+				if err != nil {
+					return nil, fmt.Errorf("%v.%v: invalid %v: %v", t, f.Name, kv[0], kv[1])
+				}
+				if kv[0] == "min" {
+					r.min = &n
+				} else {
+					r.max = &n
+				}
+			case "datetime":
+				if len(kv) != 2 {
+					return nil, fmt.Errorf("%v.%v: datetime requires a layout", t, f.Name)
+				}
+				r.datetime = kv[1]
+			default:
+				return nil, fmt.Errorf("%v.%v: unknown validate rule %v", t, f.Name, kv[0])
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func validateStruct(rv reflect.Value, prefix string, errs *ValidationErrors) {
+	for _, r := range structRules(rv.Type()) {
+		fv := rv.Field(r.index)
+		name := prefix + r.name
+		addErr := func(format string, args ...interface{}) {
+			*errs = append(*errs, FieldError{Field: name, Message: fmt.Sprintf(format, args...)})
+		}
+
+		zero := isZero(fv)
+		if zero {
+			if r.required {
+				addErr("is required")
+			}
+			continue
+		}
+
+		for fv.Kind() == reflect.Ptr {
+			fv = fv.Elem()
+		}
+		switch fv.Kind() {
+		case reflect.String:
+			s := fv.String()
+			n := float64(utf8.RuneCountInString(s))
+			if r.min != nil && n < *r.min {
+				addErr("must be at least %v characters", *r.min)
+			}
+			if r.max != nil && n > *r.max {
+				addErr("must be at most %v characters", *r.max)
+			}
+			if r.email && CheckEmailFormat(s) != nil {
+				addErr("must be a valid email")
+			}
+			if r.datetime != "" {
+				if _, err := time.Parse(r.datetime, s); err != nil {
+					addErr("must be in format %v", r.datetime)
+				}
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			n := toFloat(fv)
+			if r.min != nil && n < *r.min {
+				addErr("must be at least %v", *r.min)
+			}
+			if r.max != nil && n > *r.max {
+				addErr("must be at most %v", *r.max)
+			}
+		case reflect.Slice, reflect.Map, reflect.Array:
+			n := float64(fv.Len())
+			if r.min != nil && n < *r.min {
+				addErr("must contain at least %v items", *r.min)
+			}
+			if r.max != nil && n > *r.max {
+				addErr("must contain at most %v items", *r.max)
+			}
+		case reflect.Struct:
+			if _, ok := fv.Interface().(time.Time); !ok {
+				validateStruct(fv, name+".", errs)
+			}
+		}
+	}
+
+	var v interface{} = rv.Interface()
+	if rv.CanAddr() {
+		v = rv.Addr().Interface()
+	}
+	if v, ok := v.(Validator); ok {
+		for _, e := range v.Validate() {
+			e.Field = prefix + e.Field
+			*errs = append(*errs, e)
+		}
+	}
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	default:
+		return v.Float()
+	}
+}
@@ -0,0 +1,32 @@
+package base
+
+import (
+	"github.com/name5566/leaf/job"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/module"
+	"server/conf"
+)
+
+// name为租约名，同名的调度器在集群中只有一个节点执行任务
+// 处理函数在skeleton所在模块的goroutine中执行
+// Store需要在OnInit中用NewJobStore设置，server.json在包初始化之后才读取
+func NewScheduler(name string, skeleton *module.Skeleton) *job.Scheduler {
+	return &job.Scheduler{
+		Name:         name,
+		PollInterval: conf.JobPollInterval,
+		LeaseTTL:     conf.JobLeaseTTL,
+		Runner:       skeleton,
+	}
+}
+
+// 按配置创建定时任务的存储
+func NewJobStore(name string) job.Store {
+	switch conf.Server.JobStore {
+	case "postgre":
+		return job.NewPostgreStore()
+	case "redis", "":
+		return job.NewRedisStore(name)
+	}
+	log.Fatal("invalid JobStore %v", conf.Server.JobStore)
+	return nil
+}
@@ -6,12 +6,16 @@ import (
 	"server/conf"
 )
 
-func NewSkeleton() *module.Skeleton {
+// name为模块名，用于统计ChanRPC队列长度和执行耗时
+func NewSkeleton(name string) *module.Skeleton {
+	chanRPCServer := chanrpc.NewServer(conf.ChanRPCLen)
+	chanRPCServer.Name = name
 	skeleton := &module.Skeleton{
 		GoLen:              conf.GoLen,
 		TimerDispatcherLen: conf.TimerDispatcherLen,
+		TimerTick:          conf.TimerTick,
 		AsynCallLen:        conf.AsynCallLen,
-		ChanRPCServer:      chanrpc.NewServer(conf.ChanRPCLen),
+		ChanRPCServer:      chanRPCServer,
 	}
 	skeleton.Init()
 	return skeleton
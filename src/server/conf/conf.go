@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	golog "log"
 
+	"github.com/name5566/leaf/gate"
 	"github.com/name5566/leaf/log"
 	"path/filepath"
 )
@@ -24,24 +25,52 @@ var (
 	// skeleton conf
 	GoLen              = 10000
 	TimerDispatcherLen = 10000
+	TimerTick          = 10 * time.Millisecond // 定时器时间轮的精度，0为每个定时器使用time.AfterFunc
 	AsynCallLen        = 10000
 	ChanRPCLen         = 10000
+	ChanRPCTimeout     = 5 * time.Second        // 同步chanrpc调用的超时时间
+	ChanRPCSlowCall    = 100 * time.Millisecond // 执行超过该时长的chanrpc调用记录日志
+
+	// job conf
+	JobPollInterval = time.Second      // 检查到期定时任务的间隔
+	JobLeaseTTL     = 10 * time.Second // 执行定时任务的租约时长，节点崩溃后其他节点最多等待该时长接管
 )
 
 // 配置文件server初始化结构体
 var Server struct {
-	LogLevel     string
-	LogPath      string
-	WSAddr       string
-	CertFile     string
-	KeyFile      string
-	TCPAddr      string
-	MaxConnNum   int
-	ConsolePort  int
-	ProfilePath  string
-	HTTPAddr     string
-	HTTPCertFile string
-	HTTPKeyFile  string
+	LogLevel        string
+	LogPath         string
+	LogEncoder      string            // 日志格式，console或json
+	LogModuleLevels map[string]string // 模块单独的日志级别，模块名为gate，network等
+	LogMaxSize      int               // 单个日志文件的最大MB数，0为不按大小切割
+	LogDaily        bool              // 是否按天切割日志文件
+	LogMaxBackups   int               // 保留的旧日志文件数，0为不限制
+	LogMaxAge       int               // 旧日志文件的保留天数，0为不限制
+	LogCompress     bool              // 是否gzip压缩旧日志文件
+	WSAddr          string
+	CertFile        string
+	KeyFile         string
+	TCPAddr         string
+	TCPCodec        string // tcp使用的消息格式，json或msgpack，默认json
+	MaxConnNum      int
+	MaxConnPerIP    int
+	RateLimit       gate.LimitConf
+	AllowedOrigins  []string
+	TrustedProxies  []string
+	ProxyProtocol   bool
+	SessionTTL      int    // 断线后会话保留秒数，0为不启用会话恢复
+	SessionBuffer   int    // 会话缓存的最近发送消息数
+	JobStore        string // 定时任务的存储，redis或postgre
+	ConsolePort     int
+	ConsoleBind     string // 控制台监听地址，默认localhost，不是本机地址时需要认证
+	ConsolePassword string // 控制台密码，为空时只能用有console:admin权限的登录token认证
+	ConsoleCertFile string // 控制台TLS证书
+	ConsoleKeyFile  string
+	ProfilePath     string
+	MetricsAddr     string // 运行指标监听地址，不在HTTPAddr上提供，例如127.0.0.1:9100
+	HTTPAddr        string
+	HTTPCertFile    string
+	HTTPKeyFile     string
 }
 
 func InitServerConfig(confPath string) {
@@ -55,3 +84,34 @@ func InitServerConfig(confPath string) {
 	}
 	log.Release("Server Config: %v \n", &Server)
 }
+
+// 重新读取server.json，应用可以在运行时修改的日志级别
+func ReloadServerConfig(confPath string) error {
+	data, err := ioutil.ReadFile(filepath.Join(confPath, "server.json"))
+	if err != nil {
+		return err
+	}
+	var s struct {
+		LogLevel        string
+		LogModuleLevels map[string]string
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if err := log.SetLevel(s.LogLevel); err != nil {
+		return err
+	}
+	for module := range log.ModuleLevels() { // 配置中删除的模块恢复使用全局级别
+		if _, ok := s.LogModuleLevels[module]; !ok {
+			log.SetModuleLevel(module, "")
+		}
+	}
+	for module, level := range s.LogModuleLevels {
+		if err := log.SetModuleLevel(module, level); err != nil {
+			return err
+		}
+	}
+	Server.LogLevel = s.LogLevel
+	Server.LogModuleLevels = s.LogModuleLevels
+	return nil
+}
@@ -7,4 +7,5 @@ import (
 var (
 	Module  = new(internal.Module)
 	ChanRPC = internal.ChanRPC
+	Jobs    = internal.Jobs
 )
@@ -7,16 +7,22 @@ import (
 func init() {
 	skeleton.RegisterChanRPC("NewAgent", rpcNewAgent)
 	skeleton.RegisterChanRPC("CloseAgent", rpcCloseAgent)
+	skeleton.RegisterChanRPC("ResumeAgent", rpcResumeAgent)
 }
 
 // agent 被创建时
-func rpcNewAgent(args []interface{}) {
-	a := args[0].(gate.Agent)
+func rpcNewAgent(a gate.Agent) {
 	_ = a
 }
 
 // agent 被关闭时
-func rpcCloseAgent(args []interface{}) {
-	a := args[0].(gate.Agent)
+// 启用会话恢复时，在会话超时或被主动关闭后才调用
+func rpcCloseAgent(a gate.Agent) {
+	_ = a
+}
+
+// agent 断线重连恢复会话时
+// agent与断开前是同一个对象，用户数据和房间等状态保持不变
+func rpcResumeAgent(a gate.Agent) {
 	_ = a
 }
@@ -6,8 +6,10 @@ import (
 )
 
 var (
-	skeleton = base.NewSkeleton()
+	skeleton = base.NewSkeleton("game")
 	ChanRPC  = skeleton.ChanRPCServer
+	// 每日重置、赛季切换等持久化的定时任务，在OnInit之前注册处理函数
+	Jobs = base.NewScheduler("game", skeleton)
 )
 
 type Module struct {
@@ -16,8 +18,10 @@ type Module struct {
 
 func (m *Module) OnInit() {
 	m.Skeleton = skeleton
+	Jobs.Store = base.NewJobStore(Jobs.Name)
+	Jobs.Start()
 }
 
 func (m *Module) OnDestroy() {
-
+	Jobs.Stop()
 }
@@ -1,9 +1,17 @@
 package internal
 
 import (
+	"time"
+
+	"github.com/name5566/leaf/db/redis/ban"
 	"github.com/name5566/leaf/gate"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/module"
+	"github.com/name5566/leaf/network"
+	"github.com/name5566/leaf/network/multi"
 	"server/conf"
 	"server/game"
+	"server/login"
 	"server/msg"
 	httpHandler "server/gate/router"
 )
@@ -12,9 +20,19 @@ type Module struct {
 	*gate.Gate
 }
 
+// 消息转发到game和login模块，两者就绪后再开始监听
+func (m *Module) Dependencies() []module.Module {
+	return []module.Module{game.Module, login.Module}
+}
+
 func (m *Module) OnInit() {
+	msg.Processor.Use(gate.Limit(conf.Server.RateLimit)) // 按配置限流
 	m.Gate = &gate.Gate{
 		MaxConnNum:      conf.Server.MaxConnNum,
+		MaxConnPerIP:    conf.Server.MaxConnPerIP,
+		IPFilter:        checkIPBan,
+		AllowedOrigins:  conf.Server.AllowedOrigins,
+		TrustedProxies:  conf.Server.TrustedProxies,
 		PendingWriteNum: conf.PendingWriteNum,
 		MaxMsgLen:       conf.MaxMsgLen,
 		WSAddr:          conf.Server.WSAddr,
@@ -24,11 +42,49 @@ func (m *Module) OnInit() {
 		TCPAddr:         conf.Server.TCPAddr,
 		LenMsgLen:       conf.LenMsgLen,
 		LittleEndian:    conf.LittleEndian,
-		Processor:       msg.Processor,
+		ProxyProtocol:   conf.Server.ProxyProtocol,
+		Processor:       msg.Processor.Get(multi.JSON),
+		WSSubprotocols:  wsSubprotocols(),
+		TCPProcessor:    tcpProcessor(),
 		AgentChanRPC:    game.ChanRPC,
+		ChanRPCTimeout:  conf.ChanRPCTimeout,
 		HTTPAddr:        conf.Server.HTTPAddr,
 		HTTPCertFile:    conf.Server.HTTPCertFile,
 		HTTPKeyFile:     conf.Server.HTTPKeyFile,
 		ServeMux:        *httpHandler.HttpServeMux,
+
+		// 会话恢复
+		SessionTTL:       time.Duration(conf.Server.SessionTTL) * time.Second,
+		SessionBufferLen: conf.Server.SessionBuffer,
+	}
+}
+
+// 拒绝被封禁IP的连接
+func checkIPBan(ip string) bool {
+	if b := ban.CheckIP(ip); b != nil {
+		log.Debug("ip %v is banned: %v", ip, b.Reason)
+		return false
+	}
+	return true
+}
+
+// websocket按Sec-WebSocket-Protocol选择消息格式，未协商时使用json
+// 服务端消息没有实现proto.Message，不提供protobuf
+func wsSubprotocols() map[string]network.Processor {
+	return map[string]network.Processor{
+		multi.JSON:    msg.Processor.Get(multi.JSON),
+		multi.Msgpack: msg.Processor.Get(multi.Msgpack),
+	}
+}
+
+// tcp使用配置的消息格式
+func tcpProcessor() network.Processor {
+	switch conf.Server.TCPCodec {
+	case "", multi.JSON:
+		return msg.Processor.Get(multi.JSON)
+	case multi.Msgpack:
+		return msg.Processor.Get(multi.Msgpack)
 	}
+	log.Fatal("unknown TCPCodec %v", conf.Server.TCPCodec)
+	return nil
 }
@@ -5,18 +5,38 @@ import (
 	"server/login/api_authen"
 	"fmt"
 	"server/api/html"
+	"github.com/name5566/leaf/health"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/gate/user"
+	tk "github.com/name5566/leaf/db/redis/token"
 )
 
 var HttpServeMux = http.NewServeMux()
 
 func init() {
 	initHtmlHttp()
+	initHealthHttp()
+	initAdminHttp()
 }
 
 func initHtmlHttp() {
 	HttpServeMux.HandleFunc("/login", html.ShowLoginTest)
 }
 
+// 存活和就绪检查，失败时返回503
+func initHealthHttp() {
+	HttpServeMux.Handle("/healthz", health.LiveHandler())
+	HttpServeMux.Handle("/readyz", health.ReadyHandler())
+}
+
+// 管理接口需要的权限，AccessKey所属用户需要拥有，拥有all:all或gate:all也可以
+var AdminRight = user.Right("gate", "admin")
+
+// 管理接口，需要AccessKey和SecretKey，并且用户拥有AdminRight
+func initAdminHttp() {
+	HttpServeMux.Handle("/admin/loglevel", httpAuthenMiddleWare(httpRightMiddleWare(AdminRight, log.LevelHandler())))
+}
+
 type AuthKey struct {
 	AccessKey string
 	SecretKey string
@@ -43,4 +63,30 @@ func httpAuthenMiddleWare (handler http.Handler) http.Handler {
 		}
 	}
 	return http.HandlerFunc(ourFunc)
-}
\ No newline at end of file
+}
+
+// 检查AccessKey所属用户的权限，需要在httpAuthenMiddleWare之后
+// 权限为用户最近一次登陆时缓存的
+func httpRightMiddleWare(right string, handler http.Handler) http.Handler {
+	ourFunc := func(w http.ResponseWriter, r *http.Request) {
+		userID, _, _, err := api.GetAccessKey(r.Header.Get("AccessKey"))
+		if err != nil {
+			w.WriteHeader(403)
+			w.Write([]byte(fmt.Sprintf(`{"message": "%s","status": 403}`, err.Error())))
+			return
+		}
+		rights, err := tk.GetUserRights(userID)
+		if err != nil {
+			w.WriteHeader(500)
+			w.Write([]byte(fmt.Sprintf(`{"message": "%s","status": 500}`, err.Error())))
+			return
+		}
+		if !(user.UserData{UserID: userID, Rights: rights}).HasRight(right) {
+			w.WriteHeader(403)
+			w.Write([]byte(fmt.Sprintf(`{"message": "permission denied: %s","status": 403}`, right)))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(ourFunc)
+}
@@ -1,6 +1,7 @@
 package router
 
 import (
+	"github.com/name5566/leaf/gate"
 	"server/msg"
 	"server/login"
 	"server/msg/account"
@@ -9,9 +10,19 @@ import (
 func init() {
 	// 这里指定消息路由到对应的模块
 	// 模块间使用 ChanRPC 通讯，消息路由也不例外
+	initInterceptor()
 	initLogin()
 }
 
+// 消息拦截器，按顺序执行
+func initInterceptor() {
+	msg.Processor.Use(
+		gate.Metrics(),
+		gate.Recover(),
+		gate.AccessLog(),
+	)
+}
+
 func initLogin() {
 	msg.Processor.SetRawHandler("UserCreate", login.HandleLogin)
 	msg.Processor.SetRawHandler("UserQuery", login.HandleLogin)
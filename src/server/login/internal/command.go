@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/name5566/leaf/console"
+	"github.com/name5566/leaf/db/redis/ban"
+	tk "github.com/name5566/leaf/db/redis/token"
+	"github.com/name5566/leaf/gate"
+	"github.com/name5566/leaf/gate/user"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+)
+
+// 控制台命令
+func init() {
+	skeleton.RegisterCommand("ban", "ban an ip/cidr or a user, usage: ban ip|user <target> <duration> [reason]", commandBan)
+	skeleton.RegisterCommand("unban", "unban an ip/cidr or a user, usage: unban ip|user <target>", commandUnban)
+	skeleton.RegisterCommand("banlist", "list banned ips and users", commandBanList)
+	console.RegisterReload("banlist", func() error { // 立即从redis刷新封禁列表并踢掉被封禁的连接
+		ban.Refresh()
+		kickBanned()
+		return nil
+	})
+}
+
+// 命令参数转换为字符串
+func commandArgs(args []interface{}) []string {
+	ret := make([]string, len(args))
+	for i, arg := range args {
+		ret[i] = arg.(string)
+	}
+	return ret
+}
+
+// 封禁IP或用户，并踢掉匹配的在线连接
+// duration为0时永久封禁
+func commandBan(_args []interface{}) interface{} {
+	args := commandArgs(_args)
+	if len(args) < 3 {
+		return "usage: ban ip|user <target> <duration> [reason]"
+	}
+	duration, err := time.ParseDuration(args[2])
+	if err != nil && args[2] != "0" {
+		return fmt.Sprintf("invalid duration %v: %v", args[2], err)
+	}
+	if duration < 0 || duration == 0 && args[2] != "0" { // 只有0为永久封禁，避免-1h之类的输入错误
+		return "usage: ban ip|user <target> <duration> [reason]"
+	}
+	reason := strings.Join(args[3:], " ")
+
+	switch args[0] {
+	case "ip":
+		b, err := ban.BanIP(args[1], duration, reason)
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprintf("banned %v, kicked %d connections on this node, other nodes kick within %v",
+			b.Target, kickBanned(), ban.RefreshInterval)
+	case "user":
+		userID, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Sprintf("invalid user id: %v", args[1])
+		}
+		b, err := ban.BanUser(uint(userID), duration, reason)
+		if err != nil {
+			return err.Error()
+		}
+		if err := tk.CleanSessionID(uint(userID)); err != nil { // 清除用户的session，避免通过cookie重新登陆
+			return err.Error()
+		}
+		return fmt.Sprintf("banned user %v, kicked %d connections on this node, other nodes kick within %v",
+			b.Target, kickBanned(), ban.RefreshInterval)
+	default:
+		return "usage: ban ip|user <target> <duration> [reason]"
+	}
+}
+
+// 踢掉被封禁IP或用户的在线连接，只处理当前节点
+func kickBanned() int {
+	return gate.Kick(func(a gate.Agent) bool {
+		if ban.CheckIP(network.RemoteIP(a.RemoteAddr())) != nil {
+			return true
+		}
+		userData, ok := a.UserData().(user.UserData)
+		return ok && ban.CheckUser(userData.UserID) != nil
+	})
+}
+
+// 定期从redis刷新封禁列表，踢掉在其他节点封禁的在线连接
+// 读取redis在skeleton.Go的goroutine中执行，不阻塞模块goroutine
+func enforceBans() {
+	skeleton.Go(ban.Refresh, func() {
+		if kicked := kickBanned(); kicked > 0 {
+			log.Release("kicked %d banned connections", kicked)
+		}
+		skeleton.AfterFunc(ban.RefreshInterval, enforceBans)
+	})
+}
+
+// 解封IP或用户
+func commandUnban(_args []interface{}) interface{} {
+	args := commandArgs(_args)
+	if len(args) != 2 {
+		return "usage: unban ip|user <target>"
+	}
+
+	var err error
+	switch args[0] {
+	case "ip":
+		err = ban.UnbanIP(args[1])
+	case "user":
+		var userID int
+		userID, err = strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Sprintf("invalid user id: %v", args[1])
+		}
+		err = ban.UnbanUser(uint(userID))
+	default:
+		return "usage: unban ip|user <target>"
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("unbanned %v %v", args[0], args[1])
+}
+
+// 封禁列表
+func commandBanList(_args []interface{}) interface{} {
+	ips, users, err := ban.List()
+	if err != nil {
+		return err.Error()
+	}
+	output := "Banned ips:\r\n"
+	for _, b := range ips {
+		output += formatBan(b) + "\r\n"
+	}
+	output += "Banned users:\r\n"
+	for _, b := range users {
+		output += formatBan(b) + "\r\n"
+	}
+	return strings.TrimSuffix(output, "\r\n")
+}
+
+func formatBan(b *ban.Ban) string {
+	expired := "forever"
+	if !b.Expired.IsZero() {
+		expired = b.Expired.Format("2006/01/02 15:04:05")
+	}
+	return fmt.Sprintf("  %v - expired: %v, reason: %v", b.Target, expired, b.Reason)
+}
@@ -8,13 +8,16 @@ import (
 	"server/msg"
 	"io/ioutil"
 	"encoding/json"
+	"time"
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/util"
 	"github.com/name5566/leaf/gate"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
 	"github.com/name5566/leaf/db/postgre"
 	"github.com/name5566/leaf/db/postgre/model"
 	tk "github.com/name5566/leaf/db/redis/token"
+	"github.com/name5566/leaf/db/redis/ban"
 	"github.com/name5566/leaf/gate/user"
 	"server/login/api_authen"
 	"server/msg/account"
@@ -182,9 +185,8 @@ func HandleLogin(args []interface{}) {
 		return
 	}
 	logServerReq := logServerMap[msgID]
-	fmt.Println(msgID, ok)
+	gate.Logger(a).With(log.F("msg_id", msgID)).Debug("forward to login server")
 	req, ok := args[1].(json.RawMessage)
-	fmt.Println(req, ok)
 	if !ok {
 		msgRes := &msg.Response{Status: 400, Message: "req format is not right"}
 		a.WriteMsg(makeResponse(msgID, msgRes))
@@ -206,7 +208,6 @@ func HandleLogin(args []interface{}) {
 			a.WriteMsg(makeResponse(msgID, msgRes))
 			return
 		}
-		log.Debug("token: %s, login token: %s", token, loginToken)
 		req = addUserID(reqID.UserID, req)
 	}
 
@@ -217,8 +218,9 @@ func HandleLogin(args []interface{}) {
 		a.WriteMsg(makeResponse(msgID, msgRes))
 		return
 	}
-	log.Debug("req send to login server: %s, uri: %s", req, uri)
-	res, err := httpToLoginServer(logServerReq.Method, uri, string(req), loginToken)
+	// 请求中可能有密码，只记录大小
+	gate.Logger(a).With(log.F("msg_id", msgID), log.F("size", len(req))).Debug("send to login server")
+	res, err := httpToLoginServer(logServerReq, uri, string(req), loginToken)
 	if err != nil {
 		msgRes := &msg.Response{Status: 500, Message: string(err.Error())}
 		a.WriteMsg(makeResponse(msgID, msgRes))
@@ -249,14 +251,21 @@ func HandleLogin(args []interface{}) {
 		return
 	}
 	msgRes := parseResBody(res.StatusCode, body, nil)
+	if (msgID == "RightBind" || msgID == "RightUnBind") && res.StatusCode < 300 { // 绑定权限变化，刷新缓存的权限
+		refreshRights(a, loginToken)
+	}
 	a.WriteMsg(makeResponse(msgID, msgRes))
 }
 
+// 登陆服务器接口耗时，endpoint为"Method Uri"，Uri为未填充参数的格式
+var loginServerSeconds = metrics.NewHistogram("login_server_request_seconds", "Latency of login server requests.", nil, "endpoint")
+
 // http请求到登陆服务器
-func httpToLoginServer(method string, uri string, param string, sessionID string) (res *http.Response, err error) {
+func httpToLoginServer(logServerReq LoginServerReq, uri string, param string, sessionID string) (res *http.Response, err error) {
+	defer loginServerSeconds.Since(time.Now(), logServerReq.Method+" "+logServerReq.Uri)
 	client := &http.Client{}
-	fmt.Println(util.UrlJoin(conf.Config.LoginServer, uri))
-	req, err := http.NewRequest(method, util.UrlJoin(conf.Config.LoginServer, uri),
+	log.Debug("login server request: %v %v", logServerReq.Method, logServerReq.Uri) // uri中可能有验证码，只记录格式
+	req, err := http.NewRequest(logServerReq.Method, util.UrlJoin(conf.Config.LoginServer, uri),
 		strings.NewReader(param))
 	if err != nil {
 		return
@@ -284,7 +293,7 @@ func loginResponse(a *gate.Agent, msgID string, res *http.Response) {
 	}
 	cookies := res.Cookies()
 	tokenCookie := getCookie(cookies, "session_id") // 登陆流程需要解析登陆服务器返回cookie中的token("session_id")并存储
-	log.Debug("login server token: %s, max-age: %d\n", tokenCookie.Value, tokenCookie.MaxAge)
+	log.Debug("login server token max-age: %d", tokenCookie.MaxAge)
 	var reqID ReqID
 	err = json.Unmarshal(body, &reqID)
 	if err != nil {
@@ -298,7 +307,12 @@ func loginResponse(a *gate.Agent, msgID string, res *http.Response) {
 		(*a).WriteMsg(makeResponse(msgID, msgRes))
 		return
 	}
-	log.Debug("token MaxAge: %d, Expires: %d\n", tokenCookie.MaxAge, tokenCookie.Expires)
+	if b := ban.CheckUser(reqID.UserID); b != nil { // 封禁用户不能登陆
+		msgRes := &msg.Response{Status: 403, Message: fmt.Sprintf("user is banned: %s", b.Reason)}
+		(*a).WriteMsg(makeResponse(msgID, msgRes))
+		return
+	}
+	log.Debug("token MaxAge: %d, Expires: %v\n", tokenCookie.MaxAge, tokenCookie.Expires)
 	currentToken, err := tk.SetSessionID(reqID.UserID, uint(tokenCookie.MaxAge), tokenCookie.Value) // 生成当前服务的token，并存储登陆服务器token
 	if err != nil {
 		msgRes := &msg.Response{Status: 500, Message: string(err.Error())}
@@ -312,16 +326,88 @@ func loginResponse(a *gate.Agent, msgID string, res *http.Response) {
 		return
 	}
 
+	rights, err := queryRights(reqID.UserID, tokenCookie.Value) // 缓存用户绑定的权限，用于消息鉴权
+	if err != nil {
+		log.Error("query rights of user[%d] failed: %v", reqID.UserID, err)
+	} else if err = tk.SetUserRights(reqID.UserID, rights); err != nil { // 用于AccessKey鉴权
+		log.Error("cache rights of user[%d] failed: %v", reqID.UserID, err)
+	}
+	err = tk.SetSessionRights(currentToken, rights, uint(tokenCookie.MaxAge))
+	if err != nil {
+		log.Error("cache rights of user[%d] failed: %v", reqID.UserID, err)
+	}
+
 	loginData := map[string]interface{}{"token": currentToken, "MaxAge": tokenCookie.MaxAge}
 	(*a).SetUserData(user.UserData{
 		UserID:  reqID.UserID,
 		Token:   currentToken,
 		Expired: util.GetExpiredTime(tokenCookie.MaxAge),
+		Rights:  rights,
 	})
 	msgRes := parseResBody(201, body, loginData)
 	(*a).WriteMsg(makeResponse(msgID, msgRes))
 }
 
+// 登陆服务器返回的用户绑定权限
+type bindRights struct {
+	Data struct {
+		Rights []struct {
+			Server string
+			Name   string
+		}
+	}
+}
+
+// 查询用户在登陆服务器绑定的权限，返回 Server:Name 列表
+func queryRights(userID uint, loginToken string) ([]string, error) {
+	logServerReq := logServerMap["BindRightQuery"]
+	res, err := httpToLoginServer(logServerReq, fmt.Sprintf(logServerReq.Uri, userID), "", loginToken)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("login server status: %d, body: %s", res.StatusCode, body)
+	}
+	var data bindRights
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return nil, err
+	}
+	rights := make([]string, 0, len(data.Data.Rights))
+	for _, right := range data.Data.Rights {
+		rights = append(rights, user.Right(right.Server, right.Name))
+	}
+	return rights, nil
+}
+
+// 刷新当前agent缓存的权限
+func refreshRights(a gate.Agent, loginToken string) {
+	userData, ok := a.UserData().(user.UserData)
+	if !ok {
+		return
+	}
+	rights, err := queryRights(userData.UserID, loginToken)
+	if err != nil {
+		log.Error("refresh rights of user[%d] failed: %v", userData.UserID, err)
+		return
+	}
+	userData.Rights = rights
+	a.SetUserData(userData)
+	err = tk.SetSessionRights(userData.Token, rights, uint(time.Until(userData.Expired).Seconds()))
+	if err != nil {
+		log.Error("cache rights of user[%d] failed: %v", userData.UserID, err)
+	}
+	err = tk.SetUserRights(userData.UserID, rights)
+	if err != nil {
+		log.Error("cache rights of user[%d] failed: %v", userData.UserID, err)
+	}
+}
+
 // 登出流程处理
 func logoutResponse(a *gate.Agent, msgID string, res *http.Response, token string) {
 	body, err := ioutil.ReadAll(res.Body)
@@ -482,17 +568,12 @@ func addUserID(userID uint, rawJson json.RawMessage) (ret json.RawMessage) {
 }
 
 // 创建 api key
-func HandleApiKeyCreate(args []interface{}) {
-	a, userID, _, err := GetUserInfo(args[1])
+func HandleApiKeyCreate(data *account.ApiKeyCreate, agent gate.Agent) {
+	a, userID, _, err := GetUserInfo(agent)
 	if err != nil {
 		a.WriteMsg(&msg.Response{Status: 400, Message: string(err.Error())})
 		return
 	}
-	data, ok := args[0].(*account.ApiKeyCreate)
-	if !ok {
-		a.WriteMsg(&msg.Response{Status: 400, Message: "req for create api key is not right"})
-		return
-	}
 	msgID := msg.GetMsgID(data)
 
 	retKey, err := api.SetAccessKey(userID)
@@ -507,17 +588,12 @@ func HandleApiKeyCreate(args []interface{}) {
 }
 
 // 查询 api key
-func HandleApiKeyQuery(args []interface{}) {
-	a, userID, _, err := GetUserInfo(args[1])
+func HandleApiKeyQuery(data *account.ApiKeyQuery, agent gate.Agent) {
+	a, userID, _, err := GetUserInfo(agent)
 	if err != nil {
 		a.WriteMsg(&msg.Response{Status: 400, Message: string(err.Error())})
 		return
 	}
-	data, ok := args[0].(*account.ApiKeyQuery)
-	if !ok {
-		a.WriteMsg(&msg.Response{Status: 400, Message: "req for create api key is not right"})
-		return
-	}
 	msgID := msg.GetMsgID(data)
 
 	apiKeys, err := api.GetUserAccessKeys(userID)
@@ -531,18 +607,26 @@ func HandleApiKeyQuery(args []interface{}) {
 }
 
 // 删除 api key
-func HandleApiKeyDelete(args []interface{}) {
-	a, _, _, err := GetUserInfo(args[1])
+// 只能删除自己的api key
+func HandleApiKeyDelete(data *account.ApiKeyDelete, agent gate.Agent) {
+	a, userID, _, err := GetUserInfo(agent)
 	if err != nil {
 		a.WriteMsg(&msg.Response{Status: 400, Message: string(err.Error())})
 		return
 	}
-	data, ok := args[0].(*account.ApiKeyDelete)
-	if !ok {
-		a.WriteMsg(&msg.Response{Status: 400, Message: "req for create api key is not right"})
+	msgID := msg.GetMsgID(data)
+
+	keyUserID, _, _, err := api.GetAccessKey(data.AccessKey)
+	if err != nil {
+		msgRes := &msg.Response{Status: 400, Message: string(err.Error())}
+		a.WriteMsg(msg.MakeResponse(msgID, msgRes))
+		return
+	}
+	if keyUserID != userID {
+		msgRes := &msg.Response{Status: 403, Message: "permission denied"}
+		a.WriteMsg(msg.MakeResponse(msgID, msgRes))
 		return
 	}
-	msgID := msg.GetMsgID(data)
 
 	err = api.DelAccessKey(data.AccessKey)
 	if err != nil {
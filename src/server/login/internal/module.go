@@ -7,10 +7,14 @@ import (
 	"fmt"
 	"github.com/name5566/leaf/log"
 	"github.com/name5566/leaf/gate/user"
+	"github.com/name5566/leaf/health"
+	"github.com/name5566/leaf/conf"
+	"net/http"
+	"github.com/name5566/leaf/db/redis/ban"
 )
 
 var (
-	skeleton = base.NewSkeleton()
+	skeleton = base.NewSkeleton("login")
 	ChanRPC  = skeleton.ChanRPCServer
 )
 
@@ -20,6 +24,19 @@ type Module struct {
 
 func (m *Module) OnInit() {
 	m.Skeleton = skeleton
+	health.Register("login_server", checkLoginServer)
+	skeleton.AfterFunc(ban.RefreshInterval, enforceBans)
+}
+
+// 检查登陆服务器是否可达，返回任意http响应即视为可达
+func checkLoginServer() error {
+	client := &http.Client{Timeout: health.Timeout}
+	res, err := client.Get(conf.Config.LoginServer)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
 }
 
 func (m *Module) OnDestroy() {
@@ -32,7 +49,7 @@ func GetUserInfo(arg interface{}) (gate.Agent, uint, string, error) {
 	a, ok := arg.(gate.Agent)
 	if !ok {
 		errMsg := fmt.Sprintf("args %v is not right Agent", a)
-		log.Error(errMsg)
+		log.Error("%v", errMsg)
 		return nil, 0, "", fmt.Errorf(errMsg)
 	}
 	userData1 := a.UserData()
@@ -42,7 +59,7 @@ func GetUserInfo(arg interface{}) (gate.Agent, uint, string, error) {
 	userData, ok := userData1.(user.UserData)
 	if !ok {
 		errMsg := fmt.Sprintf("user data %v is not valid:  ", userData1)
-		log.Error(errMsg)
+		log.Error("%v", errMsg)
 		return a, 0, "", fmt.Errorf(errMsg)
 	}
 	return a, userData.UserID, userData.Token, nil
@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/name5566/leaf"
 	lconf "github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/console"
 	"server/conf"
 	"server/game"
 	"server/gate"
@@ -21,8 +22,26 @@ func main() {
 	lconf.LogLevel = conf.Server.LogLevel
 	lconf.LogPath = conf.Server.LogPath
 	lconf.LogFlag = conf.LogFlag
+	lconf.LogEncoder = conf.Server.LogEncoder
+	lconf.LogModuleLevels = conf.Server.LogModuleLevels
+	lconf.LogMaxSize = conf.Server.LogMaxSize
+	lconf.LogDaily = conf.Server.LogDaily
+	lconf.LogMaxBackups = conf.Server.LogMaxBackups
+	lconf.LogMaxAge = conf.Server.LogMaxAge
+	lconf.LogCompress = conf.Server.LogCompress
 	lconf.ConsolePort = conf.Server.ConsolePort
+	lconf.ConsoleBind = conf.Server.ConsoleBind
+	lconf.ConsolePassword = conf.Server.ConsolePassword
+	lconf.ConsoleCertFile = conf.Server.ConsoleCertFile
+	lconf.ConsoleKeyFile = conf.Server.ConsoleKeyFile
 	lconf.ProfilePath = conf.Server.ProfilePath
+	lconf.MetricsAddr = conf.Server.MetricsAddr
+	lconf.ChanRPCSlowCall = conf.ChanRPCSlowCall
+
+	// 控制台reload命令重新读取server.json中的日志级别
+	console.RegisterReload("server.json", func() error {
+		return conf.ReloadServerConfig(confPath)
+	})
 
 	leaf.Run(
 		game.Module,
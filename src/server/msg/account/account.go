@@ -1,42 +1,180 @@
 // 登陆消息结构
 package account
 
-import "encoding/json"
+import "github.com/name5566/leaf/util"
 
 // 注册登陆服务消息定义
-// 默认为raw json, 直接装发注册服务
-type UserCreate json.RawMessage
-type UserQuery json.RawMessage
-type UserUpdate json.RawMessage
-type UserDelete json.RawMessage
-type RightCreate json.RawMessage
-type RightQuery json.RawMessage
-type RightUpdate json.RawMessage
-type RightDelete json.RawMessage
-type RightBind json.RawMessage
-type RightUnBind json.RawMessage
-type BindRightQuery json.RawMessage
-type Login json.RawMessage
-type Logout json.RawMessage
-type GetUserInfo json.RawMessage
-type VerifyEmailSend json.RawMessage
-type VerifyEmailCheck json.RawMessage
-type NotifyEmailCreate json.RawMessage
-type NotifyEmailQuery json.RawMessage
-type NotifyEmailSend json.RawMessage
-type NotifyEmailDelete json.RawMessage
-type NotifyEmailSub json.RawMessage
-type NotifyEmailUnSub json.RawMessage
-type PwdChange json.RawMessage
-type EmailRestPwdSend json.RawMessage
-type EmailRestPwdCheck json.RawMessage
+// 消息通过原始消息处理函数直接转发登陆服务，结构体用于Processor在转发前按validate tag校验参数
+// 校验规则见util.Validate，UserID由gate根据登陆状态填充
+
+// 用户基本信息
+type BasicInfo struct {
+	LoginName string `validate:"max=32"`
+	Password  string `validate:"max=20"`
+	Mobile    string `validate:"max=20"`
+	Email     string `validate:"email,max=64"`
+	Source    string `validate:"max=32"`
+}
+
+// 用户详细信息
+type UserInfo struct {
+	Name     string `validate:"max=32"`
+	Age      int    `validate:"min=0,max=150"`
+	Birthday string `validate:"datetime=2006-01-02 15:04:05"`
+	Address  string `validate:"max=128"`
+}
+
+// 注册用户基本信息，登录名和密码必填
+type CreateBasicInfo struct {
+	LoginName string `validate:"required,max=32"`
+	Password  string `validate:"required,min=6,max=20"`
+	Mobile    string `validate:"max=20"`
+	Email     string `validate:"email,max=64"`
+	Source    string `validate:"max=32"`
+}
+
+// 权限
+type Right struct {
+	Server string `validate:"required,max=32"`
+	Name   string `validate:"required,max=32"`
+}
+
+type UserCreate struct {
+	BasicInfo CreateBasicInfo `validate:"required"`
+	UserInfo  UserInfo
+}
+
+type UserQuery struct {
+}
+
+type UserUpdate struct {
+	BasicInfo BasicInfo
+	UserInfo  UserInfo
+}
+
+type UserDelete struct {
+}
+
+type RightCreate struct {
+	Server string `validate:"required,max=32"`
+	Name   string `validate:"required,max=32"`
+	Desc   string `validate:"max=256"`
+}
+
+type RightQuery struct {
+}
+
+type RightUpdate struct {
+	RightID uint   `validate:"required"`
+	Server  string `validate:"max=32"`
+	Name    string `validate:"max=32"`
+	Desc    string `validate:"max=256"`
+}
+
+type RightDelete struct {
+	RightID uint `validate:"required"`
+}
+
+type RightBind struct {
+	RightID uint `validate:"required"`
+}
+
+type RightUnBind struct {
+	RightID uint `validate:"required"`
+}
+
+type BindRightQuery struct {
+}
+
+// LoginName，Mobile，Email至少上报一个
+type Login struct {
+	LoginName string `validate:"max=32"`
+	Mobile    string `validate:"max=20"`
+	Email     string `validate:"email,max=64"`
+	Password  string `validate:"required,max=20"`
+	Right     Right
+}
+
+func (m *Login) Validate() util.ValidationErrors {
+	return requireAccount(m.LoginName, m.Mobile, m.Email)
+}
+
+type Logout struct {
+}
+
+type GetUserInfo struct {
+}
+
+type VerifyEmailSend struct {
+}
+
+type VerifyEmailCheck struct {
+	Code string `validate:"required,max=16"`
+}
+
+type NotifyEmailCreate struct {
+	Email      string `validate:"required,email,max=64"`
+	Subscribed bool
+}
+
+type NotifyEmailQuery struct {
+}
+
+type NotifyEmailSend struct {
+	Subject     string `validate:"required,max=128"`
+	ContentType string `validate:"required,max=32"`
+	Content     string `validate:"required"`
+}
+
+type NotifyEmailDelete struct {
+	EmailID uint `validate:"required"`
+}
+
+type NotifyEmailSub struct {
+	EmailID uint `validate:"required"`
+}
+
+type NotifyEmailUnSub struct {
+	EmailID uint `validate:"required"`
+}
+
+type PwdChange struct {
+	OldPW string `validate:"required,max=20"`
+	NewPW string `validate:"required,min=6,max=20"`
+}
+
+// LoginName，Mobile，Email至少上报一个
+type EmailRestPwdSend struct {
+	LoginName string `validate:"max=32"`
+	Mobile    string `validate:"max=20"`
+	Email     string `validate:"email,max=64"`
+	ResetUrl  string `validate:"required,max=256"`
+}
+
+func (m *EmailRestPwdSend) Validate() util.ValidationErrors {
+	return requireAccount(m.LoginName, m.Mobile, m.Email)
+}
+
+type EmailRestPwdCheck struct {
+	UserID uint
+	Code   string `validate:"required,max=16"`
+	NewPW  string `validate:"required,min=6,max=20"`
+}
 
 type ApiKeyCreate struct {
 }
 
 type ApiKeyDelete struct {
-	AccessKey string
+	AccessKey string `validate:"required,max=64"`
 }
 
 type ApiKeyQuery struct {
 }
+
+// LoginName，Mobile，Email至少填写一个
+func requireAccount(loginName, mobile, email string) util.ValidationErrors {
+	if loginName == "" && mobile == "" && email == "" {
+		return util.ValidationErrors{{Field: "LoginName", Message: "one of LoginName, Mobile, Email is required"}}
+	}
+	return nil
+}
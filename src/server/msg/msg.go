@@ -1,19 +1,28 @@
 package msg
 
 import (
-	"github.com/name5566/leaf/network/json"
+	"github.com/name5566/leaf/network"
+	"github.com/name5566/leaf/network/multi"
 	"server/msg/account"
 	"reflect"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/gate/user"
+	"github.com/name5566/leaf/gate"
 )
 
-// 使用默认的 JSON 消息处理器（默认还提供了 protobuf 消息处理器）
-var Processor = json.NewProcessor()
+// 消息同时注册到json、msgpack和protobuf处理器，gate按监听和websocket子协议选择
+var Processor = multi.NewProcessor()
 
 func init() {
 	// 注册Processor支持的msg
 	Processor.Register(&Response{})
+	// 会话恢复
+	Processor.Register(&gate.Session{})
+	Processor.Register(&gate.Resume{})
+	// 控制台broadcast命令发送的公告
+	Processor.Register(&gate.Notice{})
 	registLogin()
+	registAuth()
 }
 
 // 标准返回数据
@@ -83,3 +92,24 @@ func registLogin() {
 	Processor.Register(&account.ApiKeyDelete{})
 	Processor.Register(&account.ApiKeyQuery{})
 }
+
+// 消息权限声明，Processor路由前检查
+// 未声明的消息不需要登陆
+func registAuth() {
+	login := &network.MsgAuth{Login: true}
+	loginAdmin := &network.MsgAuth{Login: true, Rights: []string{user.Right("login", user.RightAll)}} // 登陆服务admin权限
+	for _, msgID := range []string{
+		"UserQuery", "UserUpdate", "UserDelete", "PwdChange",
+		"BindRightQuery",
+		"Logout", "GetUserInfo",
+		"VerifyEmailSend", "VerifyEmailCheck",
+		"NotifyEmailCreate", "NotifyEmailQuery", "NotifyEmailSend", "NotifyEmailDelete", "NotifyEmailSub", "NotifyEmailUnSub",
+		"ApiKeyCreate", "ApiKeyQuery", "ApiKeyDelete",
+	} {
+		Processor.SetAuth(msgID, login)
+	}
+	// 绑定权限会写入当前用户的权限缓存，只允许admin操作
+	for _, msgID := range []string{"RightCreate", "RightQuery", "RightUpdate", "RightDelete", "RightBind", "RightUnBind"} {
+		Processor.SetAuth(msgID, loginAdmin)
+	}
+}
@@ -0,0 +1,78 @@
+// 客户端SDK生成工具
+// 遍历msg.Processor中注册的消息，生成TypeScript客户端和Markdown协议文档
+//
+// 用法(在src目录下):
+//
+//	go run server/tools/sdkgen -out ../sdk
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"server/msg"
+)
+
+// 注册的消息
+type message struct {
+	id    string
+	typ   reflect.Type // 结构体类型
+	auth  *network.MsgAuth
+	push  bool   // 服务器推送的消息，回复即为消息本身，其余消息的回复为Response
+	reply string // 回复为另一个推送消息时为该消息的msgID，例如Resume的回复为Session
+}
+
+func main() {
+	out := flag.String("out", "sdk", "output directory")
+	push := flag.String("push", "Response,Session,Notice", "comma separated msgIDs pushed by server with their own struct as payload")
+	replies := flag.String("replies", "Resume:Session", "comma separated request:reply pairs for requests answered by another pushed msgID")
+	flag.Parse()
+
+	pushIDs := make(map[string]bool)
+	for _, msgID := range strings.Split(*push, ",") {
+		pushIDs[strings.TrimSpace(msgID)] = true
+	}
+	replyIDs := make(map[string]string)
+	for _, pair := range strings.Split(*replies, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) != 2 || !pushIDs[kv[1]] {
+			log.Fatal("invalid reply pair %v, reply must be a pushed msgID", pair)
+		}
+		replyIDs[kv[0]] = kv[1]
+	}
+
+	var msgs []*message
+	msg.Processor.Range(func(msgID string, t reflect.Type) {
+		msgs = append(msgs, &message{
+			id:    msgID,
+			typ:   t.Elem(),
+			auth:  msg.Processor.GetAuth(msgID),
+			push:  pushIDs[msgID],
+			reply: replyIDs[msgID],
+		})
+	})
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		log.Fatal("%v", err)
+	}
+	files := map[string]string{
+		"client.ts":   genTypeScript(msgs),
+		"protocol.md": genMarkdown(msgs),
+	}
+	for name, content := range files {
+		path := filepath.Join(*out, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			log.Fatal("%v", err)
+		}
+		log.Release("generated %v", path)
+	}
+}
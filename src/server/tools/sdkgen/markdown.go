@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/name5566/leaf/network"
+)
+
+// 生成Markdown协议文档
+func genMarkdown(msgs []*message) string {
+	g := newTSTypes()
+	for _, m := range msgs {
+		g.declare(m.typ)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# 协议参考\n\n")
+	buf.WriteString("> 由sdkgen根据msg.Processor中注册的消息生成，请勿手动修改\n\n")
+	buf.WriteString("消息格式为`{msgID: 消息体}`，请求的回复使用相同的msgID，回复的消息体为`{status, message, data}`\n\n")
+
+	buf.WriteString("## 消息列表\n\n")
+	buf.WriteString("msgID | 方向 | 权限\n-- | -- | --\n")
+	for _, m := range msgs {
+		fmt.Fprintf(&buf, "[%v](#%v) | %v | %v\n", m.id, strings.ToLower(m.id), direction(m), authDesc(m.auth))
+	}
+
+	buf.WriteString("\n## 消息\n")
+	for _, m := range msgs {
+		fmt.Fprintf(&buf, "\n### %v\n\n", m.id)
+		fmt.Fprintf(&buf, "`方向`: %v\n\n", direction(m))
+		fmt.Fprintf(&buf, "`权限`: %v\n\n", authDesc(m.auth))
+		if m.reply != "" {
+			fmt.Fprintf(&buf, "`回复`: [%v](#%v)\n\n", m.reply, strings.ToLower(m.reply))
+		}
+		writeFields(&buf, g, m.typ)
+	}
+
+	// 消息字段中使用的结构体
+	var nested bool
+	for _, t := range g.order {
+		isMsg := false
+		for _, m := range msgs {
+			if m.typ == t {
+				isMsg = true
+				break
+			}
+		}
+		if isMsg {
+			continue
+		}
+		if !nested {
+			buf.WriteString("\n## 类型\n")
+			nested = true
+		}
+		fmt.Fprintf(&buf, "\n### %v\n\n", g.names[t])
+		writeFields(&buf, g, t)
+	}
+	return buf.String()
+}
+
+func writeFields(buf *bytes.Buffer, g *tsTypes, t reflect.Type) {
+	fields := structFields(t)
+	if len(fields) == 0 {
+		buf.WriteString("无参数\n")
+		return
+	}
+	buf.WriteString("字段 | 类型 | 必填 | 规则\n-- | -- | -- | --\n")
+	for _, f := range fields {
+		required := "是"
+		if optionalMark(f) != "" {
+			required = "否"
+		}
+		fmt.Fprintf(buf, "%v | `%v` | %v | %v\n", f.name, g.typeOf(f.typ), required, f.rules)
+	}
+}
+
+func direction(m *message) string {
+	if m.push {
+		return "服务器推送"
+	}
+	return "客户端请求"
+}
+
+func authDesc(auth *network.MsgAuth) string {
+	if auth == nil || (!auth.Login && len(auth.Rights) == 0) {
+		return "无"
+	}
+	desc := "需要登录"
+	if len(auth.Rights) > 0 {
+		desc += "，需要权限 " + strings.Join(auth.Rights, " 或 ")
+	}
+	return desc
+}
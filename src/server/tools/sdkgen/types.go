@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/name5566/leaf/log"
+)
+
+// 结构体字段
+type field struct {
+	name     string
+	typ      reflect.Type
+	optional bool
+	rules    string // validate tag
+}
+
+// 结构体导出字段，字段名使用json tag
+// 结构体使用了validate tag时，未标记required的字段为可选
+func structFields(t reflect.Type) []field {
+	validated := false
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("validate") != "" {
+			validated = true
+			break
+		}
+	}
+
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		if f.Anonymous && tag[0] == "" && f.Type.Kind() == reflect.Struct { // 嵌入的结构体字段展开
+			fields = append(fields, structFields(f.Type)...)
+			continue
+		}
+		fd := field{name: f.Name, typ: f.Type, rules: f.Tag.Get("validate")}
+		if tag[0] != "" {
+			fd.name = tag[0]
+		}
+		for _, opt := range tag[1:] {
+			if opt == "omitempty" {
+				fd.optional = true
+			}
+		}
+		if validated && !strings.Contains(","+fd.rules+",", ",required,") {
+			fd.optional = true
+		}
+		fields = append(fields, fd)
+	}
+	return fields
+}
+
+// 生成TypeScript类型，结构体声明为命名的interface
+type tsTypes struct {
+	names map[reflect.Type]string
+	types map[string]reflect.Type
+	order []reflect.Type // 声明顺序
+}
+
+func newTSTypes() *tsTypes {
+	return &tsTypes{
+		names: make(map[reflect.Type]string),
+		types: make(map[string]reflect.Type),
+	}
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// go类型对应的TypeScript类型
+func (g *tsTypes) typeOf(t reflect.Type) string {
+	if t == rawType {
+		return "any"
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.typeOf(t.Elem())
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 { // []byte编码为base64字符串
+			return "string"
+		}
+		elem := g.typeOf(t.Elem())
+		if strings.ContainsAny(elem, " |") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case reflect.Map:
+		return "{ [key: string]: " + g.typeOf(t.Elem()) + " }"
+	case reflect.Struct:
+		if t == timeType {
+			return "string"
+		}
+		if t.Name() == "" {
+			return g.inline(t)
+		}
+		return g.declare(t)
+	default:
+		return "any"
+	}
+}
+
+// 匿名结构体
+func (g *tsTypes) inline(t reflect.Type) string {
+	var parts []string
+	for _, f := range structFields(t) {
+		parts = append(parts, f.name+optionalMark(f)+": "+g.typeOf(f.typ))
+	}
+	if len(parts) == 0 {
+		return "{}"
+	}
+	return "{ " + strings.Join(parts, "; ") + " }"
+}
+
+// 声明命名的结构体，重名时加上包名
+func (g *tsTypes) declare(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if other, ok := g.types[name]; ok && other != t {
+		pkg := t.PkgPath()
+		name = pkg[strings.LastIndex(pkg, "/")+1:] + "_" + name
+		if _, ok := g.types[name]; ok {
+			log.Fatal("duplicate type name %v", name)
+		}
+	}
+	g.names[t] = name
+	g.types[name] = t
+	g.order = append(g.order, t)
+	for _, f := range structFields(t) { // 提前声明字段类型
+		g.typeOf(f.typ)
+	}
+	return name
+}
+
+func optionalMark(f field) string {
+	if f.optional || f.typ.Kind() == reflect.Ptr {
+		return "?"
+	}
+	return ""
+}
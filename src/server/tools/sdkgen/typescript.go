@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// 生成TypeScript客户端
+func genTypeScript(msgs []*message) string {
+	g := newTSTypes()
+	for _, m := range msgs {
+		g.declare(m.typ)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by sdkgen. DO NOT EDIT.\n\n")
+	buf.WriteString(`/** 请求的回复: {msgID: {status, message, data}} */
+export interface Reply<T = any> {
+	status: number;
+	message: any;
+	data: T;
+}
+`)
+
+	// 消息和字段类型
+	for _, t := range g.order {
+		buf.WriteString("\nexport interface " + g.names[t] + " {\n")
+		for _, f := range structFields(t) {
+			if f.rules != "" {
+				buf.WriteString("\t/** " + f.rules + " */\n")
+			}
+			fmt.Fprintf(&buf, "\t%v%v: %v;\n", f.name, optionalMark(f), g.typeOf(f.typ))
+		}
+		buf.WriteString("}\n")
+	}
+
+	buf.WriteString("\n/** 客户端发送的消息 */\nexport interface Requests {\n")
+	for _, m := range msgs {
+		if !m.push {
+			fmt.Fprintf(&buf, "\t%v: %v;\n", m.id, g.names[m.typ])
+		}
+	}
+	buf.WriteString("}\n")
+
+	// 回复为其他msgID的请求(如Resume)不在Incoming中，不能使用request等待回复
+	buf.WriteString("\n/** 服务器发送的消息，请求的回复为Reply，推送的消息为消息本身 */\nexport interface Incoming {\n")
+	for _, m := range msgs {
+		switch {
+		case m.push:
+			fmt.Fprintf(&buf, "\t%v: %v;\n", m.id, g.names[m.typ])
+		case m.reply == "":
+			fmt.Fprintf(&buf, "\t%v: Reply;\n", m.id)
+		}
+	}
+	buf.WriteString("}\n")
+
+	buf.WriteString(tsClient)
+	return buf.String()
+}
+
+// websocket客户端
+const tsClient = `
+export interface ClientOptions {
+	/** websocket子协议，例如"json" */
+	protocols?: string | string[];
+	/** 请求超时毫秒数，默认10000 */
+	timeout?: number;
+	/** 连接断开后重连的间隔毫秒数，0为不重连，默认3000 */
+	reconnectInterval?: number;
+}
+
+type Handler<T> = (msg: T) => void;
+
+interface Pending {
+	resolve: Handler<any>;
+	reject: (err: Error) => void;
+	timer: any;
+}
+
+export class Client {
+	/** 当前会话ID，重连时用于恢复会话 */
+	sessionID = "";
+	/** 收到的最后一条消息序号 */
+	seq = 0;
+	/** 收到Session时调用，resumed为false时表示新会话，需要重新登录 */
+	onsession?: (resumed: boolean) => void;
+	onclose?: (ev: CloseEvent) => void;
+
+	private ws?: WebSocket;
+	private opened = false;
+	private closed = false;
+	private handlers: { [msgID: string]: Handler<any>[] } = {};
+	private pending: { [msgID: string]: Pending[] } = {};
+
+	constructor(private url: string, private options: ClientOptions = {}) {}
+
+	/** 建立连接，已有会话时先发送Resume恢复会话 */
+	connect(): Promise<void> {
+		this.closed = false;
+		return new Promise((resolve, reject) => {
+			const ws = new WebSocket(this.url, this.options.protocols);
+			ws.binaryType = "arraybuffer";
+			ws.onopen = () => {
+				this.ws = ws;
+				this.opened = true;
+				if (this.sessionID) {
+					this.write("Resume", { SessionID: this.sessionID, Seq: this.seq });
+				}
+				resolve();
+			};
+			ws.onerror = () => reject(new Error("websocket error"));
+			ws.onmessage = (ev) => this.dispatch(ev.data);
+			ws.onclose = (ev) => {
+				this.ws = undefined;
+				this.rejectAll(new Error("connection closed"));
+				if (this.onclose) {
+					this.onclose(ev);
+				}
+				const interval = this.options.reconnectInterval === undefined ? 3000 : this.options.reconnectInterval;
+				if (this.opened && !this.closed && interval > 0) {
+					setTimeout(() => this.connect().catch(() => undefined), interval);
+				}
+			};
+		});
+	}
+
+	/** 关闭连接，不再重连 */
+	close(): void {
+		this.closed = true;
+		if (this.ws) {
+			this.ws.close();
+		}
+	}
+
+	/** 发送消息 */
+	send<K extends keyof Requests>(msgID: K, msg: Requests[K]): void {
+		this.write(msgID as string, msg);
+	}
+
+	/** 发送消息并等待同一msgID的回复 */
+	request<K extends keyof Requests & keyof Incoming>(msgID: K, msg: Requests[K], timeout?: number): Promise<Incoming[K]> {
+		const id = msgID as string;
+		return new Promise((resolve, reject) => {
+			const p: Pending = { resolve, reject, timer: undefined };
+			p.timer = setTimeout(() => {
+				this.removePending(id, p);
+				reject(new Error(id + " timeout"));
+			}, timeout || this.options.timeout || 10000);
+			(this.pending[id] = this.pending[id] || []).push(p);
+			try {
+				this.write(id, msg);
+			} catch (err) {
+				clearTimeout(p.timer);
+				this.removePending(id, p);
+				reject(err);
+			}
+		});
+	}
+
+	/** 监听服务器发送的消息，返回取消监听的函数 */
+	on<K extends keyof Incoming>(msgID: K, handler: Handler<Incoming[K]>): () => void {
+		const id = msgID as string;
+		(this.handlers[id] = this.handlers[id] || []).push(handler);
+		return () => {
+			this.handlers[id] = (this.handlers[id] || []).filter((h) => h !== handler);
+		};
+	}
+
+	private write(msgID: string, msg: any): void {
+		if (!this.ws || this.ws.readyState !== WebSocket.OPEN) {
+			throw new Error("not connected");
+		}
+		this.ws.send(JSON.stringify({ [msgID]: msg }));
+	}
+
+	private dispatch(data: ArrayBuffer | string): void {
+		const packet = JSON.parse(typeof data === "string" ? data : new TextDecoder().decode(data));
+		for (const msgID of Object.keys(packet)) {
+			const msg = packet[msgID];
+			if (msgID === "Session") {
+				const resumed = msg.ID === this.sessionID;
+				this.sessionID = msg.ID;
+				this.seq = msg.Seq;
+				if (this.onsession) {
+					this.onsession(resumed);
+				}
+			} else {
+				this.seq++;
+			}
+
+			const waiting = this.pending[msgID];
+			if (waiting && waiting.length > 0) {
+				const p = waiting.shift()!;
+				clearTimeout(p.timer);
+				p.resolve(msg);
+			}
+			for (const h of this.handlers[msgID] || []) {
+				h(msg);
+			}
+		}
+	}
+
+	private removePending(msgID: string, p: Pending): void {
+		this.pending[msgID] = (this.pending[msgID] || []).filter((x) => x !== p);
+	}
+
+	private rejectAll(err: Error): void {
+		for (const msgID of Object.keys(this.pending)) {
+			for (const p of this.pending[msgID]) {
+				clearTimeout(p.timer);
+				p.reject(err);
+			}
+		}
+		this.pending = {};
+	}
+}
+`